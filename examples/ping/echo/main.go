@@ -21,7 +21,7 @@ func main() {
 		panic(err)
 	}
 	// Use middleware
-	e.Use(wall.NewEchoMiddleware(invoiceOptions, lnClient, storageClient, nil))
+	e.Use(wall.NewEchoMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions, nil))
 
 	e.GET("/ping", func(c echo.Context) error {
 		return c.String(http.StatusOK, "pong")
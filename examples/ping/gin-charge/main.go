@@ -23,7 +23,7 @@ func main() {
 		panic(err)
 	}
 	// Use middleware
-	r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient))
+	r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions))
 
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
@@ -28,7 +28,7 @@ func main() {
 		panic(err)
 	}
 	// Use middleware
-	r.Use(wall.NewHandlerMiddleware(invoiceOptions, lnClient, storageClient))
+	r.Use(wall.NewHandlerMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions))
 
 	r.HandleFunc("/ping", pingHandler)
 
@@ -32,7 +32,7 @@ func main() {
 	}
 
 	// Create function that we can use in the middleware chain
-	withPayment := wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, storageClient)
+	withPayment := wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions)
 	// Use a chain of middlewares for the "/ping" endpoint
 	http.HandleFunc("/ping", withLogging(withPayment(pingHandler)))
 
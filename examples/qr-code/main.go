@@ -56,7 +56,7 @@ func main() {
 	}
 
 	// Use middleware
-	r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient))
+	r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions))
 
 	r.GET("/qr", qrHandler)
 
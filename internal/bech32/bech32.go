@@ -0,0 +1,125 @@
+/*
+Package bech32 implements the bech32 encoding as specified in BIP 173, without the length limit of 90 characters,
+because BOLT11 invoices are usually longer than that.
+*/
+package bech32
+
+import (
+	"errors"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Encode encodes the human-readable part and the data (5 bit groups) as bech32 string, including the checksum.
+func Encode(hrp string, data []byte) (string, error) {
+	hrp = strings.ToLower(hrp)
+	result := make([]byte, 0, len(hrp)+1+len(data)+6)
+	result = append(result, hrp...)
+	result = append(result, '1')
+	for _, b := range append(data, createChecksum(hrp, data)...) {
+		if b > 31 {
+			return "", errors.New("The data contains a value that doesn't fit into 5 bits")
+		}
+		result = append(result, charset[b])
+	}
+	return string(result), nil
+}
+
+// Decode decodes a bech32 string into the human-readable part and the data (5 bit groups) and verifies the checksum.
+// The returned data doesn't contain the checksum.
+func Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("The string contains both lower and upper case characters")
+	}
+	s = strings.ToLower(s)
+	separatorIndex := strings.LastIndex(s, "1")
+	if separatorIndex < 1 || separatorIndex+7 > len(s) {
+		return "", nil, errors.New("The string doesn't have a valid separator position")
+	}
+	hrp := s[:separatorIndex]
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, errors.New("The human-readable part contains an invalid character")
+		}
+	}
+	data := make([]byte, 0, len(s)-separatorIndex-1)
+	for _, c := range s[separatorIndex+1:] {
+		index := strings.IndexRune(charset, c)
+		if index == -1 {
+			return "", nil, errors.New("The data part contains an invalid character")
+		}
+		data = append(data, byte(index))
+	}
+	if polymod(append(expandHRP(hrp), data...)) != 1 {
+		return "", nil, errors.New("The checksum is invalid")
+	}
+	return hrp, data[:len(data)-6], nil
+}
+
+// ConvertBits regroups the given data from groups of fromBits bits to groups of toBits bits.
+// If pad is true, the last group is padded with zeros. Otherwise the remaining bits must be zeros and fewer than fromBits.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, b := range data {
+		if uint32(b)>>fromBits != 0 {
+			return nil, errors.New("The data contains a value that doesn't fit into the given number of bits")
+		}
+		acc = acc<<fromBits | uint32(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, errors.New("The data has invalid padding")
+	}
+	return result, nil
+}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := uint(0); i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func expandHRP(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+	return result
+}
+
+func createChecksum(hrp string, data []byte) []byte {
+	values := append(expandHRP(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ 1
+	result := make([]byte, 6)
+	for i := range result {
+		result[i] = byte(mod >> (5 * (5 - uint(i))) & 31)
+	}
+	return result
+}
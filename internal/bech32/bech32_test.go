@@ -0,0 +1,84 @@
+package bech32_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/philippgille/ln-paywall/internal/bech32"
+)
+
+// TestDecodeValid tests if the valid test vectors of BIP 173 are decoded and encoded again without changes,
+// see https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki#test-vectors
+func TestDecodeValid(t *testing.T) {
+	testCases := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	for _, s := range testCases {
+		hrp, data, err := bech32.Decode(s)
+		if err != nil {
+			t.Errorf("An error occurred when decoding %v: %v\n", s, err)
+			continue
+		}
+		actual, err := bech32.Encode(hrp, data)
+		if err != nil {
+			t.Errorf("An error occurred when encoding %v: %v\n", s, err)
+			continue
+		}
+		if actual != strings.ToLower(s) {
+			t.Errorf("Expected %v, but was %v\n", strings.ToLower(s), actual)
+		}
+	}
+}
+
+// TestDecodeInvalid tests if the invalid test vectors of BIP 173 (that aren't only invalid because of their length) lead to an error.
+func TestDecodeInvalid(t *testing.T) {
+	testCases := []string{
+		"\x201nwldj5",
+		"\x7f1axkwrx",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"de1lg7wt\xff",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+		"a12UEL5L",
+	}
+	for _, s := range testCases {
+		_, _, err := bech32.Decode(s)
+		if err == nil {
+			t.Errorf("Expected an error for %q, but was nil\n", s)
+		}
+	}
+}
+
+// TestConvertBits tests if converting from 8 to 5 bits and back leads to the original data.
+func TestConvertBits(t *testing.T) {
+	expected := []byte{0x00, 0x01, 0x02, 0xfe, 0xff}
+	groups, err := bech32.ConvertBits(expected, 8, 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 8 {
+		t.Errorf("Expected %v groups, but was %v\n", 8, len(groups))
+	}
+	actual, err := bech32.ConvertBits(groups, 5, 8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("Expected %v, but was %v\n", expected, actual)
+	}
+
+	_, err = bech32.ConvertBits([]byte{32}, 5, 8, false)
+	if err == nil {
+		t.Errorf("Expected an error for a value that doesn't fit into 5 bits, but was nil\n")
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Package logging contains helpers for the structured logging with log/slog in the wall and ln packages,
+especially for keeping sensitive values like preimages, tokens and invoices out of the logs.
+*/
+package logging
+
+import (
+	"log/slog"
+)
+
+// Redacted is logged instead of secret values.
+const Redacted = "[redacted]"
+
+// shortenedLength is the number of characters of an identifier that are logged when it's shortened.
+const shortenedLength = 8
+
+// Secret returns an attribute for a value that allows whoever knows it to access something,
+// like a preimage, a pass or credit token or a macaroon.
+// Unless logSensitive is true, the value is replaced by Redacted.
+func Secret(key string, value string, logSensitive bool) slog.Attr {
+	if logSensitive || value == "" {
+		return slog.String(key, value)
+	}
+	return slog.String(key, Redacted)
+}
+
+// Identifier returns an attribute for a value that identifies a payment, like a payment hash.
+// Unless logSensitive is true, only the first characters of the value are logged,
+// which is enough for correlating log entries, but not for looking up the full value.
+func Identifier(key string, value string, logSensitive bool) slog.Attr {
+	if logSensitive || len(value) <= shortenedLength {
+		return slog.String(key, value)
+	}
+	return slog.String(key, value[:shortenedLength]+"...")
+}
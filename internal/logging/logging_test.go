@@ -0,0 +1,45 @@
+package logging_test
+
+import (
+	"testing"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// TestSecret tests if secret values are only logged when sensitive values should be logged.
+func TestSecret(t *testing.T) {
+	preimage := "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	testCases := []struct {
+		logSensitive bool
+		expected     string
+	}{
+		{false, logging.Redacted},
+		{true, preimage},
+	}
+	for _, testCase := range testCases {
+		actual := logging.Secret("preimage", preimage, testCase.logSensitive)
+		if actual.Key != "preimage" || actual.Value.String() != testCase.expected {
+			t.Errorf("Expected %v, but was %v\n", testCase.expected, actual)
+		}
+	}
+}
+
+// TestIdentifier tests if identifiers are shortened unless sensitive values should be logged.
+func TestIdentifier(t *testing.T) {
+	paymentHash := "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3"
+	testCases := []struct {
+		value        string
+		logSensitive bool
+		expected     string
+	}{
+		{paymentHash, false, "bf3e0e73..."},
+		{paymentHash, true, paymentHash},
+		{"bf3e0e", false, "bf3e0e"},
+	}
+	for _, testCase := range testCases {
+		actual := logging.Identifier("payment_hash", testCase.value, testCase.logSensitive)
+		if actual.Value.String() != testCase.expected {
+			t.Errorf("Expected %v, but was %v\n", testCase.expected, actual.Value.String())
+		}
+	}
+}
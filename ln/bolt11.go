@@ -0,0 +1,297 @@
+package ln
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+
+	"github.com/philippgille/ln-paywall/internal/bech32"
+)
+
+// DecodedInvoice contains the data of a BOLT11 invoice,
+// see https://github.com/lightningnetwork/lightning-rfc/blob/master/11-payment-encoding.md
+type DecodedInvoice struct {
+	// Network part of the invoice's human-readable prefix,
+	// for example "bc" for mainnet, "tb" for testnet and "bcrt" for regtest.
+	Network string
+	// Amount in Millisatoshis. 0 if the invoice doesn't contain an amount.
+	AmountMsat int64
+	// Amount in Satoshis, rounded up if the invoice's amount contains fractions of a Satoshi.
+	// 0 if the invoice doesn't contain an amount.
+	Amount int64
+	// Hex encoded.
+	PaymentHash string
+	// Hex encoded. Empty if the invoice doesn't contain a payment secret.
+	PaymentSecret string
+	// Short description of the purpose of the payment (memo).
+	Description string
+	// Hex encoded SHA-256 hash of a longer description. Empty if the invoice contains a description instead.
+	DescriptionHash string
+	// Time when the invoice was created.
+	Timestamp time.Time
+	// Duration after the timestamp after which the invoice expires (1 hour if the invoice doesn't contain one).
+	Expiry time.Duration
+	// Hex encoded compressed public key of the payee's node.
+	// Taken from the invoice if it contains it, otherwise recovered from the signature.
+	Payee string
+	// Minimum CLTV expiry of the last hop (18 if the invoice doesn't contain one).
+	MinFinalCLTVExpiry uint64
+	// Routing information for private channels. Each route is a list of hops that lead to the payee.
+	RouteHints [][]HopHint
+	// Numbers of the feature bits that are set, in ascending order.
+	Features []int
+}
+
+// HopHint is a hop of a route hint in a BOLT11 invoice.
+type HopHint struct {
+	// Hex encoded compressed public key of the node at the start of the channel.
+	NodeID string
+	// Short channel ID of the channel.
+	ShortChannelID uint64
+	FeeBaseMsat    uint32
+	// Proportional fee in millionths of the amount.
+	FeeProportionalMillionths uint32
+	CLTVExpiryDelta           uint16
+}
+
+// ExpiresAt returns the time when the invoice expires.
+func (i DecodedInvoice) ExpiresAt() time.Time {
+	return i.Timestamp.Add(i.Expiry)
+}
+
+// Types of the tagged fields of a BOLT11 invoice that are decoded.
+// Fields of other types are skipped.
+const (
+	fieldTypePaymentHash     byte = 1
+	fieldTypeRouteHint       byte = 3
+	fieldTypeFeatures        byte = 5
+	fieldTypeExpiry          byte = 6
+	fieldTypeDescription     byte = 13
+	fieldTypePaymentSecret   byte = 16
+	fieldTypePayee           byte = 19
+	fieldTypeDescriptionHash byte = 23
+	fieldTypeMinFinalCLTV    byte = 24
+)
+
+// hopLength is the length of a hop in a route hint in bytes:
+// 33 bytes public key, 8 bytes short channel ID, 4 bytes base fee, 4 bytes proportional fee and 2 bytes CLTV expiry delta.
+const hopLength = 51
+
+// DecodeInvoice decodes a BOLT11 invoice (optionally with a "lightning:" prefix) and verifies its signature.
+// An error is returned if the invoice isn't a valid BOLT11 invoice,
+// if it doesn't contain a payment hash or if the signature doesn't match the payee.
+// It doesn't check if the invoice expired.
+func DecodeInvoice(invoice string) (DecodedInvoice, error) {
+	result := DecodedInvoice{}
+
+	if strings.HasPrefix(strings.ToLower(invoice), "lightning:") {
+		invoice = invoice[len("lightning:"):]
+	}
+	hrp, data, err := bech32.Decode(invoice)
+	if err != nil {
+		return result, fmt.Errorf("The invoice isn't bech32 encoded: %v", err)
+	}
+	if !strings.HasPrefix(hrp, "ln") {
+		return result, errors.New("The invoice's prefix doesn't start with \"ln\"")
+	}
+	result.Network, result.AmountMsat, err = parseHRP(hrp[len("ln"):])
+	if err != nil {
+		return result, err
+	}
+	// Round up, so that fractions of a Satoshi aren't lost when paying
+	result.Amount = (result.AmountMsat + 999) / 1000
+
+	// 7 groups timestamp + 104 groups signature
+	if len(data) < 7+104 {
+		return result, errors.New("The invoice is too short")
+	}
+	sigGroups := data[len(data)-104:]
+	data = data[:len(data)-104]
+	result.Timestamp = time.Unix(int64(groupsToUint64(data[:7])), 0)
+
+	// Default values
+	result.Expiry = time.Hour
+	result.MinFinalCLTVExpiry = 18
+
+	for fields := data[7:]; len(fields) > 0; {
+		if len(fields) < 3 {
+			return result, errors.New("The invoice contains an incomplete tagged field")
+		}
+		fieldType := fields[0]
+		length := int(groupsToUint64(fields[1:3]))
+		if len(fields) < 3+length {
+			return result, errors.New("The invoice contains a tagged field that's longer than the invoice")
+		}
+		err = result.parseField(fieldType, fields[3:3+length])
+		if err != nil {
+			return result, err
+		}
+		fields = fields[3+length:]
+	}
+	if result.PaymentHash == "" {
+		return result, errors.New("The invoice doesn't contain a payment hash")
+	}
+
+	// Verify the signature, which is over the SHA-256 of the human-readable part and the data (without signature),
+	// with the 5 bit groups of the data converted to bytes.
+	sig, err := bech32.ConvertBits(sigGroups, 5, 8, false)
+	if err != nil {
+		return result, err
+	}
+	recoveryID := sig[64]
+	if recoveryID > 3 {
+		return result, errors.New("The invoice's signature has an invalid recovery ID")
+	}
+	dataBytes, err := bech32.ConvertBits(data, 5, 8, true)
+	if err != nil {
+		return result, err
+	}
+	hash := sha256.Sum256(append([]byte(hrp), dataBytes...))
+	// RecoverCompact expects the recovery ID (+27+4 for compressed keys) in front of R and S
+	compactSig := append([]byte{27 + 4 + recoveryID}, sig[:64]...)
+	pubKey, _, err := ecdsa.RecoverCompact(compactSig, hash[:])
+	if err != nil {
+		return result, fmt.Errorf("The invoice's signature is invalid: %v", err)
+	}
+	payee := hex.EncodeToString(pubKey.SerializeCompressed())
+	if result.Payee != "" && result.Payee != payee {
+		return result, errors.New("The invoice's signature doesn't match the payee")
+	}
+	result.Payee = payee
+
+	return result, nil
+}
+
+// parseField parses the data of a tagged field and sets the corresponding value of the decoded invoice.
+// As required by BOLT11, fields of unknown types and fields with an unexpected length are skipped.
+func (i *DecodedInvoice) parseField(fieldType byte, data []byte) error {
+	switch fieldType {
+	case fieldTypePaymentHash, fieldTypePaymentSecret, fieldTypeDescriptionHash:
+		if len(data) != 52 {
+			return nil
+		}
+		b, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return err
+		}
+		switch fieldType {
+		case fieldTypePaymentHash:
+			i.PaymentHash = hex.EncodeToString(b)
+		case fieldTypePaymentSecret:
+			i.PaymentSecret = hex.EncodeToString(b)
+		default:
+			i.DescriptionHash = hex.EncodeToString(b)
+		}
+	case fieldTypePayee:
+		if len(data) != 53 {
+			return nil
+		}
+		b, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return err
+		}
+		i.Payee = hex.EncodeToString(b)
+	case fieldTypeDescription:
+		b, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return err
+		}
+		i.Description = string(b)
+	case fieldTypeExpiry:
+		i.Expiry = time.Duration(groupsToUint64(data)) * time.Second
+	case fieldTypeMinFinalCLTV:
+		i.MinFinalCLTVExpiry = groupsToUint64(data)
+	case fieldTypeRouteHint:
+		b, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return err
+		}
+		if len(b)%hopLength != 0 {
+			return errors.New("The invoice contains a route hint with an invalid length")
+		}
+		var route []HopHint
+		for ; len(b) > 0; b = b[hopLength:] {
+			route = append(route, HopHint{
+				NodeID:                    hex.EncodeToString(b[:33]),
+				ShortChannelID:            binary.BigEndian.Uint64(b[33:41]),
+				FeeBaseMsat:               binary.BigEndian.Uint32(b[41:45]),
+				FeeProportionalMillionths: binary.BigEndian.Uint32(b[45:49]),
+				CLTVExpiryDelta:           binary.BigEndian.Uint16(b[49:51]),
+			})
+		}
+		i.RouteHints = append(i.RouteHints, route)
+	case fieldTypeFeatures:
+		// The last group contains the bits 0 to 4
+		var features []int
+		for index, group := range data {
+			for bit := 0; bit < 5; bit++ {
+				if group&(1<<uint(bit)) != 0 {
+					features = append(features, (len(data)-1-index)*5+bit)
+				}
+			}
+		}
+		sort.Ints(features)
+		i.Features = features
+	}
+	return nil
+}
+
+// parseHRP parses the network and the amount (in Millisatoshis) from the human-readable part of an invoice
+// without the "ln" prefix, for example "bcrt10u".
+func parseHRP(hrp string) (string, int64, error) {
+	amountIndex := strings.IndexAny(hrp, "0123456789")
+	if amountIndex == -1 {
+		return hrp, 0, nil
+	}
+	network, amountString := hrp[:amountIndex], hrp[amountIndex:]
+	if network == "" {
+		return "", 0, errors.New("The invoice's prefix doesn't contain a network")
+	}
+
+	// Millisatoshis per unit. Without multiplier the unit is 1 Bitcoin = 100,000,000,000 Millisatoshis.
+	multiplier, divisor := int64(100000000000), int64(1)
+	if unit := amountString[len(amountString)-1]; unit < '0' || unit > '9' {
+		amountString = amountString[:len(amountString)-1]
+		switch unit {
+		case 'm':
+			multiplier = 100000000
+		case 'u':
+			multiplier = 100000
+		case 'n':
+			multiplier = 100
+		case 'p':
+			// 1 pico-Bitcoin = 0.1 Millisatoshis
+			multiplier, divisor = 1, 10
+		default:
+			return "", 0, fmt.Errorf("The invoice's prefix contains an unknown multiplier: %v", string(unit))
+		}
+	}
+	amount, err := strconv.ParseInt(amountString, 10, 64)
+	if err != nil || amount <= 0 {
+		return "", 0, fmt.Errorf("The invoice's prefix contains an invalid amount: %v", amountString)
+	}
+	if amount > (1<<63-1)/multiplier {
+		return "", 0, errors.New("The invoice's amount is too high")
+	}
+	if amount%divisor != 0 {
+		return "", 0, errors.New("The invoice's amount contains fractions of a Millisatoshi")
+	}
+	return network, amount * multiplier / divisor, nil
+}
+
+// groupsToUint64 converts big-endian 5 bit groups to an integer.
+func groupsToUint64(groups []byte) uint64 {
+	var result uint64
+	for _, group := range groups {
+		result = result<<5 | uint64(group)
+	}
+	return result
+}
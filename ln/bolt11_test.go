@@ -0,0 +1,159 @@
+package ln_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/ln/lntest"
+)
+
+// Values that all examples in the BOLT11 specification have in common,
+// see https://github.com/lightningnetwork/lightning-rfc/blob/master/11-payment-encoding.md#examples
+const (
+	bolt11PaymentHash   = "0001020304050607080900010203040506070809000102030405060708090102"
+	bolt11PaymentSecret = "1111111111111111111111111111111111111111111111111111111111111111"
+	bolt11Payee         = "03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad"
+)
+
+var bolt11Timestamp = time.Unix(1496314658, 0)
+
+// TestDecodeInvoice tests if examples of the BOLT11 specification are decoded correctly.
+func TestDecodeInvoice(t *testing.T) {
+	testCases := map[string]ln.DecodedInvoice{
+		// Donation of any amount
+		"lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql": {
+			Network:            "bc",
+			PaymentHash:        bolt11PaymentHash,
+			PaymentSecret:      bolt11PaymentSecret,
+			Description:        "Please consider supporting this project",
+			Timestamp:          bolt11Timestamp,
+			Expiry:             time.Hour,
+			Payee:              bolt11Payee,
+			MinFinalCLTVExpiry: 18,
+			Features:           []int{8, 14},
+		},
+		// 2500 micro-Bitcoin for a cup of coffee, expiring in 1 minute
+		"lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh": {
+			Network:            "bc",
+			AmountMsat:         250000000,
+			Amount:             250000,
+			PaymentHash:        bolt11PaymentHash,
+			PaymentSecret:      bolt11PaymentSecret,
+			Description:        "1 cup coffee",
+			Timestamp:          bolt11Timestamp,
+			Expiry:             time.Minute,
+			Payee:              bolt11Payee,
+			MinFinalCLTVExpiry: 18,
+			Features:           []int{8, 14},
+		},
+		// 20 milli-Bitcoin with description hash, fallback address and route hints
+		"lnbc20m1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqhp58yjmdan79s6qqdhdzgynm4zwqd5d7xmw5fk98klysy043l2ahrqsfpp3qjmp7lwpagxun9pygexvgpjdc4jdj85fr9yq20q82gphp2nflc7jtzrcazrra7wwgzxqc8u7754cdlpfrmccae92qgzqvzq2ps8pqqqqqqpqqqqq9qqqvpeuqafqxu92d8lr6fvg0r5gv0heeeqgcrqlnm6jhphu9y00rrhy4grqszsvpcgpy9qqqqqqgqqqqq7qqzq9qrsgqdfjcdk6w3ak5pca9hwfwfh63zrrz06wwfya0ydlzpgzxkn5xagsqz7x9j4jwe7yj7vaf2k9lqsdk45kts2fd0fkr28am0u4w95tt2nsq76cqw0": {
+			Network:            "bc",
+			AmountMsat:         2000000000,
+			Amount:             2000000,
+			PaymentHash:        bolt11PaymentHash,
+			PaymentSecret:      bolt11PaymentSecret,
+			DescriptionHash:    "3925b6f67e2c340036ed12093dd44e0368df1b6ea26c53dbe4811f58fd5db8c1",
+			Timestamp:          bolt11Timestamp,
+			Expiry:             time.Hour,
+			Payee:              bolt11Payee,
+			MinFinalCLTVExpiry: 18,
+			RouteHints: [][]ln.HopHint{{
+				{
+					NodeID:                    "029e03a901b85534ff1e92c43c74431f7ce72046060fcf7a95c37e148f78c77255",
+					ShortChannelID:            0x0102030405060708,
+					FeeBaseMsat:               1,
+					FeeProportionalMillionths: 20,
+					CLTVExpiryDelta:           3,
+				},
+				{
+					NodeID:                    "039e03a901b85534ff1e92c43c74431f7ce72046060fcf7a95c37e148f78c77255",
+					ShortChannelID:            0x030405060708090a,
+					FeeBaseMsat:               2,
+					FeeProportionalMillionths: 30,
+					CLTVExpiryDelta:           4,
+				},
+			}},
+			Features: []int{8, 14},
+		},
+	}
+	for invoice, expected := range testCases {
+		actual, err := ln.DecodeInvoice(invoice)
+		if err != nil {
+			t.Errorf("An error occurred when decoding %v: %v\n", invoice, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %+v, but was %+v\n", expected, actual)
+		}
+	}
+}
+
+// TestDecodeInvoiceFormats tests if upper case invoices and invoices with "lightning:" prefix (like in QR codes) are decoded.
+func TestDecodeInvoiceFormats(t *testing.T) {
+	invoice := "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rh"
+	for _, s := range []string{strings.ToUpper(invoice), "lightning:" + invoice, "LIGHTNING:" + strings.ToUpper(invoice)} {
+		decodedInvoice, err := ln.DecodeInvoice(s)
+		if err != nil {
+			t.Errorf("An error occurred when decoding %v: %v\n", s, err)
+			continue
+		}
+		if decodedInvoice.PaymentHash != bolt11PaymentHash {
+			t.Errorf("Expected %v, but was %v\n", bolt11PaymentHash, decodedInvoice.PaymentHash)
+		}
+	}
+}
+
+// TestDecodeInvoiceInvalid tests if invalid invoices lead to an error.
+func TestDecodeInvoiceInvalid(t *testing.T) {
+	testCases := map[string]string{
+		"empty":            "",
+		"bad checksum":     "lnbc2500u1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpu9qrsgquk0rl77nj30yxdy8j9vdx85fkpmdla2087ne0xh8nhedh8w27kyke0lp53ut353s06fv3qfegext0eh0ymjpf39tuven09sam30g4vgpfna3rq",
+		"not lightning":    "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"fake test string": "lnbc100n1test",
+	}
+	for name, invoice := range testCases {
+		_, err := ln.DecodeInvoice(invoice)
+		if err == nil {
+			t.Errorf("%v: Expected an error, but was nil\n", name)
+		}
+	}
+}
+
+// TestDecodeInvoiceLntest tests if invoices of the lntest.Node are decoded correctly,
+// including amounts that require different multipliers.
+func TestDecodeInvoiceLntest(t *testing.T) {
+	node, err := lntest.NewNode(lntest.DefaultNodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, amount := range []int64{1, 15, 100, 2500, 100000, 123456789} {
+		invoice, err := node.GenerateInvoice(amount, "API call", 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedInvoice, err := ln.DecodeInvoice(invoice.PaymentRequest)
+		if err != nil {
+			t.Errorf("An error occurred when decoding %v: %v\n", invoice.PaymentRequest, err)
+			continue
+		}
+		if decodedInvoice.Network != "bcrt" || decodedInvoice.Amount != amount || decodedInvoice.AmountMsat != amount*1000 {
+			t.Errorf("Expected network %v and amount %v, but was %v and %v\n", "bcrt", amount, decodedInvoice.Network, decodedInvoice.Amount)
+		}
+		if decodedInvoice.PaymentHash != invoice.PaymentHash {
+			t.Errorf("Expected payment hash %v, but was %v\n", invoice.PaymentHash, decodedInvoice.PaymentHash)
+		}
+		if decodedInvoice.Description != "API call" || decodedInvoice.Expiry != 10*time.Minute {
+			t.Errorf("Expected description %v and expiry %v, but was %v and %v\n", "API call", 10*time.Minute, decodedInvoice.Description, decodedInvoice.Expiry)
+		}
+		if decodedInvoice.Payee != node.NodeID() {
+			t.Errorf("Expected payee %v, but was %v\n", node.NodeID(), decodedInvoice.Payee)
+		}
+		if time.Since(decodedInvoice.Timestamp) > time.Minute || decodedInvoice.ExpiresAt() != decodedInvoice.Timestamp.Add(10*time.Minute) {
+			t.Errorf("Unexpected timestamp %v or expiry time %v\n", decodedInvoice.Timestamp, decodedInvoice.ExpiresAt())
+		}
+	}
+}
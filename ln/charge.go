@@ -1,13 +1,18 @@
 package ln
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
 )
 
 // ChargeClient is an implementation of the wall.LNclient interface for "Lightning Charge"
@@ -16,10 +21,17 @@ type ChargeClient struct {
 	client   *http.Client
 	baseURL  string
 	apiToken string
+	logger   *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, Lightning Charge's default (1 hour) is used.
+func (c ChargeClient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
 }
 
-// GenerateInvoice generates an invoice with the given price and memo.
-func (c ChargeClient) GenerateInvoice(amount int64, memo string) (Invoice, error) {
+// GenerateInvoiceContext is like GenerateInvoice, but the request to Lightning Charge is cancelled when the context is done.
+func (c ChargeClient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
 	result := Invoice{}
 
 	data := make(url.Values)
@@ -29,6 +41,9 @@ func (c ChargeClient) GenerateInvoice(amount int64, memo string) (Invoice, error
 	mSatoshi := strconv.FormatInt(1000*amount, 10)
 	data.Add("msatoshi", mSatoshi)
 	data.Add("description", memo)
+	if expiry > 0 {
+		data.Add("expiry", strconv.FormatInt(int64(expiry.Seconds()), 10))
+	}
 
 	// Send request
 	req, err := http.NewRequest("POST", c.baseURL+"/invoice", strings.NewReader(data.Encode()))
@@ -37,8 +52,8 @@ func (c ChargeClient) GenerateInvoice(amount int64, memo string) (Invoice, error
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth("api-token", c.apiToken) // This might seem strange, but it's how Lightning Charge expects it
-	stdOutLogger.Println("Creating invoice for a new API request")
-	res, err := c.client.Do(req)
+	c.logger.Debug("Creating invoice", "amount", amount)
+	res, err := c.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return result, err
 	}
@@ -67,7 +82,12 @@ func (c ChargeClient) GenerateInvoice(amount int64, memo string) (Invoice, error
 // An error is returned if the invoice info couldn't be fetched from Lightning Charge or deserialized etc.
 // False is returned if the invoice isn't settled.
 func (c ChargeClient) CheckInvoice(id string) (bool, error) {
-	stdOutLogger.Printf("Checking invoice %v\n", id)
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the request to Lightning Charge is cancelled when the context is done.
+func (c ChargeClient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	c.logger.Debug("Checking invoice", logging.Identifier("invoice_id", id, false))
 
 	// Fetch invoice
 	req, err := http.NewRequest("GET", c.baseURL+"/invoice/"+id, nil)
@@ -75,7 +95,7 @@ func (c ChargeClient) CheckInvoice(id string) (bool, error) {
 		return false, err
 	}
 	req.SetBasicAuth("api-token", c.apiToken) // This might seem strange, but it's how Lightning Charge expects it
-	res, err := c.client.Do(req)
+	res, err := c.client.Do(req.WithContext(ctx))
 	if err != nil {
 		return false, err
 	}
@@ -117,6 +137,7 @@ func NewChargeClient(chargeOptions ChargeOptions) (ChargeClient, error) {
 	// we can rely on that it's ok to add for example "/invoice" to the baseURL.
 	result.baseURL = strings.TrimSuffix(chargeOptions.Address, "/")
 	result.apiToken = chargeOptions.APItoken
+	result.logger = chargeOptions.Logger
 
 	return result, nil
 }
@@ -129,6 +150,10 @@ type ChargeOptions struct {
 	// APItoken for authenticating the request to Lightning Charge.
 	// The token is configured when Lightning Charge is started.
 	APItoken string
+	// Logger for the client's structured log entries, for example about created invoices.
+	// Invoice IDs are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
 }
 
 // DefaultChargeOptions provides default values for ChargeOptions.
@@ -137,6 +162,9 @@ var DefaultChargeOptions = ChargeOptions{
 }
 
 func assignChargeDefaultValues(chargeOptions ChargeOptions) ChargeOptions {
+	if chargeOptions.Logger == nil {
+		chargeOptions.Logger = slog.Default()
+	}
 	if chargeOptions.Address == "" {
 		chargeOptions.Address = DefaultChargeOptions.Address
 	}
@@ -0,0 +1,304 @@
+package ln
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// clightningErrorCodeInvoiceExpired is the JSON-RPC error code that lightningd returns
+// when an invoice expired while waiting for it with "waitinvoice".
+const clightningErrorCodeInvoiceExpired = 903
+
+// clightningRequestID is incremented for each JSON-RPC request, so that each request has its own ID.
+var clightningRequestID uint64
+
+// CLightningClient is an implementation of the wall.LNclient and pay.LNclient interface
+// for the c-lightning (a.k.a. Core Lightning) Lightning Network node implementation.
+// It talks to lightningd directly via its JSON-RPC unix socket, so no Lightning Charge server is required.
+type CLightningClient struct {
+	socketPath string
+	logger     *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, lightningd's default (1 week) is used.
+func (c CLightningClient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
+}
+
+// GenerateInvoiceContext is like GenerateInvoice, but the call to lightningd is cancelled when the context is done.
+func (c CLightningClient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	result := Invoice{}
+
+	// The label must be unique for each invoice
+	label, err := newCLightningLabel()
+	if err != nil {
+		return result, err
+	}
+	params := map[string]interface{}{
+		"amount_msat": 1000 * amount,
+		"label":       label,
+		"description": memo,
+	}
+	if expiry > 0 {
+		params["expiry"] = int64(expiry.Seconds())
+	}
+
+	c.logger.Debug("Creating invoice", "amount", amount)
+	invoice := clightningInvoice{}
+	err = c.call(ctx, "invoice", params, &invoice)
+	if err != nil {
+		return result, err
+	}
+
+	// c-lightning uses the label to identify invoices, so it's the ID
+	result.ImplDepID = label
+	result.PaymentHash = invoice.PaymentHash
+	result.PaymentRequest = invoice.Bolt11
+	return result, nil
+}
+
+// CheckInvoice takes an invoice ID (LN node implementation specific) and checks if the corresponding invoice was settled.
+// An error is returned if no corresponding invoice was found.
+// False is returned if the invoice isn't settled.
+func (c CLightningClient) CheckInvoice(id string) (bool, error) {
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the call to lightningd is cancelled when the context is done.
+func (c CLightningClient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	// In the case of c-lightning, the ID is the label of the invoice.
+	c.logger.Debug("Checking invoice", logging.Identifier("invoice_id", id, false))
+
+	params := map[string]interface{}{
+		"label": id,
+	}
+	invoices := clightningInvoices{}
+	err := c.call(ctx, "listinvoices", params, &invoices)
+	if err != nil {
+		return false, err
+	}
+	if len(invoices.Invoices) == 0 {
+		return false, fmt.Errorf("unable to locate invoice with label %v", id)
+	}
+
+	return invoices.Invoices[0].isPaid()
+}
+
+// WaitInvoice takes an invoice ID (LN node implementation specific) and waits until the corresponding invoice
+// is either settled or expired. True is returned if it was settled, false if it expired.
+// An error is returned if no corresponding invoice was found.
+func (c CLightningClient) WaitInvoice(id string) (bool, error) {
+	return c.WaitInvoiceContext(context.Background(), id)
+}
+
+// WaitInvoiceContext is like WaitInvoice, but waiting is cancelled when the context is done.
+func (c CLightningClient) WaitInvoiceContext(ctx context.Context, id string) (bool, error) {
+	params := map[string]interface{}{
+		"label": id,
+	}
+	invoice := clightningInvoice{}
+	err := c.call(ctx, "waitinvoice", params, &invoice)
+	if err != nil {
+		if rpcErr, ok := err.(clightningError); ok && rpcErr.Code == clightningErrorCodeInvoiceExpired {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return invoice.isPaid()
+}
+
+// Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
+func (c CLightningClient) Pay(invoice string) (string, error) {
+	return c.PayContext(context.Background(), invoice)
+}
+
+// PayContext is like Pay, but the call to lightningd is cancelled when the context is done.
+// Note that the payment might still succeed if the context is done while the payment is in flight.
+func (c CLightningClient) PayContext(ctx context.Context, invoice string) (string, error) {
+	params := map[string]interface{}{
+		"bolt11": invoice,
+	}
+	logPayment(c.logger, invoice)
+	payment := clightningPayment{}
+	err := c.call(ctx, "pay", params, &payment)
+	if err != nil {
+		return "", err
+	}
+	if payment.Status != "complete" {
+		return "", errors.New("The payment wasn't completed, its status is: " + payment.Status)
+	}
+
+	return payment.PaymentPreimage, nil
+}
+
+// call sends a JSON-RPC request with the given method and params to lightningd
+// and populates the fields of the object that result points to with the values of the response's result.
+// A new connection is used for each request, so concurrent calls don't interfere with each other.
+// When the context is done, the connection is closed and the context's error is returned.
+func (c CLightningClient) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Closing the connection unblocks writing the request and reading the response
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	req := clightningRequest{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&clightningRequestID, 1),
+		Method:  method,
+		Params:  params,
+	}
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	res := clightningResponse{}
+	err = json.NewDecoder(conn).Decode(&res)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if res.Error != nil {
+		return *res.Error
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
+
+// NewCLightningClient creates a new CLightningClient instance.
+func NewCLightningClient(clightningOptions CLightningOptions) (CLightningClient, error) {
+	result := CLightningClient{}
+
+	clightningOptions = assignCLightningDefaultValues(clightningOptions)
+
+	result.socketPath = clightningOptions.SocketPath
+	result.logger = clightningOptions.Logger
+
+	return result, nil
+}
+
+// CLightningOptions are the options for the connection to the c-lightning node.
+type CLightningOptions struct {
+	// Path to the JSON-RPC unix socket of lightningd, which is called "lightning-rpc"
+	// and usually located in the network directory within lightningd's data directory,
+	// e.g. "/home/user/.lightning/bitcoin/lightning-rpc".
+	// Optional ("lightning-rpc" by default).
+	SocketPath string
+	// Logger for the client's structured log entries, for example about sent payments.
+	// Invoices and payment hashes are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+}
+
+// DefaultCLightningOptions provides default values for CLightningOptions.
+var DefaultCLightningOptions = CLightningOptions{
+	SocketPath: "lightning-rpc",
+}
+
+func assignCLightningDefaultValues(clightningOptions CLightningOptions) CLightningOptions {
+	if clightningOptions.Logger == nil {
+		clightningOptions.Logger = slog.Default()
+	}
+	if clightningOptions.SocketPath == "" {
+		clightningOptions.SocketPath = DefaultCLightningOptions.SocketPath
+	}
+
+	return clightningOptions
+}
+
+// newCLightningLabel generates a random label for an invoice.
+func newCLightningLabel() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return "ln-paywall-" + hex.EncodeToString(randomBytes), nil
+}
+
+// clightningRequest is a JSON-RPC 2.0 request to lightningd.
+type clightningRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// clightningResponse is a JSON-RPC 2.0 response from lightningd.
+// Either Result or Error is set.
+type clightningResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      uint64           `json:"id"`
+	Result  json.RawMessage  `json:"result"`
+	Error   *clightningError `json:"error"`
+}
+
+// clightningError is the error object of a JSON-RPC 2.0 response from lightningd.
+type clightningError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e clightningError) Error() string {
+	return fmt.Sprintf("c-lightning returned error %v: %v", e.Code, e.Message)
+}
+
+// clightningInvoice contains the fields of an invoice that are returned by lightningd's
+// "invoice", "listinvoices" and "waitinvoice" commands and are relevant to us.
+type clightningInvoice struct {
+	Label       string `json:"label"`
+	PaymentHash string `json:"payment_hash"`
+	Bolt11      string `json:"bolt11"`
+	Status      string `json:"status"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// isPaid returns true if the invoice's status is "paid" and false if it's "unpaid" or "expired".
+func (i clightningInvoice) isPaid() (bool, error) {
+	switch i.Status {
+	case "paid":
+		return true, nil
+	case "unpaid", "expired":
+		return false, nil
+	default:
+		return false, errors.New("The invoice found in c-lightning has an unknown / unhandled status: " + i.Status)
+	}
+}
+
+// clightningInvoices is the result of lightningd's "listinvoices" command.
+type clightningInvoices struct {
+	Invoices []clightningInvoice `json:"invoices"`
+}
+
+// clightningPayment contains the fields of the result of lightningd's "pay" command that are relevant to us.
+type clightningPayment struct {
+	PaymentPreimage string `json:"payment_preimage"`
+	Status          string `json:"status"`
+}
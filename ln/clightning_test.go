@@ -0,0 +1,257 @@
+package ln_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestCLightningClientImpl tests if the CLightningClient struct implements the wall.LNclient and pay.LNclient interfaces.
+// This doesn't happen at runtime, but at compile time.
+func TestCLightningClientImpl(t *testing.T) {
+	t.SkipNow()
+	clightningClient := ln.CLightningClient{}
+	wall.NewHandlerFuncMiddleware(wall.InvoiceOptions{}, clightningClient, nil, wall.MiddlewareOptions{})
+	pay.NewClient(nil, clightningClient)
+}
+
+// fakeLightningd is a JSON-RPC server on a unix socket that behaves like lightningd for the given methods.
+// Each method handler gets the request params and returns either a result or an error object.
+type fakeLightningd struct {
+	socketPath string
+	listener   net.Listener
+	handlers   map[string]func(params map[string]interface{}) (interface{}, map[string]interface{})
+	// Params of the last request, by method
+	params map[string]map[string]interface{}
+	lock   sync.Mutex
+}
+
+func startFakeLightningd(t *testing.T, handlers map[string]func(params map[string]interface{}) (interface{}, map[string]interface{})) *fakeLightningd {
+	socketPath := os.TempDir() + "/" + strconv.FormatInt(rand.Int63(), 10) + ".sock"
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeLightningd{
+		socketPath: socketPath,
+		listener:   listener,
+		handlers:   handlers,
+		params:     make(map[string]map[string]interface{}),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.handle(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLightningd) handle(conn net.Conn) {
+	defer conn.Close()
+	var req struct {
+		JSONRPC string                 `json:"jsonrpc"`
+		ID      uint64                 `json:"id"`
+		Method  string                 `json:"method"`
+		Params  map[string]interface{} `json:"params"`
+	}
+	err := json.NewDecoder(conn).Decode(&req)
+	if err != nil {
+		return
+	}
+	f.lock.Lock()
+	f.params[req.Method] = req.Params
+	f.lock.Unlock()
+	res := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+	}
+	handler, ok := f.handlers[req.Method]
+	if !ok {
+		res["error"] = map[string]interface{}{"code": -32601, "message": "Unknown command '" + req.Method + "'"}
+	} else if result, rpcErr := handler(req.Params); rpcErr != nil {
+		res["error"] = rpcErr
+	} else {
+		res["result"] = result
+	}
+	json.NewEncoder(conn).Encode(res)
+}
+
+func (f *fakeLightningd) getParams(method string) map[string]interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.params[method]
+}
+
+func (f *fakeLightningd) close() {
+	f.listener.Close()
+	os.Remove(f.socketPath)
+}
+
+// TestCLightningClientGenerateInvoice tests if the invoice is created with the correct params
+// and if the result is converted properly.
+func TestCLightningClientGenerateInvoice(t *testing.T) {
+	f := startFakeLightningd(t, map[string]func(map[string]interface{}) (interface{}, map[string]interface{}){
+		"invoice": func(params map[string]interface{}) (interface{}, map[string]interface{}) {
+			return map[string]interface{}{
+				"payment_hash": "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3",
+				"expires_at":   1536432505,
+				"bolt11":       "lntb10n1pdegzmf",
+			}, nil
+		},
+	})
+	defer f.close()
+	clightningClient, err := ln.NewCLightningClient(ln.CLightningOptions{SocketPath: f.socketPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := clightningClient.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	if invoice.PaymentHash != "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3" {
+		t.Errorf("Unexpected payment hash: %v\n", invoice.PaymentHash)
+	}
+	if invoice.PaymentRequest != "lntb10n1pdegzmf" {
+		t.Errorf("Unexpected payment request: %v\n", invoice.PaymentRequest)
+	}
+	params := f.getParams("invoice")
+	if params["amount_msat"] != float64(10000) {
+		t.Errorf("Expected amount_msat %v, but was %v\n", 10000, params["amount_msat"])
+	}
+	if params["description"] != "API call" {
+		t.Errorf("Expected description %v, but was %v\n", "API call", params["description"])
+	}
+	if params["expiry"] != float64(3600) {
+		t.Errorf("Expected expiry %v, but was %v\n", 3600, params["expiry"])
+	}
+	if params["label"] == "" || params["label"] != invoice.ImplDepID {
+		t.Errorf("Expected the label %v to be the invoice ID %v\n", params["label"], invoice.ImplDepID)
+	}
+}
+
+// TestCLightningClientCheckInvoice tests if the status of the invoice is interpreted properly.
+func TestCLightningClientCheckInvoice(t *testing.T) {
+	statuses := map[string]string{
+		"paid-invoice":    "paid",
+		"unpaid-invoice":  "unpaid",
+		"expired-invoice": "expired",
+	}
+	f := startFakeLightningd(t, map[string]func(map[string]interface{}) (interface{}, map[string]interface{}){
+		"listinvoices": func(params map[string]interface{}) (interface{}, map[string]interface{}) {
+			invoices := []interface{}{}
+			label := params["label"].(string)
+			if status, ok := statuses[label]; ok {
+				invoices = append(invoices, map[string]interface{}{
+					"label":  label,
+					"status": status,
+				})
+			}
+			return map[string]interface{}{"invoices": invoices}, nil
+		},
+	})
+	defer f.close()
+	clightningClient, _ := ln.NewCLightningClient(ln.CLightningOptions{SocketPath: f.socketPath})
+
+	for label, status := range statuses {
+		settled, err := clightningClient.CheckInvoice(label)
+		if err != nil {
+			t.Errorf("An error occurred during the test: %v\n", err)
+		}
+		if settled != (status == "paid") {
+			t.Errorf("Expected settled to be %v for status %v, but was %v\n", status == "paid", status, settled)
+		}
+	}
+
+	_, err := clightningClient.CheckInvoice("unknown-invoice")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown invoice, but was nil\n")
+	}
+}
+
+// TestCLightningClientWaitInvoice tests if an invoice that expired during the wait is reported as not settled.
+func TestCLightningClientWaitInvoice(t *testing.T) {
+	f := startFakeLightningd(t, map[string]func(map[string]interface{}) (interface{}, map[string]interface{}){
+		"waitinvoice": func(params map[string]interface{}) (interface{}, map[string]interface{}) {
+			if params["label"] == "paid-invoice" {
+				return map[string]interface{}{"label": "paid-invoice", "status": "paid"}, nil
+			}
+			return nil, map[string]interface{}{"code": 903, "message": "Invoice expired during wait"}
+		},
+	})
+	defer f.close()
+	clightningClient, _ := ln.NewCLightningClient(ln.CLightningOptions{SocketPath: f.socketPath})
+
+	settled, err := clightningClient.WaitInvoice("paid-invoice")
+	if err != nil || !settled {
+		t.Errorf("Expected (true, nil), but was (%v, %v)\n", settled, err)
+	}
+	settled, err = clightningClient.WaitInvoice("expired-invoice")
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+}
+
+// TestCLightningClientWaitInvoiceContext tests if waiting for an invoice stops when the context is done.
+func TestCLightningClientWaitInvoiceContext(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	f := startFakeLightningd(t, map[string]func(map[string]interface{}) (interface{}, map[string]interface{}){
+		"waitinvoice": func(params map[string]interface{}) (interface{}, map[string]interface{}) {
+			// Never paid and never expired during the test
+			<-stop
+			return nil, map[string]interface{}{"code": 903, "message": "Invoice expired during wait"}
+		},
+	})
+	defer f.close()
+	clightningClient, _ := ln.NewCLightningClient(ln.CLightningOptions{SocketPath: f.socketPath})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	settled, err := clightningClient.WaitInvoiceContext(ctx, "unpaid-invoice")
+	if err != context.DeadlineExceeded || settled {
+		t.Errorf("Expected (false, %v), but was (%v, %v)\n", context.DeadlineExceeded, settled, err)
+	}
+}
+
+// TestCLightningClientPay tests if the preimage is returned for successful payments and an error for failed ones.
+func TestCLightningClientPay(t *testing.T) {
+	expected := "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	f := startFakeLightningd(t, map[string]func(map[string]interface{}) (interface{}, map[string]interface{}){
+		"pay": func(params map[string]interface{}) (interface{}, map[string]interface{}) {
+			if params["bolt11"] == "lntb10n1pdegzmf" {
+				return map[string]interface{}{"payment_preimage": expected, "status": "complete"}, nil
+			}
+			return nil, map[string]interface{}{"code": 210, "message": "Ran out of routes to try"}
+		},
+	})
+	defer f.close()
+	clightningClient, _ := ln.NewCLightningClient(ln.CLightningOptions{SocketPath: f.socketPath})
+
+	actual, err := clightningClient.Pay("lntb10n1pdegzmf")
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, actual)
+	}
+
+	_, err = clightningClient.Pay("lntb20n1unroutable")
+	if err == nil {
+		t.Errorf("Expected an error for a failed payment, but was nil\n")
+	}
+}
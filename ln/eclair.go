@@ -0,0 +1,282 @@
+package ln
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// eclairPaymentPollInterval is the interval in which the status of an outgoing payment is fetched
+// until the payment either succeeded or failed.
+const eclairPaymentPollInterval = 500 * time.Millisecond
+
+// eclairNotFoundError is the error message of eclair's "404 Not Found" responses for unknown payment hashes,
+// as opposed to for example unknown endpoints.
+const eclairNotFoundError = "Not found"
+
+// EclairClient is an implementation of the wall.LNclient and pay.LNclient interface
+// for the eclair Lightning Network node implementation.
+// It uses eclair's HTTP API.
+type EclairClient struct {
+	client     *http.Client
+	baseURL    string
+	password   string
+	payTimeout time.Duration
+	logger     *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, eclair's default (1 hour) is used.
+func (c EclairClient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
+}
+
+// GenerateInvoiceContext is like GenerateInvoice, but the requests to eclair are cancelled when the context is done.
+func (c EclairClient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	result := Invoice{}
+
+	data := make(url.Values)
+	data.Add("amountMsat", strconv.FormatInt(1000*amount, 10))
+	data.Add("description", memo)
+	if expiry > 0 {
+		data.Add("expireIn", strconv.FormatInt(int64(expiry.Seconds()), 10))
+	}
+
+	c.logger.Debug("Creating invoice", "amount", amount)
+	invoice := eclairInvoice{}
+	err := c.post(ctx, "/createinvoice", data, &invoice)
+	if err != nil {
+		return result, err
+	}
+
+	// eclair uses the payment hash to identify invoices
+	result.ImplDepID = invoice.PaymentHash
+	result.PaymentHash = invoice.PaymentHash
+	result.PaymentRequest = invoice.Serialized
+	return result, nil
+}
+
+// CheckInvoice takes an invoice ID (LN node implementation specific) and checks if the corresponding invoice was settled.
+// An error is returned if no corresponding invoice was found.
+// False is returned if the invoice isn't settled.
+func (c EclairClient) CheckInvoice(id string) (bool, error) {
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the requests to eclair are cancelled when the context is done.
+func (c EclairClient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	// In the case of eclair, the ID is the hex encoded payment hash.
+	c.logger.Debug("Checking invoice", logging.Identifier("payment_hash", id, false))
+
+	data := make(url.Values)
+	data.Add("paymentHash", id)
+	receivedInfo := eclairReceivedInfo{}
+	err := c.post(ctx, "/getreceivedinfo", data, &receivedInfo)
+	if err != nil {
+		return false, err
+	}
+
+	switch receivedInfo.Status.Type {
+	case "received":
+		return true, nil
+	case "pending", "expired":
+		return false, nil
+	default:
+		return false, errors.New("The invoice found in eclair has an unknown / unhandled status: " + receivedInfo.Status.Type)
+	}
+}
+
+// Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
+// eclair sends payments asynchronously, so this method polls the status of the payment until it either succeeded or failed,
+// but at most for the duration of EclairOptions.PayTimeout.
+func (c EclairClient) Pay(invoice string) (string, error) {
+	return c.PayContext(context.Background(), invoice)
+}
+
+// PayContext is like Pay, but the requests to eclair and the polling are cancelled when the context is done.
+// Note that the payment might still succeed if the context is done while the payment is in flight.
+func (c EclairClient) PayContext(ctx context.Context, invoice string) (string, error) {
+	data := make(url.Values)
+	data.Add("invoice", invoice)
+	logPayment(c.logger, invoice)
+	var paymentID string
+	err := c.post(ctx, "/payinvoice", data, &paymentID)
+	if err != nil {
+		return "", err
+	}
+
+	data = make(url.Values)
+	data.Add("id", paymentID)
+	timeout := time.After(c.payTimeout)
+	for {
+		// A payment can be split into multiple parts, which all have the same ID as parent ID
+		var sentInfos []eclairSentInfo
+		err = c.post(ctx, "/getsentinfo", data, &sentInfos)
+		if err != nil {
+			return "", err
+		}
+		failedCount := 0
+		for _, sentInfo := range sentInfos {
+			switch sentInfo.Status.Type {
+			case "sent":
+				return sentInfo.Status.PaymentPreimage, nil
+			case "failed":
+				failedCount++
+			}
+		}
+		if len(sentInfos) > 0 && failedCount == len(sentInfos) {
+			return "", errors.New("The payment failed")
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("The payment neither succeeded nor failed within %v, but it might still succeed later", c.payTimeout)
+		case <-time.After(eclairPaymentPollInterval):
+		}
+	}
+}
+
+// post sends a POST request with the given context and form data to the given eclair API endpoint
+// and populates the fields of the object that result points to with the values of the response's JSON.
+func (c EclairClient) post(ctx context.Context, endpoint string, data url.Values, result interface{}) error {
+	req, err := http.NewRequest("POST", c.baseURL+endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("", c.password) // eclair only uses the password
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	err = res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		apiError := eclairError{}
+		// If the body isn't the expected JSON, the error message is empty
+		json.Unmarshal(body, &apiError)
+		if res.StatusCode == http.StatusNotFound && strings.EqualFold(apiError.Error, eclairNotFoundError) {
+			return fmt.Errorf("unable to locate invoice: %v", apiError.Error)
+		}
+		return fmt.Errorf("eclair responded with status %v: %s", res.Status, body)
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+// NewEclairClient creates a new EclairClient instance.
+func NewEclairClient(eclairOptions EclairOptions) (EclairClient, error) {
+	result := EclairClient{}
+
+	eclairOptions = assignEclairDefaultValues(eclairOptions)
+
+	result.client = http.DefaultClient
+	// Make sure the address doesn't end with "/", so that in the other functions
+	// we can rely on that it's ok to add for example "/createinvoice" to the baseURL.
+	result.baseURL = strings.TrimSuffix(eclairOptions.Address, "/")
+	result.password = eclairOptions.Password
+	result.payTimeout = eclairOptions.PayTimeout
+	result.logger = eclairOptions.Logger
+
+	return result, nil
+}
+
+// EclairOptions are the options for the connection to the eclair node.
+type EclairOptions struct {
+	// Address of eclair's HTTP API, including the protocol (e.g. "https://") and port.
+	// Optional ("http://localhost:8080" by default).
+	Address string
+	// Password for authenticating the requests to eclair's API.
+	// The password is configured with "eclair.api.password" in eclair's config file.
+	Password string
+	// Maximum duration that Pay waits for an outgoing payment to either succeed or fail.
+	// Values below 1 millisecond are automatically changed to the default value.
+	// Optional (1 minute by default).
+	PayTimeout time.Duration
+	// Logger for the client's structured log entries, for example about sent payments.
+	// Invoices and payment hashes are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+}
+
+// DefaultEclairOptions provides default values for EclairOptions.
+var DefaultEclairOptions = EclairOptions{
+	Address:    "http://localhost:8080",
+	PayTimeout: time.Minute,
+}
+
+func assignEclairDefaultValues(eclairOptions EclairOptions) EclairOptions {
+	if eclairOptions.Logger == nil {
+		eclairOptions.Logger = slog.Default()
+	}
+	if eclairOptions.Address == "" {
+		eclairOptions.Address = DefaultEclairOptions.Address
+	}
+	if eclairOptions.PayTimeout < time.Millisecond {
+		eclairOptions.PayTimeout = DefaultEclairOptions.PayTimeout
+	}
+
+	return eclairOptions
+}
+
+// eclairInvoice contains the fields of the invoice JSON from eclair's "createinvoice" endpoint that are relevant to us.
+type eclairInvoice struct {
+	PaymentHash string `json:"paymentHash"`
+	Serialized  string `json:"serialized"`
+	Expiry      int64  `json:"expiry"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+// eclairReceivedInfo contains the fields of the JSON from eclair's "getreceivedinfo" endpoint that are relevant to us.
+//
+// Example JSON (shortened):
+//
+//	{
+//	  "paymentPreimage": "0000000000000000000000000000000000000000000000000000000000000000",
+//	  "paymentType": "Standard",
+//	  "createdAt": { "iso": "2022-02-01T12:40:19.309Z", "unix": 1643719219 },
+//	  "status": { "type": "received", "amount": 1000000, "receivedAt": { "iso": "2022-02-01T12:40:19.438Z", "unix": 1643719219 } }
+//	}
+type eclairReceivedInfo struct {
+	PaymentPreimage string `json:"paymentPreimage"`
+	Status          struct {
+		// "pending", "expired" or "received"
+		Type string `json:"type"`
+	} `json:"status"`
+}
+
+// eclairSentInfo contains the fields of an element of the JSON array from eclair's "getsentinfo" endpoint
+// that are relevant to us.
+type eclairSentInfo struct {
+	ID          string `json:"id"`
+	PaymentHash string `json:"paymentHash"`
+	Status      struct {
+		// "pending", "failed" or "sent"
+		Type            string `json:"type"`
+		PaymentPreimage string `json:"paymentPreimage"`
+	} `json:"status"`
+}
+
+// eclairError is the JSON that eclair responds with in case of an error.
+type eclairError struct {
+	Error string `json:"error"`
+}
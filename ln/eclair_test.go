@@ -0,0 +1,214 @@
+package ln_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestEclairClientImpl tests if the EclairClient struct implements the wall.LNclient and pay.LNclient interfaces.
+// This doesn't happen at runtime, but at compile time.
+func TestEclairClientImpl(t *testing.T) {
+	t.SkipNow()
+	eclairClient := ln.EclairClient{}
+	wall.NewHandlerFuncMiddleware(wall.InvoiceOptions{}, eclairClient, nil, wall.MiddlewareOptions{})
+	pay.NewClient(nil, eclairClient)
+}
+
+// newFakeEclair returns a stand-in for eclair's HTTP API, which checks the password
+// and responds to the given endpoints with the given handlers.
+func newFakeEclair(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected a POST request, but was %v\n", r.Method)
+		}
+		if _, password, _ := r.BasicAuth(); password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler, ok := handlers[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}))
+}
+
+// TestEclairClientGenerateInvoice tests if the invoice is created with the correct parameters
+// and if the result is converted properly.
+func TestEclairClientGenerateInvoice(t *testing.T) {
+	server := newFakeEclair(t, map[string]http.HandlerFunc{
+		"/createinvoice": func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("amountMsat") != "10000" {
+				t.Errorf("Expected amountMsat %v, but was %v\n", "10000", r.FormValue("amountMsat"))
+			}
+			if r.FormValue("description") != "API call" {
+				t.Errorf("Expected description %v, but was %v\n", "API call", r.FormValue("description"))
+			}
+			if r.FormValue("expireIn") != "3600" {
+				t.Errorf("Expected expireIn %v, but was %v\n", "3600", r.FormValue("expireIn"))
+			}
+			w.Write([]byte(`{"prefix":"lnbcrt","timestamp":1643718891,"nodeId":"028e2403fbfddb3d787843361f91adbda64c6f622921b802fb185a8a85bb4d3a5a","serialized":"lnbcrt100n1pshk4am","description":"API call","paymentHash":"bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3","expiry":3600,"amount":10000}`))
+		},
+	})
+	defer server.Close()
+	eclairClient, err := ln.NewEclairClient(ln.EclairOptions{Address: server.URL + "/", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := eclairClient.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	expected := ln.Invoice{
+		ImplDepID:      "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3",
+		PaymentHash:    "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3",
+		PaymentRequest: "lnbcrt100n1pshk4am",
+	}
+	if invoice != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, invoice)
+	}
+}
+
+// TestEclairClientCheckInvoice tests if the status of the invoice is interpreted properly.
+func TestEclairClientCheckInvoice(t *testing.T) {
+	statuses := map[string]string{
+		"paid":    "received",
+		"unpaid":  "pending",
+		"expired": "expired",
+	}
+	server := newFakeEclair(t, map[string]http.HandlerFunc{
+		"/getreceivedinfo": func(w http.ResponseWriter, r *http.Request) {
+			status, ok := statuses[r.FormValue("paymentHash")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"Not found"}`))
+				return
+			}
+			w.Write([]byte(`{"paymentPreimage":"119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8","paymentType":"Standard","status":{"type":"` + status + `"}}`))
+		},
+	})
+	defer server.Close()
+	eclairClient, _ := ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret"})
+
+	for id, status := range statuses {
+		settled, err := eclairClient.CheckInvoice(id)
+		if err != nil {
+			t.Errorf("An error occurred during the test: %v\n", err)
+		}
+		if settled != (status == "received") {
+			t.Errorf("Expected settled to be %v for status %v, but was %v\n", status == "received", status, settled)
+		}
+	}
+
+	_, err := eclairClient.CheckInvoice("unknown")
+	if err == nil || !strings.Contains(err.Error(), "unable to locate invoice") {
+		t.Errorf("Expected an error for an unknown invoice, but was %v\n", err)
+	}
+}
+
+// TestEclairClientNotFound tests if a "404 Not Found" response that isn't about an unknown invoice,
+// for example because of a wrong address, leads to an error with the status and body.
+func TestEclairClientNotFound(t *testing.T) {
+	server := newFakeEclair(t, map[string]http.HandlerFunc{})
+	defer server.Close()
+	eclairClient, _ := ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret"})
+
+	_, err := eclairClient.CheckInvoice("unknown")
+	if err == nil || strings.Contains(err.Error(), "unable to locate invoice") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected an error with the status code %v, but was %v\n", 404, err)
+	}
+}
+
+// TestEclairClientPay tests if the preimage is returned after the payment changed from pending to sent.
+func TestEclairClientPay(t *testing.T) {
+	expected := "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	pollCount := 0
+	server := newFakeEclair(t, map[string]http.HandlerFunc{
+		"/payinvoice": func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("invoice") != "lnbcrt100n1pshk4am" {
+				t.Errorf("Expected invoice %v, but was %v\n", "lnbcrt100n1pshk4am", r.FormValue("invoice"))
+			}
+			w.Write([]byte(`"e4227601-38b3-404e-9aa0-75a829e9bec0"`))
+		},
+		"/getsentinfo": func(w http.ResponseWriter, r *http.Request) {
+			if r.FormValue("id") != "e4227601-38b3-404e-9aa0-75a829e9bec0" {
+				t.Errorf("Expected id %v, but was %v\n", "e4227601-38b3-404e-9aa0-75a829e9bec0", r.FormValue("id"))
+			}
+			pollCount++
+			if pollCount == 1 {
+				w.Write([]byte(`[{"id":"e4227601-38b3-404e-9aa0-75a829e9bec0","status":{"type":"pending"}}]`))
+				return
+			}
+			w.Write([]byte(`[{"id":"e4227601-38b3-404e-9aa0-75a829e9bec0","status":{"type":"sent","paymentPreimage":"` + expected + `"}}]`))
+		},
+	})
+	defer server.Close()
+	eclairClient, _ := ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret"})
+
+	actual, err := eclairClient.Pay("lnbcrt100n1pshk4am")
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, actual)
+	}
+}
+
+// TestEclairClientPayFailed tests if an error is returned when the payment failed.
+func TestEclairClientPayFailed(t *testing.T) {
+	server := newFakeEclair(t, map[string]http.HandlerFunc{
+		"/payinvoice": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`"e4227601-38b3-404e-9aa0-75a829e9bec0"`))
+		},
+		"/getsentinfo": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":"e4227601-38b3-404e-9aa0-75a829e9bec0","status":{"type":"failed","failures":[]}}]`))
+		},
+	})
+	defer server.Close()
+	eclairClient, _ := ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret"})
+
+	_, err := eclairClient.Pay("lnbcrt100n1pshk4am")
+	if err == nil {
+		t.Errorf("Expected an error for a failed payment, but was nil\n")
+	}
+}
+
+// TestEclairClientPayContext tests if polling a pending payment stops when the context is done or the timeout is reached.
+func TestEclairClientPayContext(t *testing.T) {
+	server := newFakeEclair(t, map[string]http.HandlerFunc{
+		"/payinvoice": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`"e4227601-38b3-404e-9aa0-75a829e9bec0"`))
+		},
+		"/getsentinfo": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"id":"e4227601-38b3-404e-9aa0-75a829e9bec0","status":{"type":"pending"}}]`))
+		},
+	})
+	defer server.Close()
+	eclairClient, _ := ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := eclairClient.PayContext(ctx, "lnbcrt100n1pshk4am")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, but was %v\n", context.DeadlineExceeded, err)
+	}
+
+	// Without a context, the polling stops after the timeout
+	eclairClient, _ = ln.NewEclairClient(ln.EclairOptions{Address: server.URL, Password: "secret", PayTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	_, err = eclairClient.Pay("lnbcrt100n1pshk4am")
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("Expected an error after %v, but was %v after %v\n", 100*time.Millisecond, err, time.Since(start))
+	}
+}
@@ -3,12 +3,10 @@ package ln
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"log"
-	"os"
-)
+	"log/slog"
 
-// stdOutLogger logs to stdout, while the default log package loggers log to stderr.
-var stdOutLogger = log.New(os.Stdout, "", log.LstdFlags)
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
 
 // Invoice is a Lightning Network invoice and contains the typical invoice string and the payment hash.
 type Invoice struct {
@@ -49,3 +47,18 @@ func HashPreimage(preimageHex string) (string, error) {
 	preimageHashHex := hex.EncodeToString(hashByteArray[:])
 	return preimageHashHex, nil
 }
+
+// logPayment logs that a payment for the given invoice is sent, with the amount, payee and payment hash of the invoice.
+// The invoice is decoded locally, because the decoded values are only used for logging.
+// The LN node decodes the invoice itself, so an invoice that can't be decoded here (for example because of a feature
+// that the local decoder doesn't support) is still sent to the node.
+func logPayment(logger *slog.Logger, invoice string) {
+	decodedInvoice, err := DecodeInvoice(invoice)
+	if err != nil {
+		logger.Warn("Couldn't decode invoice, sending the payment anyway", "error", err)
+		logger.Info("Sending payment")
+		return
+	}
+	logger.Info("Sending payment", "amount", decodedInvoice.Amount, "payee", decodedInvoice.Payee,
+		logging.Identifier("payment_hash", decodedInvoice.PaymentHash, false))
+}
@@ -0,0 +1,284 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// lnbitsPaymentPollInterval is the interval in which the status of an outgoing payment is fetched
+// in case LNbits didn't finish the payment when responding to the payment request.
+const lnbitsPaymentPollInterval = 500 * time.Millisecond
+
+// lnbitsNotFoundDetail is part of the error detail of LNbits' "404 Not Found" responses for unknown payment hashes,
+// as opposed to for example unknown endpoints.
+const lnbitsNotFoundDetail = "does not exist"
+
+// LNbitsClient is an implementation of the wall.LNclient and pay.LNclient interface
+// for an LNbits wallet.
+// It uses the wallet's invoice key for creating and checking invoices and the admin key for paying invoices,
+// so the same wallet can be used for receiving and sending payments.
+type LNbitsClient struct {
+	client     *http.Client
+	baseURL    string
+	invoiceKey string
+	adminKey   string
+	payTimeout time.Duration
+	logger     *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, LNbits' default is used.
+func (c LNbitsClient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
+}
+
+// GenerateInvoiceContext is like GenerateInvoice, but the requests to LNbits are cancelled when the context is done.
+func (c LNbitsClient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	result := Invoice{}
+
+	data := lnbitsCreatePayment{
+		Out:    false,
+		Amount: amount,
+		Memo:   memo,
+		Expiry: int64(expiry.Seconds()),
+	}
+	c.logger.Debug("Creating invoice", "amount", amount)
+	payment := lnbitsPayment{}
+	err := c.send(ctx, "POST", "/api/v1/payments", c.invoiceKey, data, &payment)
+	if err != nil {
+		return result, err
+	}
+
+	// LNbits uses the payment hash to identify payments
+	result.ImplDepID = payment.PaymentHash
+	result.PaymentHash = payment.PaymentHash
+	result.PaymentRequest = payment.PaymentRequest
+	return result, nil
+}
+
+// CheckInvoice takes an invoice ID (LN node implementation specific) and checks if the corresponding invoice was settled.
+// An error is returned if no corresponding invoice was found.
+// False is returned if the invoice isn't settled.
+func (c LNbitsClient) CheckInvoice(id string) (bool, error) {
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the requests to LNbits are cancelled when the context is done.
+func (c LNbitsClient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	// In the case of LNbits, the ID is the hex encoded payment hash.
+	c.logger.Debug("Checking invoice", logging.Identifier("payment_hash", id, false))
+
+	status := lnbitsPaymentStatus{}
+	err := c.send(ctx, "GET", "/api/v1/payments/"+id, c.invoiceKey, nil, &status)
+	if err != nil {
+		return false, err
+	}
+	return status.Paid, nil
+}
+
+// Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
+// This requires the admin key of the wallet.
+// If the payment is still in flight after LNbits responded, its status is polled until it either succeeded or failed,
+// but at most for the duration of LNbitsOptions.PayTimeout.
+func (c LNbitsClient) Pay(invoice string) (string, error) {
+	return c.PayContext(context.Background(), invoice)
+}
+
+// PayContext is like Pay, but the requests to LNbits and the polling are cancelled when the context is done.
+// Note that the payment might still succeed if the context is done while the payment is in flight.
+func (c LNbitsClient) PayContext(ctx context.Context, invoice string) (string, error) {
+	if c.adminKey == "" {
+		return "", errors.New("Paying invoices with LNbits requires the admin key of the wallet")
+	}
+
+	data := lnbitsCreatePayment{
+		Out:    true,
+		Bolt11: invoice,
+	}
+	logPayment(c.logger, invoice)
+	payment := lnbitsPayment{}
+	err := c.send(ctx, "POST", "/api/v1/payments", c.adminKey, data, &payment)
+	if err != nil {
+		return "", err
+	}
+
+	// The payment might still be in flight, in which case we have to wait until it's done
+	timeout := time.After(c.payTimeout)
+	for {
+		status := lnbitsPaymentStatus{}
+		err = c.send(ctx, "GET", "/api/v1/payments/"+payment.PaymentHash, c.adminKey, nil, &status)
+		if err != nil {
+			return "", err
+		}
+		if status.Paid {
+			return status.Preimage, nil
+		}
+		if status.Details.Status == "failed" {
+			return "", errors.New("The payment failed")
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timeout:
+			return "", fmt.Errorf("The payment neither succeeded nor failed within %v, but it might still succeed later", c.payTimeout)
+		case <-time.After(lnbitsPaymentPollInterval):
+		}
+	}
+}
+
+// send sends a request with the given context, method and JSON body (if not nil) to the given LNbits API endpoint,
+// authenticated with the given key, and populates the fields of the object that result points to
+// with the values of the response's JSON.
+func (c LNbitsClient) send(ctx context.Context, method string, endpoint string, key string, data interface{}, result interface{}) error {
+	var body []byte
+	if data != nil {
+		var err error
+		body, err = json.Marshal(data)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("X-Api-Key", key)
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	err = res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		apiError := lnbitsError{}
+		// If the body isn't the expected JSON, the error message is empty
+		json.Unmarshal(resBody, &apiError)
+		if res.StatusCode == http.StatusNotFound && strings.Contains(apiError.Detail, lnbitsNotFoundDetail) {
+			return fmt.Errorf("unable to locate invoice: %v", apiError.Detail)
+		}
+		return fmt.Errorf("LNbits responded with status %v: %s", res.Status, resBody)
+	}
+
+	return json.Unmarshal(resBody, result)
+}
+
+// NewLNbitsClient creates a new LNbitsClient instance.
+func NewLNbitsClient(lnbitsOptions LNbitsOptions) (LNbitsClient, error) {
+	result := LNbitsClient{}
+
+	lnbitsOptions = assignLNbitsDefaultValues(lnbitsOptions)
+	if lnbitsOptions.InvoiceKey == "" {
+		return result, errors.New("Either the invoice key or the admin key of the LNbits wallet is required")
+	}
+
+	result.client = http.DefaultClient
+	// Make sure the address doesn't end with "/", so that in the other functions
+	// we can rely on that it's ok to add for example "/api/v1/payments" to the baseURL.
+	result.baseURL = strings.TrimSuffix(lnbitsOptions.Address, "/")
+	result.invoiceKey = lnbitsOptions.InvoiceKey
+	result.adminKey = lnbitsOptions.AdminKey
+	result.payTimeout = lnbitsOptions.PayTimeout
+	result.logger = lnbitsOptions.Logger
+
+	return result, nil
+}
+
+// LNbitsOptions are the options for the connection to the LNbits wallet.
+type LNbitsOptions struct {
+	// Address of the LNbits server, including the protocol (e.g. "https://") and port.
+	// Optional ("http://localhost:5000" by default).
+	Address string
+	// Invoice key (a.k.a. "Invoice/read key") of the wallet, for creating and checking invoices
+	// (required by the middleware in the package "wall").
+	// Optional (AdminKey by default, but at least one of both is required).
+	InvoiceKey string
+	// Admin key of the wallet, for paying invoices (required by the client in the package "pay").
+	// Optional ("" by default).
+	AdminKey string
+	// Maximum duration that Pay waits for an outgoing payment to either succeed or fail.
+	// Values below 1 millisecond are automatically changed to the default value.
+	// Optional (1 minute by default).
+	PayTimeout time.Duration
+	// Logger for the client's structured log entries, for example about sent payments.
+	// Invoices and payment hashes are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+}
+
+// DefaultLNbitsOptions provides default values for LNbitsOptions.
+var DefaultLNbitsOptions = LNbitsOptions{
+	Address:    "http://localhost:5000",
+	PayTimeout: time.Minute,
+}
+
+func assignLNbitsDefaultValues(lnbitsOptions LNbitsOptions) LNbitsOptions {
+	if lnbitsOptions.Logger == nil {
+		lnbitsOptions.Logger = slog.Default()
+	}
+	if lnbitsOptions.Address == "" {
+		lnbitsOptions.Address = DefaultLNbitsOptions.Address
+	}
+	if lnbitsOptions.PayTimeout < time.Millisecond {
+		lnbitsOptions.PayTimeout = DefaultLNbitsOptions.PayTimeout
+	}
+	// The admin key can be used for everything the invoice key can be used for
+	if lnbitsOptions.InvoiceKey == "" {
+		lnbitsOptions.InvoiceKey = lnbitsOptions.AdminKey
+	}
+
+	return lnbitsOptions
+}
+
+// lnbitsCreatePayment is the JSON that's sent to LNbits for creating an invoice (Out is false)
+// or paying an invoice (Out is true).
+type lnbitsCreatePayment struct {
+	Out    bool   `json:"out"`
+	Amount int64  `json:"amount,omitempty"`
+	Memo   string `json:"memo,omitempty"`
+	Expiry int64  `json:"expiry,omitempty"`
+	Bolt11 string `json:"bolt11,omitempty"`
+}
+
+// lnbitsPayment contains the fields of LNbits' response to creating an invoice or paying an invoice
+// that are relevant to us.
+type lnbitsPayment struct {
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+	CheckingID     string `json:"checking_id"`
+}
+
+// lnbitsPaymentStatus contains the fields of LNbits' response to checking a payment that are relevant to us.
+type lnbitsPaymentStatus struct {
+	Paid     bool   `json:"paid"`
+	Preimage string `json:"preimage"`
+	Details  struct {
+		// "pending", "success" or "failed" (only in newer versions of LNbits)
+		Status string `json:"status"`
+	} `json:"details"`
+}
+
+// lnbitsError is the JSON that LNbits responds with in case of an error.
+type lnbitsError struct {
+	Detail string `json:"detail"`
+}
@@ -0,0 +1,186 @@
+package ln_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestLNbitsClientImpl tests if the LNbitsClient struct implements the wall.LNclient and pay.LNclient interfaces.
+// This doesn't happen at runtime, but at compile time.
+func TestLNbitsClientImpl(t *testing.T) {
+	t.SkipNow()
+	lnbitsClient := ln.LNbitsClient{}
+	wall.NewHandlerFuncMiddleware(wall.InvoiceOptions{}, lnbitsClient, nil, wall.MiddlewareOptions{})
+	pay.NewClient(nil, lnbitsClient)
+}
+
+const (
+	testLNbitsInvoiceKey = "invoicekey"
+	testLNbitsAdminKey   = "adminkey"
+)
+
+// newFakeLNbits returns a stand-in for the LNbits wallet API with a single invoice ("paidhash") that's paid
+// and one ("unpaidhash") that isn't. Invoices can only be paid with the admin key,
+// and paying the unpaid one leaves the payment pending.
+func newFakeLNbits(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		if key != testLNbitsInvoiceKey && key != testLNbitsAdminKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"detail":"Invalid key"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == "POST" && r.URL.Path == "/api/v1/payments" {
+			data := make(map[string]interface{})
+			err := json.NewDecoder(r.Body).Decode(&data)
+			if err != nil {
+				t.Error(err)
+			}
+			if data["out"] == true {
+				if key != testLNbitsAdminKey {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"detail":"Invoice key can't be used for paying"}`))
+					return
+				}
+				paymentHashes := map[interface{}]string{"lnbc100n1paid": "paidhash", "lnbc100n1unpaid": "unpaidhash"}
+				paymentHash, ok := paymentHashes[data["bolt11"]]
+				if !ok {
+					t.Errorf("Expected bolt11 %v or %v, but was %v\n", "lnbc100n1paid", "lnbc100n1unpaid", data["bolt11"])
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"payment_hash":"` + paymentHash + `","checking_id":"` + paymentHash + `"}`))
+				return
+			}
+			if data["amount"] != float64(10) || data["memo"] != "API call" || data["expiry"] != float64(3600) {
+				t.Errorf("Unexpected invoice data: %v\n", data)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"payment_hash":"unpaidhash","payment_request":"lnbc100n1unpaid","checking_id":"unpaidhash"}`))
+			return
+		}
+
+		if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/v1/payments/") {
+			switch strings.TrimPrefix(r.URL.Path, "/api/v1/payments/") {
+			case "paidhash":
+				w.Write([]byte(`{"paid":true,"preimage":"119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8","details":{"status":"success"}}`))
+			case "unpaidhash":
+				w.Write([]byte(`{"paid":false,"preimage":null,"details":{"status":"pending"}}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"detail":"Payment does not exist."}`))
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+// TestLNbitsClientGenerateInvoice tests if the invoice is created with the correct data
+// and if the result is converted properly.
+func TestLNbitsClientGenerateInvoice(t *testing.T) {
+	server := newFakeLNbits(t)
+	defer server.Close()
+	lnbitsClient, err := ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL, InvoiceKey: testLNbitsInvoiceKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoice, err := lnbitsClient.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	expected := ln.Invoice{
+		ImplDepID:      "unpaidhash",
+		PaymentHash:    "unpaidhash",
+		PaymentRequest: "lnbc100n1unpaid",
+	}
+	if invoice != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, invoice)
+	}
+}
+
+// TestLNbitsClientCheckInvoice tests if the status of the invoice is interpreted properly.
+func TestLNbitsClientCheckInvoice(t *testing.T) {
+	server := newFakeLNbits(t)
+	defer server.Close()
+	lnbitsClient, _ := ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL, InvoiceKey: testLNbitsInvoiceKey})
+
+	settled, err := lnbitsClient.CheckInvoice("paidhash")
+	if err != nil || !settled {
+		t.Errorf("Expected (true, nil), but was (%v, %v)\n", settled, err)
+	}
+	settled, err = lnbitsClient.CheckInvoice("unpaidhash")
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+	_, err = lnbitsClient.CheckInvoice("unknownhash")
+	if err == nil || !strings.Contains(err.Error(), "unable to locate invoice") {
+		t.Errorf("Expected an error for an unknown invoice, but was %v\n", err)
+	}
+
+	// A "404 Not Found" response that isn't about an unknown invoice, for example because of a wrong address
+	lnbitsClient, _ = ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL + "/wrong", InvoiceKey: testLNbitsInvoiceKey})
+	_, err = lnbitsClient.CheckInvoice("paidhash")
+	if err == nil || strings.Contains(err.Error(), "unable to locate invoice") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected an error with the status code %v, but was %v\n", 404, err)
+	}
+}
+
+// TestLNbitsClientPay tests if paying works with the admin key and doesn't work without it,
+// and if the polling of a pending payment is bounded.
+func TestLNbitsClientPay(t *testing.T) {
+	server := newFakeLNbits(t)
+	defer server.Close()
+
+	// Without admin key
+	lnbitsClient, _ := ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL, InvoiceKey: testLNbitsInvoiceKey})
+	_, err := lnbitsClient.Pay("lnbc100n1paid")
+	if err == nil {
+		t.Errorf("Expected an error when paying without admin key, but was nil\n")
+	}
+
+	// With admin key only, which is used as invoice key as well
+	lnbitsClient, err = ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL, AdminKey: testLNbitsAdminKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	actual, err := lnbitsClient.Pay("lnbc100n1paid")
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, actual)
+	}
+	settled, err := lnbitsClient.CheckInvoice("paidhash")
+	if err != nil || !settled {
+		t.Errorf("Expected (true, nil), but was (%v, %v)\n", settled, err)
+	}
+
+	// The polling of a pending payment stops after the timeout
+	lnbitsClient, _ = ln.NewLNbitsClient(ln.LNbitsOptions{Address: server.URL, AdminKey: testLNbitsAdminKey, PayTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	_, err = lnbitsClient.Pay("lnbc100n1unpaid")
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("Expected an error after %v, but was %v after %v\n", 100*time.Millisecond, err, time.Since(start))
+	}
+}
+
+// TestNewLNbitsClientWithoutKey tests if creating a client without any key leads to an error.
+func TestNewLNbitsClientWithoutKey(t *testing.T) {
+	_, err := ln.NewLNbitsClient(ln.DefaultLNbitsOptions)
+	if err == nil {
+		t.Errorf("Expected an error, but was nil\n")
+	}
+}
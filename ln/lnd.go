@@ -5,33 +5,45 @@ import (
 	"encoding/hex"
 	"errors"
 	"io/ioutil"
+	"log/slog"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
 )
 
 // LNDclient is an implementation of the wall.LNClient and pay.LNClient interface
 // for the lnd Lightning Network node implementation.
 type LNDclient struct {
-	lndClient lnrpc.LightningClient
-	ctx       context.Context
-	conn      *grpc.ClientConn
+	lndClient   lnrpc.LightningClient
+	macaroonHex string
+	conn        *grpc.ClientConn
+	logger      *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, lnd's default (1 hour) is used.
+func (c LNDclient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
 }
 
-// GenerateInvoice generates an invoice with the given price and memo.
-func (c LNDclient) GenerateInvoice(amount int64, memo string) (Invoice, error) {
+// GenerateInvoiceContext is like GenerateInvoice, but the RPC call is cancelled when the context is done.
+func (c LNDclient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
 	result := Invoice{}
 
 	// Create the request and send it
 	invoice := lnrpc.Invoice{
-		Memo:  memo,
-		Value: amount,
+		Memo:   memo,
+		Value:  amount,
+		Expiry: int64(expiry.Seconds()),
 	}
-	stdOutLogger.Println("Creating invoice for a new API request")
-	res, err := c.lndClient.AddInvoice(c.ctx, &invoice)
+	c.logger.Debug("Creating invoice", "amount", amount)
+	res, err := c.lndClient.AddInvoice(c.withMacaroon(ctx), &invoice)
 	if err != nil {
 		return result, err
 	}
@@ -46,13 +58,18 @@ func (c LNDclient) GenerateInvoice(amount int64, memo string) (Invoice, error) {
 // An error is returned if no corresponding invoice was found.
 // False is returned if the invoice isn't settled.
 func (c LNDclient) CheckInvoice(id string) (bool, error) {
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the RPC call is cancelled when the context is done.
+func (c LNDclient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
 	// In the case of lnd, the ID is the hex encoded preimage hash.
 	plainHash, err := hex.DecodeString(id)
 	if err != nil {
 		return false, err
 	}
 
-	stdOutLogger.Printf("Checking invoice for hash %v\n", id)
+	c.logger.Debug("Checking invoice", logging.Identifier("payment_hash", id, false))
 
 	// Get the invoice for that hash
 	paymentHash := lnrpc.PaymentHash{
@@ -60,7 +77,7 @@ func (c LNDclient) CheckInvoice(id string) (bool, error) {
 		// Hex encoded, must be exactly 32 byte
 		RHashStr: id,
 	}
-	invoice, err := c.lndClient.LookupInvoice(c.ctx, &paymentHash)
+	invoice, err := c.lndClient.LookupInvoice(c.withMacaroon(ctx), &paymentHash)
 	if err != nil {
 		return false, err
 	}
@@ -74,23 +91,20 @@ func (c LNDclient) CheckInvoice(id string) (bool, error) {
 
 // Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
 func (c LNDclient) Pay(invoice string) (string, error) {
-	// Decode payment request (a.k.a. invoice).
-	// TODO: Decoded values are only used for logging, so maybe make this optional to make fewer RPC calls
-	payReqString := lnrpc.PayReqString{
-		PayReq: invoice,
-	}
-	decodedPayReq, err := c.lndClient.DecodePayReq(c.ctx, &payReqString)
-	if err != nil {
-		return "", err
-	}
+	return c.PayContext(context.Background(), invoice)
+}
+
+// PayContext is like Pay, but the RPC call is cancelled when the context is done.
+// Note that the payment might still succeed if the context is done while the payment is in flight.
+func (c LNDclient) PayContext(ctx context.Context, invoice string) (string, error) {
+	// Decoded locally instead of making another RPC call
+	logPayment(c.logger, invoice)
 
 	// Send payment
 	sendReq := lnrpc.SendRequest{
 		PaymentRequest: invoice,
 	}
-	stdOutLogger.Printf("Sending payment with %v Satoshis to %v (memo: \"%v\")",
-		decodedPayReq.NumSatoshis, decodedPayReq.Destination, decodedPayReq.Description)
-	sendRes, err := c.lndClient.SendPaymentSync(c.ctx, &sendReq)
+	sendRes, err := c.lndClient.SendPaymentSync(c.withMacaroon(ctx), &sendReq)
 	if err != nil {
 		return "", err
 	}
@@ -103,6 +117,11 @@ func (c LNDclient) Pay(invoice string) (string, error) {
 	return string(hexPreimage), nil
 }
 
+// withMacaroon adds the macaroon to the outgoing context.
+func (c LNDclient) withMacaroon(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "macaroon", c.macaroonHex)
+}
+
 // NewLNDclient creates a new LNDclient instance.
 func NewLNDclient(lndOptions LNDoptions) (LNDclient, error) {
 	result := LNDclient{}
@@ -120,7 +139,7 @@ func NewLNDclient(lndOptions LNDoptions) (LNDclient, error) {
 	}
 	c := lnrpc.NewLightningClient(conn)
 
-	// Add the macaroon to the outgoing context
+	// Load the macaroon, which is added to the outgoing context of each RPC call
 
 	macaroon, err := ioutil.ReadFile(lndOptions.MacaroonFile)
 	if err != nil {
@@ -128,13 +147,12 @@ func NewLNDclient(lndOptions LNDoptions) (LNDclient, error) {
 	}
 	// Value must be the hex representation of the file content
 	macaroonHex := hex.EncodeToString(macaroon)
-	ctx := context.Background()
-	ctx = metadata.AppendToOutgoingContext(ctx, "macaroon", macaroonHex)
 
 	result = LNDclient{
-		conn:      conn,
-		ctx:       ctx,
-		lndClient: c,
+		conn:        conn,
+		macaroonHex: macaroonHex,
+		lndClient:   c,
+		logger:      lndOptions.Logger,
 	}
 
 	return result, nil
@@ -154,6 +172,10 @@ type LNDoptions struct {
 	// "admin.macaroon" if you use the Pay() method (required by the client in the package "pay").
 	// Optional ("invoice.macaroon" by default).
 	MacaroonFile string
+	// Logger for the client's structured log entries, for example about sent payments.
+	// Invoices and payment hashes are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
 }
 
 // DefaultLNDoptions provides default values for LNDoptions.
@@ -164,6 +186,9 @@ var DefaultLNDoptions = LNDoptions{
 }
 
 func assignLNDdefaultValues(lndOptions LNDoptions) LNDoptions {
+	if lndOptions.Logger == nil {
+		lndOptions.Logger = slog.Default()
+	}
 	// LNDoptions
 	if lndOptions.Address == "" {
 		lndOptions.Address = DefaultLNDoptions.Address
@@ -0,0 +1,326 @@
+package ln
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// LNDRESTclient is an implementation of the wall.LNclient and pay.LNclient interface
+// for the lnd Lightning Network node implementation.
+// In contrast to the LNDclient it uses lnd's REST API instead of gRPC,
+// so it can be used when only lnd's REST port is reachable, for example behind an HTTP-only proxy.
+type LNDRESTclient struct {
+	client      *http.Client
+	baseURL     string
+	macaroonHex string
+	logger      *slog.Logger
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry.
+// If the expiry is 0, lnd's default (1 hour) is used.
+func (c LNDRESTclient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	return c.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
+}
+
+// GenerateInvoiceContext is like GenerateInvoice, but the request to lnd is cancelled when the context is done.
+func (c LNDRESTclient) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (Invoice, error) {
+	result := Invoice{}
+
+	// lnd's REST API expects 64 bit integers as strings
+	data := map[string]string{
+		"value": strconv.FormatInt(amount, 10),
+		"memo":  memo,
+	}
+	if expiry > 0 {
+		data["expiry"] = strconv.FormatInt(int64(expiry.Seconds()), 10)
+	}
+	c.logger.Debug("Creating invoice", "amount", amount)
+	res := lndRESTaddInvoiceResponse{}
+	err := c.send(ctx, "POST", "/v1/invoices", data, &res)
+	if err != nil {
+		return result, err
+	}
+	rHash, err := base64.StdEncoding.DecodeString(res.RHash)
+	if err != nil {
+		return result, err
+	}
+
+	result.ImplDepID = hex.EncodeToString(rHash)
+	result.PaymentHash = result.ImplDepID
+	result.PaymentRequest = res.PaymentRequest
+	return result, nil
+}
+
+// CheckInvoice takes an invoice ID (LN node implementation specific) and checks if the corresponding invoice was settled.
+// An error is returned if no corresponding invoice was found.
+// False is returned if the invoice isn't settled.
+func (c LNDRESTclient) CheckInvoice(id string) (bool, error) {
+	return c.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but the request to lnd is cancelled when the context is done.
+func (c LNDRESTclient) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	// In the case of lnd, the ID is the hex encoded preimage hash.
+	_, err := hex.DecodeString(id)
+	if err != nil {
+		return false, err
+	}
+
+	c.logger.Debug("Checking invoice", logging.Identifier("payment_hash", id, false))
+
+	invoice := lndRESTinvoice{}
+	err = c.send(ctx, "GET", "/v1/invoice/"+id, nil, &invoice)
+	if err != nil {
+		return false, err
+	}
+
+	// "settled" is deprecated in favor of "state", but older lnd versions only have the former
+	return invoice.State == "SETTLED" || invoice.Settled, nil
+}
+
+// Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
+func (c LNDRESTclient) Pay(invoice string) (string, error) {
+	return c.PayContext(context.Background(), invoice)
+}
+
+// PayContext is like Pay, but the request to lnd is cancelled when the context is done.
+// Note that the payment might still succeed if the context is done while the payment is in flight.
+func (c LNDRESTclient) PayContext(ctx context.Context, invoice string) (string, error) {
+	data := map[string]string{
+		"payment_request": invoice,
+	}
+	logPayment(c.logger, invoice)
+	res := lndRESTsendResponse{}
+	err := c.send(ctx, "POST", "/v1/channels/transactions", data, &res)
+	if err != nil {
+		return "", err
+	}
+	// Even if the request was successful, this doesn't mean the payment was successful
+	if res.PaymentError != "" {
+		return "", errors.New(res.PaymentError)
+	}
+	preimage, err := base64.StdEncoding.DecodeString(res.PaymentPreimage)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(preimage), nil
+}
+
+// send sends a request with the given context, method and JSON body (if not nil) to the given lnd REST endpoint
+// and populates the fields of the object that result points to with the values of the response's JSON.
+func (c LNDRESTclient) send(ctx context.Context, method string, endpoint string, data interface{}, result interface{}) error {
+	var body []byte
+	if data != nil {
+		var err error
+		body, err = json.Marshal(data)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	// Same as with gRPC, but with the grpc-gateway prefix
+	req.Header.Add("Grpc-Metadata-macaroon", c.macaroonHex)
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	err = res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		apiError := lndRESTerror{}
+		// If the body isn't the expected JSON, the error message is empty.
+		// The message contains for example "unable to locate invoice", like the gRPC error.
+		json.Unmarshal(resBody, &apiError)
+		if apiError.Message == "" {
+			apiError.Message = apiError.Error
+		}
+		return fmt.Errorf("lnd responded with status %v: %v", res.Status, apiError.Message)
+	}
+
+	return json.Unmarshal(resBody, result)
+}
+
+// NewLNDRESTclient creates a new LNDRESTclient instance.
+func NewLNDRESTclient(lndRESToptions LNDRESToptions) (LNDRESTclient, error) {
+	result := LNDRESTclient{}
+
+	lndRESToptions = assignLNDRESTdefaultValues(lndRESToptions)
+
+	// Load the macaroon
+	macaroon := lndRESToptions.Macaroon
+	if macaroon == nil {
+		var err error
+		macaroon, err = ioutil.ReadFile(lndRESToptions.MacaroonFile)
+		if err != nil {
+			return result, err
+		}
+	}
+	// Value must be the hex representation of the file content
+	macaroonHex := string(bytes.TrimSpace(macaroon))
+	if _, err := hex.DecodeString(macaroonHex); err != nil {
+		macaroonHex = hex.EncodeToString(macaroon)
+	}
+
+	// Set up the HTTP client, which only trusts lnd's TLS certificate (if configured)
+	client := http.DefaultClient
+	cert := lndRESToptions.Cert
+	if cert == nil && lndRESToptions.CertFile != "" {
+		var err error
+		cert, err = ioutil.ReadFile(lndRESToptions.CertFile)
+		if err != nil {
+			return result, err
+		}
+	}
+	if cert != nil {
+		certPool, err := newCertPool(cert)
+		if err != nil {
+			return result, err
+		}
+		// Keep the defaults like the proxy from the environment and the timeouts
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs: certPool,
+		}
+		client = &http.Client{
+			Transport: transport,
+		}
+	}
+
+	result = LNDRESTclient{
+		client: client,
+		// Make sure the address doesn't end with "/", so that in the other functions
+		// we can rely on that it's ok to add for example "/v1/invoices" to the baseURL.
+		baseURL:     strings.TrimSuffix(lndRESToptions.Address, "/"),
+		macaroonHex: macaroonHex,
+		logger:      lndRESToptions.Logger,
+	}
+
+	return result, nil
+}
+
+// newCertPool creates a certificate pool that contains only the given certificate,
+// which can be PEM encoded (like lnd's "tls.cert" file), DER encoded, or one of both hex encoded.
+func newCertPool(cert []byte) (*x509.CertPool, error) {
+	if decoded, err := hex.DecodeString(string(bytes.TrimSpace(cert))); err == nil {
+		cert = decoded
+	}
+
+	certPool := x509.NewCertPool()
+	if certPool.AppendCertsFromPEM(cert) {
+		return certPool, nil
+	}
+	x509Cert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, errors.New("The TLS certificate for lnd is neither PEM nor DER encoded")
+	}
+	certPool.AddCert(x509Cert)
+	return certPool, nil
+}
+
+// LNDRESToptions are the options for the connection to the lnd node via its REST API.
+type LNDRESToptions struct {
+	// Address of your LND node's REST API, including the protocol (e.g. "https://") and port.
+	// Optional ("https://localhost:8080" by default).
+	Address string
+	// Path to the "tls.cert" file that your LND node uses.
+	// Not used if Cert is set.
+	// Optional (if neither Cert nor CertFile is set, the system's root certificates are used,
+	// which works for publicly trusted certificates, for example of a reverse proxy).
+	CertFile string
+	// Content of the "tls.cert" file that your LND node uses, either as is (PEM encoded) or hex encoded.
+	// DER encoded certificates are supported as well.
+	// Optional (nil by default).
+	Cert []byte
+	// Path to the macaroon file that your LND node uses.
+	// "invoice.macaroon" if you only use the GenerateInvoice() and CheckInvoice() methods
+	// (required by the middleware in the package "wall").
+	// "admin.macaroon" if you use the Pay() method (required by the client in the package "pay").
+	// Not used if Macaroon is set.
+	// Optional ("invoice.macaroon" by default).
+	MacaroonFile string
+	// Content of the macaroon file, either as is (binary) or hex encoded.
+	// Optional (nil by default).
+	Macaroon []byte
+	// Logger for the client's structured log entries, for example about sent payments.
+	// Invoices and payment hashes are shortened in the log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+}
+
+// DefaultLNDRESToptions provides default values for LNDRESToptions.
+var DefaultLNDRESToptions = LNDRESToptions{
+	Address:      "https://localhost:8080",
+	MacaroonFile: "invoice.macaroon",
+}
+
+func assignLNDRESTdefaultValues(lndRESToptions LNDRESToptions) LNDRESToptions {
+	if lndRESToptions.Logger == nil {
+		lndRESToptions.Logger = slog.Default()
+	}
+	if lndRESToptions.Address == "" {
+		lndRESToptions.Address = DefaultLNDRESToptions.Address
+	}
+	if lndRESToptions.MacaroonFile == "" {
+		lndRESToptions.MacaroonFile = DefaultLNDRESToptions.MacaroonFile
+	}
+
+	return lndRESToptions
+}
+
+// lndRESTaddInvoiceResponse contains the fields of the response of lnd's "POST /v1/invoices" endpoint that are relevant to us.
+type lndRESTaddInvoiceResponse struct {
+	// Base64 encoded
+	RHash          string `json:"r_hash"`
+	PaymentRequest string `json:"payment_request"`
+}
+
+// lndRESTinvoice contains the fields of the response of lnd's "GET /v1/invoice/{r_hash_str}" endpoint that are relevant to us.
+type lndRESTinvoice struct {
+	Settled bool `json:"settled"`
+	// "OPEN", "SETTLED", "CANCELED" or "ACCEPTED"
+	State string `json:"state"`
+}
+
+// lndRESTsendResponse contains the fields of the response of lnd's "POST /v1/channels/transactions" endpoint that are relevant to us.
+type lndRESTsendResponse struct {
+	PaymentError string `json:"payment_error"`
+	// Base64 encoded
+	PaymentPreimage string `json:"payment_preimage"`
+}
+
+// lndRESTerror is the JSON that lnd's REST API responds with in case of an error.
+// Depending on the lnd version the message is in "message" or "error".
+type lndRESTerror struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,212 @@
+package ln_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestLNDRESTclientImpl tests if the LNDRESTclient struct implements the wall.LNclient and pay.LNclient interfaces.
+// This doesn't happen at runtime, but at compile time.
+func TestLNDRESTclientImpl(t *testing.T) {
+	t.SkipNow()
+	lndRESTclient := ln.LNDRESTclient{}
+	wall.NewHandlerFuncMiddleware(wall.InvoiceOptions{}, lndRESTclient, nil, wall.MiddlewareOptions{})
+	pay.NewClient(nil, lndRESTclient)
+}
+
+// testMacaroon is a (binary) macaroon for the tests. Its content doesn't matter, only that it's sent hex encoded.
+var testMacaroon = []byte{0x02, 0x01, 0x03, 0x6c, 0x6e, 0x64}
+
+// newFakeLNDREST returns a TLS stand-in for lnd's REST API with a settled invoice (with the payment hash of testPreimageHex)
+// and an open one (with the payment hash "0000...").
+func newFakeLNDREST(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Grpc-Metadata-macaroon") != hex.EncodeToString(testMacaroon) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"verification failed: signature mismatch","code":2,"message":"verification failed: signature mismatch"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/invoices":
+			data := make(map[string]string)
+			json.NewDecoder(r.Body).Decode(&data)
+			if data["value"] != "10" || data["memo"] != "API call" || data["expiry"] != "3600" {
+				t.Errorf("Unexpected invoice data: %v\n", data)
+			}
+			// "vz4O..." is the base64 encoding of the payment hash of testPreimageHex
+			w.Write([]byte(`{"r_hash":"vz4Oc9S7HunWjKjRB4IT0FniPW4cihSz35P6+Hqk/tM=","payment_request":"lnbc100n1test","add_index":"1"}`))
+		case r.Method == "GET" && r.URL.Path == "/v1/invoice/"+testPaymentHashHex:
+			w.Write([]byte(`{"memo":"API call","settled":true,"state":"SETTLED"}`))
+		case r.Method == "GET" && r.URL.Path == "/v1/invoice/0000000000000000000000000000000000000000000000000000000000000000":
+			w.Write([]byte(`{"memo":"API call","settled":false,"state":"OPEN"}`))
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"unable to locate invoice","code":5,"message":"unable to locate invoice"}`))
+		case r.Method == "POST" && r.URL.Path == "/v1/channels/transactions":
+			data := make(map[string]string)
+			json.NewDecoder(r.Body).Decode(&data)
+			if data["payment_request"] != "lnbc100n1test" {
+				w.Write([]byte(`{"payment_error":"unable to find a path to destination"}`))
+				return
+			}
+			// "EZlpwj..." is the base64 encoding of testPreimageHex
+			w.Write([]byte(`{"payment_error":"","payment_preimage":"EZlpwjOHmM1WcIEmtdbA9vXnXtONp6QJsAgdlLTay/g=","payment_hash":"vz4Oc9S7HunWjKjRB4IT0FniPW4cihSz35P6+Hqk/tM="}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+const (
+	testPreimageHex    = "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	testPaymentHashHex = "bf3e0e73d4bb1ee9d68ca8d1078213d059e23d6e1c8a14b3df93faf87aa4fed3"
+)
+
+// newTestLNDRESTclient creates an LNDRESTclient for the given server, with the cert and macaroon passed as bytes.
+func newTestLNDRESTclient(t *testing.T, server *httptest.Server) ln.LNDRESTclient {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	lndRESTclient, err := ln.NewLNDRESTclient(ln.LNDRESToptions{
+		Address:  server.URL,
+		Cert:     certPEM,
+		Macaroon: testMacaroon,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lndRESTclient
+}
+
+// TestLNDRESTclientGenerateInvoice tests if the invoice is created with the correct data
+// and if the result is converted properly.
+func TestLNDRESTclientGenerateInvoice(t *testing.T) {
+	server := newFakeLNDREST(t)
+	defer server.Close()
+	lndRESTclient := newTestLNDRESTclient(t, server)
+
+	invoice, err := lndRESTclient.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	expected := ln.Invoice{
+		ImplDepID:      testPaymentHashHex,
+		PaymentHash:    testPaymentHashHex,
+		PaymentRequest: "lnbc100n1test",
+	}
+	if invoice != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, invoice)
+	}
+}
+
+// TestLNDRESTclientCheckInvoice tests if the state of the invoice is interpreted properly.
+func TestLNDRESTclientCheckInvoice(t *testing.T) {
+	server := newFakeLNDREST(t)
+	defer server.Close()
+	lndRESTclient := newTestLNDRESTclient(t, server)
+
+	settled, err := lndRESTclient.CheckInvoice(testPaymentHashHex)
+	if err != nil || !settled {
+		t.Errorf("Expected (true, nil), but was (%v, %v)\n", settled, err)
+	}
+	settled, err = lndRESTclient.CheckInvoice("0000000000000000000000000000000000000000000000000000000000000000")
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+	_, err = lndRESTclient.CheckInvoice("1111111111111111111111111111111111111111111111111111111111111111")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown invoice, but was nil\n")
+	}
+}
+
+// TestLNDRESTclientPay tests if the preimage is returned for successful payments and an error for failed ones.
+func TestLNDRESTclientPay(t *testing.T) {
+	server := newFakeLNDREST(t)
+	defer server.Close()
+	lndRESTclient := newTestLNDRESTclient(t, server)
+
+	actual, err := lndRESTclient.Pay("lnbc100n1test")
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+	if actual != testPreimageHex {
+		t.Errorf("Expected %v, but was %v\n", testPreimageHex, actual)
+	}
+
+	_, err = lndRESTclient.Pay("lnbc100n1unroutable")
+	if err == nil {
+		t.Errorf("Expected an error for a failed payment, but was nil\n")
+	}
+}
+
+// TestNewLNDRESTclientCredentials tests if the cert and macaroon can be passed as files, bytes and hex encoded bytes.
+func TestNewLNDRESTclientCredentials(t *testing.T) {
+	server := newFakeLNDREST(t)
+	defer server.Close()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	tempDir := os.TempDir() + "/" + strconv.FormatInt(rand.Int63(), 10)
+	err := os.Mkdir(tempDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	err = ioutil.WriteFile(tempDir+"/tls.cert", certPEM, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tempDir+"/invoice.macaroon", testMacaroon, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]ln.LNDRESToptions{
+		"files": {
+			CertFile:     tempDir + "/tls.cert",
+			MacaroonFile: tempDir + "/invoice.macaroon",
+		},
+		"hex": {
+			Cert:     []byte(hex.EncodeToString(certPEM)),
+			Macaroon: []byte(hex.EncodeToString(testMacaroon)),
+		},
+		"DER": {
+			Cert:     server.Certificate().Raw,
+			Macaroon: testMacaroon,
+		},
+	}
+	for name, lndRESToptions := range testCases {
+		lndRESToptions.Address = server.URL
+		lndRESTclient, err := ln.NewLNDRESTclient(lndRESToptions)
+		if err != nil {
+			t.Errorf("%v: An error occurred during creating the client: %v\n", name, err)
+			continue
+		}
+		settled, err := lndRESTclient.CheckInvoice(testPaymentHashHex)
+		if err != nil || !settled {
+			t.Errorf("%v: Expected (true, nil), but was (%v, %v)\n", name, settled, err)
+		}
+	}
+
+	// Without the cert the connection must fail, because the server's cert isn't trusted
+	lndRESTclient, err := ln.NewLNDRESTclient(ln.LNDRESToptions{Address: server.URL, Macaroon: testMacaroon})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lndRESTclient.CheckInvoice(testPaymentHashHex)
+	if err == nil {
+		t.Errorf("Expected an error for an untrusted cert, but was nil\n")
+	}
+}
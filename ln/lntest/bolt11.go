@@ -0,0 +1,115 @@
+package lntest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+
+	"github.com/philippgille/ln-paywall/internal/bech32"
+)
+
+// Types of the tagged fields of a BOLT11 invoice,
+// see https://github.com/lightningnetwork/lightning-rfc/blob/master/11-payment-encoding.md#tagged-fields
+const (
+	fieldTypePaymentHash   byte = 1
+	fieldTypeFeatures      byte = 5
+	fieldTypeExpiry        byte = 6
+	fieldTypeDescription   byte = 13
+	fieldTypePaymentSecret byte = 16
+	fieldTypeMinFinalCLTV  byte = 24
+)
+
+// minFinalCLTVexpiry is the value of the "c" field, which is lnd's default.
+const minFinalCLTVexpiry = 40
+
+// featureBits are the feature bits 8 (var_onion_optin) and 14 (payment_secret) as 5 bit groups,
+// which are required by all current LN node implementations.
+var featureBits = []byte{16, 8, 0}
+
+// taggedField is a tagged field of a BOLT11 invoice, with the data as 5 bit groups.
+type taggedField struct {
+	fieldType byte
+	data      []byte
+}
+
+// encodeInvoice encodes and signs a BOLT11 invoice with the given human-readable part (e.g. "lnbcrt10u"),
+// timestamp and tagged fields, which are encoded in the given order.
+func encodeInvoice(hrp string, timestamp time.Time, fields []taggedField, key *secp256k1.PrivateKey) (string, error) {
+	data := uint64ToGroups(uint64(timestamp.Unix()), 7)
+	for _, field := range fields {
+		if len(field.data) > 1023 {
+			return "", errors.New("The data of a tagged field must not be longer than 1023 groups")
+		}
+		data = append(data, field.fieldType)
+		data = append(data, uint64ToGroups(uint64(len(field.data)), 2)...)
+		data = append(data, field.data...)
+	}
+
+	// The signature is over the SHA-256 of the human-readable part and the data (without signature),
+	// with the 5 bit groups of the data converted to bytes.
+	dataBytes, err := bech32.ConvertBits(data, 5, 8, true)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append([]byte(hrp), dataBytes...))
+	// SignCompact returns the recovery ID (+27+4 for compressed keys) in front of R and S,
+	// but BOLT11 requires R and S followed by the recovery ID.
+	compactSig := ecdsa.SignCompact(key, hash[:], true)
+	sig := append(compactSig[1:], compactSig[0]-27-4)
+	sigGroups, err := bech32.ConvertBits(sig, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	return bech32.Encode(hrp, append(data, sigGroups...))
+}
+
+// invoiceHRP returns the human-readable part of a BOLT11 invoice for the given network prefix and amount in Satoshis.
+// It uses the largest multiplier that represents the amount without fractions, like LN node implementations do.
+// An amount of 0 leads to an invoice without amount.
+func invoiceHRP(network string, amount int64) string {
+	hrp := "ln" + network
+	switch {
+	case amount <= 0:
+		return hrp
+	case amount%100000 == 0:
+		// 1 milli-Bitcoin = 100,000 Satoshis
+		return hrp + strconv.FormatInt(amount/100000, 10) + "m"
+	case amount%100 == 0:
+		// 1 micro-Bitcoin = 100 Satoshis
+		return hrp + strconv.FormatInt(amount/100, 10) + "u"
+	default:
+		// 1 nano-Bitcoin = 0.1 Satoshis
+		return hrp + strconv.FormatInt(amount*10, 10) + "n"
+	}
+}
+
+// bytesField returns a tagged field with the given bytes converted to 5 bit groups.
+func bytesField(fieldType byte, data []byte) taggedField {
+	// Can't fail when converting from 8 to 5 bits with padding
+	groups, _ := bech32.ConvertBits(data, 8, 5, true)
+	return taggedField{fieldType: fieldType, data: groups}
+}
+
+// uintField returns a tagged field with the given value as big-endian 5 bit groups, without leading zeros.
+func uintField(fieldType byte, value uint64) taggedField {
+	groupCount := 1
+	for value>>(5*uint(groupCount)) > 0 {
+		groupCount++
+	}
+	return taggedField{fieldType: fieldType, data: uint64ToGroups(value, groupCount)}
+}
+
+// uint64ToGroups converts the value to the given number of big-endian 5 bit groups.
+func uint64ToGroups(value uint64, groupCount int) []byte {
+	result := make([]byte, groupCount)
+	for i := groupCount - 1; i >= 0; i-- {
+		result[i] = byte(value & 31)
+		value >>= 5
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package lntest
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// TestEncodeInvoice tests if the invoice from the examples in the BOLT11 specification is encoded exactly like in the specification,
+// see https://github.com/lightningnetwork/lightning-rfc/blob/master/11-payment-encoding.md#examples
+func TestEncodeInvoice(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes(DefaultNodeOptions.PrivateKey)
+	paymentHash, _ := hex.DecodeString("0001020304050607080900010203040506070809000102030405060708090102")
+	paymentSecret, _ := hex.DecodeString("1111111111111111111111111111111111111111111111111111111111111111")
+	fields := []taggedField{
+		bytesField(fieldTypePaymentSecret, paymentSecret),
+		bytesField(fieldTypePaymentHash, paymentHash),
+		bytesField(fieldTypeDescription, []byte("Please consider supporting this project")),
+		{fieldType: fieldTypeFeatures, data: featureBits},
+	}
+
+	actual, err := encodeInvoice("lnbc", time.Unix(1496314658, 0), fields, key)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	expected := "lnbc1pvjluezsp5zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zyg3zygspp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq9qrsgq357wnc5r2ueh7ck6q93dj32dlqnls087fxdwk8qakdyafkq3yap9us6v52vjjsrvywa6rt52cm9r9zqt8r2t7mlcwspyetp5h2tztugp9lfyql"
+	if actual != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, actual)
+	}
+}
+
+// TestInvoiceHRP tests if the amount is encoded with the largest possible multiplier.
+func TestInvoiceHRP(t *testing.T) {
+	testCases := map[int64]string{
+		0:       "lnbcrt",
+		1:       "lnbcrt10n",
+		10:      "lnbcrt100n",
+		100:     "lnbcrt1u",
+		2500:    "lnbcrt25u",
+		100000:  "lnbcrt1m",
+		2500000: "lnbcrt25m",
+		100001:  "lnbcrt1000010n",
+	}
+	for amount, expected := range testCases {
+		actual := invoiceHRP("bcrt", amount)
+		if actual != expected {
+			t.Errorf("Expected %v for amount %v, but was %v\n", expected, amount, actual)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Package lntest contains an in-memory fake Lightning Network node for tests and local development.
+
+The Node implements both the wall.LNclient and the pay.LNclient interface,
+so a pay.Client can pay a paywalled service within a single process, without any real LN node:
+
+	node, _ := lntest.NewNode(lntest.DefaultNodeOptions)
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(handler)
+	// ...
+	client := pay.NewClient(nil, node)
+	res, err := client.Get(url)
+
+The node only knows its own invoices, so it can only pay invoices that it created itself.
+*/
+package lntest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+
+	"github.com/philippgille/ln-paywall/ln"
+)
+
+// Names of the methods for which errors and latency can be injected.
+const (
+	MethodGenerateInvoice = "GenerateInvoice"
+	MethodCheckInvoice    = "CheckInvoice"
+	MethodPay             = "Pay"
+)
+
+// InvoiceState is the state of an invoice of the Node.
+type InvoiceState int
+
+// States of an invoice of the Node.
+const (
+	// StateOpen is the state of an invoice that's neither settled nor expired.
+	StateOpen InvoiceState = iota
+	// StateSettled is the state of a paid invoice.
+	StateSettled
+	// StateExpired is the state of an invoice that wasn't paid before its expiry.
+	StateExpired
+)
+
+// Node is an in-memory fake Lightning Network node, which implements the wall.LNclient and pay.LNclient interface.
+// Its invoices are valid BOLT11 invoices with a random preimage, signed with the node's private key.
+// Besides creating, checking and paying invoices, it allows tests to settle invoices,
+// to change their state and to inject errors and latency.
+// It's safe for concurrent use.
+type Node struct {
+	key      *secp256k1.PrivateKey
+	network  string
+	invoices map[string]*invoice
+	// Maps the payment request to the payment hash
+	paymentHashes map[string]string
+	errs          map[string]error
+	latencies     map[string]time.Duration
+	lock          *sync.Mutex
+}
+
+// invoice is an invoice of the Node.
+type invoice struct {
+	preimage  string
+	expiresAt time.Time
+	settled   bool
+}
+
+// GenerateInvoice generates an invoice with the given price, memo and expiry and a random preimage.
+// If the expiry is 0, the BOLT11 default (1 hour) is used.
+// In the returned invoice, ImplDepID and PaymentHash are both the hex encoded payment hash.
+func (n *Node) GenerateInvoice(amount int64, memo string, expiry time.Duration) (ln.Invoice, error) {
+	return n.GenerateInvoiceContext(context.Background(), amount, memo, expiry)
+}
+
+// GenerateInvoiceContext is like GenerateInvoice, but waiting for the injected latency stops when the context is done.
+func (n *Node) GenerateInvoiceContext(ctx context.Context, amount int64, memo string, expiry time.Duration) (ln.Invoice, error) {
+	result := ln.Invoice{}
+	if err := n.beforeCall(ctx, MethodGenerateInvoice); err != nil {
+		return result, err
+	}
+
+	preimage := make([]byte, 32)
+	_, err := rand.Read(preimage)
+	if err != nil {
+		return result, err
+	}
+	// The payment secret isn't checked, but it's a mandatory field in current LN node implementations
+	paymentSecret := make([]byte, 32)
+	_, err = rand.Read(paymentSecret)
+	if err != nil {
+		return result, err
+	}
+	preimageHex := hex.EncodeToString(preimage)
+	paymentHash, err := ln.HashPreimage(preimageHex)
+	if err != nil {
+		return result, err
+	}
+	// Can't fail, because it was just encoded by HashPreimage
+	paymentHashBytes, _ := hex.DecodeString(paymentHash)
+
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+	now := time.Now()
+	fields := []taggedField{
+		bytesField(fieldTypePaymentHash, paymentHashBytes),
+		bytesField(fieldTypePaymentSecret, paymentSecret),
+		bytesField(fieldTypeDescription, []byte(memo)),
+		uintField(fieldTypeExpiry, uint64(expiry.Seconds())),
+		uintField(fieldTypeMinFinalCLTV, minFinalCLTVexpiry),
+		{fieldType: fieldTypeFeatures, data: featureBits},
+	}
+	paymentRequest, err := encodeInvoice(invoiceHRP(n.network, amount), now, fields, n.key)
+	if err != nil {
+		return result, err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.invoices[paymentHash] = &invoice{
+		preimage:  preimageHex,
+		expiresAt: now.Add(expiry),
+	}
+	n.paymentHashes[paymentRequest] = paymentHash
+
+	result.ImplDepID = paymentHash
+	result.PaymentHash = paymentHash
+	result.PaymentRequest = paymentRequest
+	return result, nil
+}
+
+// CheckInvoice takes an invoice ID (the hex encoded payment hash) and checks if the corresponding invoice was settled.
+// An error is returned if no corresponding invoice was found.
+// False is returned if the invoice isn't settled.
+func (n *Node) CheckInvoice(id string) (bool, error) {
+	return n.CheckInvoiceContext(context.Background(), id)
+}
+
+// CheckInvoiceContext is like CheckInvoice, but waiting for the injected latency stops when the context is done.
+func (n *Node) CheckInvoiceContext(ctx context.Context, id string) (bool, error) {
+	if err := n.beforeCall(ctx, MethodCheckInvoice); err != nil {
+		return false, err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	inv, ok := n.invoices[id]
+	if !ok {
+		return false, errors.New("unable to locate invoice")
+	}
+	return inv.settled, nil
+}
+
+// Pay pays the invoice and returns the preimage (hex encoded) on success, or an error on failure.
+// Only invoices that were generated by the node itself can be paid,
+// and only if they're neither settled nor expired.
+func (n *Node) Pay(paymentRequest string) (string, error) {
+	return n.PayContext(context.Background(), paymentRequest)
+}
+
+// PayContext is like Pay, but waiting for the injected latency stops when the context is done.
+// The invoice isn't paid in that case.
+func (n *Node) PayContext(ctx context.Context, paymentRequest string) (string, error) {
+	if err := n.beforeCall(ctx, MethodPay); err != nil {
+		return "", err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	paymentHash, ok := n.paymentHashes[paymentRequest]
+	if !ok {
+		return "", errors.New("unable to find a path to destination")
+	}
+	return n.settle(paymentHash)
+}
+
+// Settle settles the invoice with the given payment hash (hex encoded) like a payment from another node would,
+// and returns its preimage (hex encoded).
+// An error is returned if no corresponding invoice was found or if it's already settled or expired.
+func (n *Node) Settle(paymentHash string) (string, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.settle(paymentHash)
+}
+
+// settle must only be called while holding the lock.
+func (n *Node) settle(paymentHash string) (string, error) {
+	inv, ok := n.invoices[paymentHash]
+	if !ok {
+		return "", errors.New("unable to locate invoice")
+	}
+	if inv.settled {
+		return "", errors.New("invoice is already paid")
+	}
+	if !time.Now().Before(inv.expiresAt) {
+		return "", errors.New("invoice expired")
+	}
+	inv.settled = true
+	return inv.preimage, nil
+}
+
+// SetInvoiceState sets the state of the invoice with the given payment hash (hex encoded).
+// This can be used to simulate for example an invoice that expired before it was paid (StateExpired),
+// or a payment that the payer considers successful while the node doesn't know about it yet (StateOpen after Pay).
+// An error is returned if no corresponding invoice was found.
+func (n *Node) SetInvoiceState(paymentHash string, state InvoiceState) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	inv, ok := n.invoices[paymentHash]
+	if !ok {
+		return errors.New("unable to locate invoice")
+	}
+	switch state {
+	case StateOpen:
+		inv.settled = false
+		if !time.Now().Before(inv.expiresAt) {
+			inv.expiresAt = time.Now().Add(time.Hour)
+		}
+	case StateSettled:
+		inv.settled = true
+	case StateExpired:
+		inv.settled = false
+		inv.expiresAt = time.Now()
+	default:
+		return errors.New("unknown invoice state")
+	}
+	return nil
+}
+
+// InjectError makes all following calls of the method with the given name (for example MethodPay)
+// return the given error. Pass nil to remove the error again.
+func (n *Node) InjectError(method string, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if err == nil {
+		delete(n.errs, method)
+	} else {
+		n.errs[method] = err
+	}
+}
+
+// InjectLatency makes all following calls of the method with the given name (for example MethodCheckInvoice)
+// take at least the given duration, unless the context of the call is done before. Pass 0 to remove the latency again.
+func (n *Node) InjectLatency(method string, latency time.Duration) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if latency <= 0 {
+		delete(n.latencies, method)
+	} else {
+		n.latencies[method] = latency
+	}
+}
+
+// NodeID returns the hex encoded compressed public key of the node, which is the payee of its invoices.
+func (n *Node) NodeID() string {
+	return hex.EncodeToString(n.key.PubKey().SerializeCompressed())
+}
+
+// beforeCall waits for the injected latency of the given method and returns the injected error, if any.
+// If the context is done before, the context's error is returned.
+func (n *Node) beforeCall(ctx context.Context, method string) error {
+	n.lock.Lock()
+	latency := n.latencies[method]
+	n.lock.Unlock()
+	// Don't hold the lock while waiting, so that concurrent calls aren't serialized
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(latency):
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.errs[method]
+}
+
+// NewNode creates a new Node instance.
+func NewNode(nodeOptions NodeOptions) (*Node, error) {
+	nodeOptions = assignDefaultValues(nodeOptions)
+	if len(nodeOptions.PrivateKey) != 32 {
+		return nil, errors.New("The private key must be 32 bytes long")
+	}
+
+	return &Node{
+		key:           secp256k1.PrivKeyFromBytes(nodeOptions.PrivateKey),
+		network:       nodeOptions.Network,
+		invoices:      make(map[string]*invoice),
+		paymentHashes: make(map[string]string),
+		errs:          make(map[string]error),
+		latencies:     make(map[string]time.Duration),
+		lock:          &sync.Mutex{},
+	}, nil
+}
+
+// NodeOptions are the options for the Node.
+type NodeOptions struct {
+	// Network part of the invoices' human-readable prefix,
+	// for example "bc" for mainnet, "tb" for testnet and "bcrt" for regtest.
+	// Optional ("bcrt" by default).
+	Network string
+	// Private key of the node, which is used for signing invoices (32 bytes).
+	// Optional (the private key from the examples in the BOLT11 specification by default,
+	// so the node ID is always "03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad").
+	PrivateKey []byte
+}
+
+// DefaultNodeOptions provides default values for NodeOptions.
+var DefaultNodeOptions = NodeOptions{
+	Network: "bcrt",
+	// Can't fail, because it's a constant valid hex string
+	PrivateKey: mustDecodeHex("e126f68f7eafcc8b74f54d269fe206be715000f94dac067d1c04a8ca3b2db734"),
+}
+
+func assignDefaultValues(nodeOptions NodeOptions) NodeOptions {
+	if nodeOptions.Network == "" {
+		nodeOptions.Network = DefaultNodeOptions.Network
+	}
+	if nodeOptions.PrivateKey == nil {
+		nodeOptions.PrivateKey = DefaultNodeOptions.PrivateKey
+	}
+
+	return nodeOptions
+}
+
+func mustDecodeHex(s string) []byte {
+	result, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+package lntest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestNodeImpl tests if the Node struct implements the wall.LNclient and pay.LNclient interfaces.
+// This doesn't happen at runtime, but at compile time.
+func TestNodeImpl(t *testing.T) {
+	t.SkipNow()
+	node := &lntest.Node{}
+	wall.NewHandlerFuncMiddleware(wall.InvoiceOptions{}, node, nil, wall.MiddlewareOptions{})
+	pay.NewClient(nil, node)
+}
+
+func newTestNode(t *testing.T) *lntest.Node {
+	node, err := lntest.NewNode(lntest.DefaultNodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// TestNodeGenerateInvoice tests if the generated invoice has the expected prefix
+// and if its payment hash matches the preimage that's returned when paying it.
+func TestNodeGenerateInvoice(t *testing.T) {
+	node := newTestNode(t)
+
+	invoice, err := node.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	if !strings.HasPrefix(invoice.PaymentRequest, "lnbcrt100n1") {
+		t.Errorf("Expected the payment request to start with %v, but was %v\n", "lnbcrt100n1", invoice.PaymentRequest)
+	}
+	if invoice.ImplDepID != invoice.PaymentHash {
+		t.Errorf("Expected the ID to be the payment hash %v, but was %v\n", invoice.PaymentHash, invoice.ImplDepID)
+	}
+
+	preimage, err := node.Pay(invoice.PaymentRequest)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	paymentHash, err := ln.HashPreimage(preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paymentHash != invoice.PaymentHash {
+		t.Errorf("Expected the hash of the preimage to be %v, but was %v\n", invoice.PaymentHash, paymentHash)
+	}
+
+	// Each invoice must have its own preimage
+	invoice2, _ := node.GenerateInvoice(10, "API call", time.Hour)
+	if invoice2.PaymentHash == invoice.PaymentHash {
+		t.Errorf("Expected different payment hashes, but both were %v\n", invoice.PaymentHash)
+	}
+}
+
+// TestNodeCheckInvoice tests if invoices are only settled after being paid or settled by the test.
+func TestNodeCheckInvoice(t *testing.T) {
+	node := newTestNode(t)
+	invoice, _ := node.GenerateInvoice(10, "API call", time.Hour)
+
+	settled, err := node.CheckInvoice(invoice.ImplDepID)
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+	_, err = node.Settle(invoice.PaymentHash)
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+	settled, err = node.CheckInvoice(invoice.ImplDepID)
+	if err != nil || !settled {
+		t.Errorf("Expected (true, nil), but was (%v, %v)\n", settled, err)
+	}
+
+	_, err = node.CheckInvoice("0000000000000000000000000000000000000000000000000000000000000000")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown invoice, but was nil\n")
+	}
+}
+
+// TestNodePay tests if paid, expired and unknown invoices can't be paid.
+func TestNodePay(t *testing.T) {
+	node := newTestNode(t)
+	invoice, _ := node.GenerateInvoice(10, "API call", time.Hour)
+
+	_, err := node.Pay(invoice.PaymentRequest)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	_, err = node.Pay(invoice.PaymentRequest)
+	if err == nil {
+		t.Errorf("Expected an error for an already paid invoice, but was nil\n")
+	}
+
+	invoice, _ = node.GenerateInvoice(10, "API call", time.Hour)
+	err = node.SetInvoiceState(invoice.PaymentHash, lntest.StateExpired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = node.Pay(invoice.PaymentRequest)
+	if err == nil {
+		t.Errorf("Expected an error for an expired invoice, but was nil\n")
+	}
+
+	_, err = node.Pay("lnbcrt100n1unknown")
+	if err == nil {
+		t.Errorf("Expected an error for an unknown invoice, but was nil\n")
+	}
+}
+
+// TestNodeSetInvoiceState tests if a paid invoice can be made unsettled again.
+func TestNodeSetInvoiceState(t *testing.T) {
+	node := newTestNode(t)
+	invoice, _ := node.GenerateInvoice(10, "API call", time.Hour)
+	node.Pay(invoice.PaymentRequest)
+
+	err := node.SetInvoiceState(invoice.PaymentHash, lntest.StateOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settled, err := node.CheckInvoice(invoice.ImplDepID)
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+
+	err = node.SetInvoiceState("0000000000000000000000000000000000000000000000000000000000000000", lntest.StateSettled)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown invoice, but was nil\n")
+	}
+}
+
+// TestNodeInject tests if injected errors and latency affect only the given method and can be removed again.
+func TestNodeInject(t *testing.T) {
+	node := newTestNode(t)
+	expectedErr := errors.New("connection refused")
+
+	node.InjectError(lntest.MethodGenerateInvoice, expectedErr)
+	_, err := node.GenerateInvoice(10, "API call", time.Hour)
+	if err != expectedErr {
+		t.Errorf("Expected %v, but was %v\n", expectedErr, err)
+	}
+	node.InjectError(lntest.MethodGenerateInvoice, nil)
+	invoice, err := node.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	}
+
+	latency := 100 * time.Millisecond
+	node.InjectLatency(lntest.MethodCheckInvoice, latency)
+	start := time.Now()
+	node.CheckInvoice(invoice.ImplDepID)
+	if time.Since(start) < latency {
+		t.Errorf("Expected the call to take at least %v, but it took %v\n", latency, time.Since(start))
+	}
+	node.InjectLatency(lntest.MethodCheckInvoice, 0)
+	start = time.Now()
+	node.CheckInvoice(invoice.ImplDepID)
+	if time.Since(start) >= latency {
+		t.Errorf("Expected the call to take less than %v, but it took %v\n", latency, time.Since(start))
+	}
+}
+
+// TestNodeContext tests if a call with injected latency stops when the context is done
+// and if a cancelled payment doesn't settle the invoice.
+func TestNodeContext(t *testing.T) {
+	node := newTestNode(t)
+	invoice, err := node.GenerateInvoice(10, "API call", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.InjectLatency(lntest.MethodPay, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = node.PayContext(ctx, invoice.PaymentRequest)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, but was %v\n", context.DeadlineExceeded, err)
+	}
+	settled, err := node.CheckInvoiceContext(context.Background(), invoice.ImplDepID)
+	if err != nil || settled {
+		t.Errorf("Expected (false, nil), but was (%v, %v)\n", settled, err)
+	}
+}
+
+// TestNewNode tests if the node ID is deterministic and if an invalid private key leads to an error.
+func TestNewNode(t *testing.T) {
+	node := newTestNode(t)
+	expected := "03e7156ae33b0a208d0744199163177e909e80176e55d97a2f221ede0f934dd9ad"
+	if node.NodeID() != expected {
+		t.Errorf("Expected %v, but was %v\n", expected, node.NodeID())
+	}
+
+	_, err := lntest.NewNode(lntest.NodeOptions{PrivateKey: []byte{1, 2, 3}})
+	if err == nil {
+		t.Errorf("Expected an error for an invalid private key, but was nil\n")
+	}
+}
@@ -0,0 +1,7 @@
+/*
+Package metrics contains metrics implementations and related options.
+
+These metrics implementations satisfy the wall.Metrics interface.
+You can use one of them or implement your own.
+*/
+package metrics
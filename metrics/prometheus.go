@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusCollector is an implementation of the wall.Metrics interface, which collects the metrics with Prometheus.
+// It's a prometheus.Collector, so it must be registered before the metrics can be scraped, for example:
+//
+//	collector := metrics.NewPrometheusCollector(metrics.DefaultPrometheusOptions)
+//	prometheus.MustRegister(collector)
+//	middlewareOptions.Metrics = collector
+//
+// It collects the following metrics (with the default namespace):
+//
+//	ln_paywall_invoices_generated_total{route}         Number of invoices that were sent to clients
+//	ln_paywall_invoiced_satoshis_total{route}          Sum of the prices of the invoices that were sent to clients
+//	ln_paywall_invoices_redeemed_total{route}          Number of paid invoices whose preimage was accepted
+//	ln_paywall_redeemed_satoshis_total{route}          Sum of the prices of the paid invoices whose preimage was accepted
+//	ln_paywall_credentials_accepted_total{route}       Number of requests that were accepted with an L402 credential that isn't single-use
+//	ln_paywall_preimages_rejected_total{route,reason}  Number of requests that were rejected because of an invalid preimage
+//	ln_paywall_ln_call_duration_seconds{method,result} Histogram of the duration of calls to the LN node, with "success" or "error" as result
+//	ln_paywall_storage_errors_total{method}            Number of failed calls to the storage
+type PrometheusCollector struct {
+	invoicesGenerated   *prometheus.CounterVec
+	invoicedSatoshis    *prometheus.CounterVec
+	invoicesRedeemed    *prometheus.CounterVec
+	redeemedSatoshis    *prometheus.CounterVec
+	credentialsAccepted *prometheus.CounterVec
+	preimagesRejected   *prometheus.CounterVec
+	lnCallDuration      *prometheus.HistogramVec
+	storageErrors       *prometheus.CounterVec
+}
+
+// InvoiceGenerated counts the invoice and its amount.
+func (c *PrometheusCollector) InvoiceGenerated(route string, amount int64) {
+	c.invoicesGenerated.WithLabelValues(route).Inc()
+	c.invoicedSatoshis.WithLabelValues(route).Add(float64(amount))
+}
+
+// InvoiceRedeemed counts the invoice and its amount.
+func (c *PrometheusCollector) InvoiceRedeemed(route string, amount int64) {
+	c.invoicesRedeemed.WithLabelValues(route).Inc()
+	c.redeemedSatoshis.WithLabelValues(route).Add(float64(amount))
+}
+
+// CredentialAccepted counts the request.
+func (c *PrometheusCollector) CredentialAccepted(route string) {
+	c.credentialsAccepted.WithLabelValues(route).Inc()
+}
+
+// PreimageRejected counts the rejection.
+func (c *PrometheusCollector) PreimageRejected(route string, reason string) {
+	c.preimagesRejected.WithLabelValues(route, reason).Inc()
+}
+
+// LNcall observes the duration of the call.
+func (c *PrometheusCollector) LNcall(method string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	c.lnCallDuration.WithLabelValues(method, result).Observe(duration.Seconds())
+}
+
+// StorageError counts the error.
+func (c *PrometheusCollector) StorageError(method string, err error) {
+	c.storageErrors.WithLabelValues(method).Inc()
+}
+
+// Describe sends the descriptors of all metrics to the channel. It implements prometheus.Collector.
+func (c *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect sends all metrics to the channel. It implements prometheus.Collector.
+func (c *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (c *PrometheusCollector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.invoicesGenerated,
+		c.invoicedSatoshis,
+		c.invoicesRedeemed,
+		c.redeemedSatoshis,
+		c.credentialsAccepted,
+		c.preimagesRejected,
+		c.lnCallDuration,
+		c.storageErrors,
+	}
+}
+
+// NewPrometheusCollector creates a new PrometheusCollector.
+func NewPrometheusCollector(prometheusOptions PrometheusOptions) *PrometheusCollector {
+	prometheusOptions = assignPrometheusDefaultValues(prometheusOptions)
+
+	newCounterVec := func(name string, help string, labelNames ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   prometheusOptions.Namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheusOptions.ConstLabels,
+		}, labelNames)
+	}
+	return &PrometheusCollector{
+		invoicesGenerated:   newCounterVec("invoices_generated_total", "Number of invoices that were sent to clients.", "route"),
+		invoicedSatoshis:    newCounterVec("invoiced_satoshis_total", "Sum of the prices of the invoices that were sent to clients.", "route"),
+		invoicesRedeemed:    newCounterVec("invoices_redeemed_total", "Number of paid invoices whose preimage was accepted.", "route"),
+		redeemedSatoshis:    newCounterVec("redeemed_satoshis_total", "Sum of the prices of the paid invoices whose preimage was accepted.", "route"),
+		credentialsAccepted: newCounterVec("credentials_accepted_total", "Number of requests that were accepted with an L402 credential that isn't single-use.", "route"),
+		preimagesRejected:   newCounterVec("preimages_rejected_total", "Number of requests that were rejected because of an invalid preimage.", "route", "reason"),
+		lnCallDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   prometheusOptions.Namespace,
+			Name:        "ln_call_duration_seconds",
+			Help:        "Duration of calls to the LN node.",
+			ConstLabels: prometheusOptions.ConstLabels,
+			Buckets:     prometheusOptions.Buckets,
+		}, []string{"method", "result"}),
+		storageErrors: newCounterVec("storage_errors_total", "Number of failed calls to the storage.", "method"),
+	}
+}
+
+// PrometheusOptions are the options for the PrometheusCollector.
+type PrometheusOptions struct {
+	// Namespace that's used as prefix of the metric names.
+	// Optional ("ln_paywall" by default).
+	Namespace string
+	// Labels with fixed values that are added to all metrics,
+	// for example for distinguishing multiple middlewares in the same web service.
+	// Optional (nil by default).
+	ConstLabels prometheus.Labels
+	// Buckets of the histogram of the LN node call durations, in seconds.
+	// Optional (prometheus.DefBuckets by default).
+	Buckets []float64
+}
+
+// DefaultPrometheusOptions provides default values for PrometheusOptions.
+var DefaultPrometheusOptions = PrometheusOptions{
+	Namespace: "ln_paywall",
+	Buckets:   prometheus.DefBuckets,
+}
+
+func assignPrometheusDefaultValues(prometheusOptions PrometheusOptions) PrometheusOptions {
+	if prometheusOptions.Namespace == "" {
+		prometheusOptions.Namespace = DefaultPrometheusOptions.Namespace
+	}
+	if len(prometheusOptions.Buckets) == 0 {
+		prometheusOptions.Buckets = DefaultPrometheusOptions.Buckets
+	}
+
+	return prometheusOptions
+}
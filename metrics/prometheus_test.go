@@ -0,0 +1,147 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/metrics"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+func newTestHandlerFunc(node *lntest.Node, storageClient wall.StorageClient, collector *metrics.PrometheusCollector) http.HandlerFunc {
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.PricingTable = []wall.RoutePrice{{Path: "/items/*", Price: 10}}
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Metrics = collector
+	return wall.NewHandlerFuncMiddleware(invoiceOptions, node, storageClient, middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+}
+
+func newTestNode(t *testing.T) *lntest.Node {
+	node, err := lntest.NewNode(lntest.DefaultNodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// TestPrometheusCollector tests if the collector counts the invoices and rejections of the middleware
+// and observes the duration of the calls to the LN node.
+func TestPrometheusCollector(t *testing.T) {
+	node := newTestNode(t)
+	collector := metrics.NewPrometheusCollector(metrics.DefaultPrometheusOptions)
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	handlerFunc := newTestHandlerFunc(node, storage.NewGoMap(), collector)
+
+	// Get the invoice and redeem the preimage twice
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/items/1", nil))
+	preimage, err := node.Pay(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/items/1", nil)
+		req.Header.Set("X-Preimage", preimage)
+		handlerFunc(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP ln_paywall_invoiced_satoshis_total Sum of the prices of the invoices that were sent to clients.
+# TYPE ln_paywall_invoiced_satoshis_total counter
+ln_paywall_invoiced_satoshis_total{route="/items/*"} 10
+# HELP ln_paywall_invoices_generated_total Number of invoices that were sent to clients.
+# TYPE ln_paywall_invoices_generated_total counter
+ln_paywall_invoices_generated_total{route="/items/*"} 1
+# HELP ln_paywall_invoices_redeemed_total Number of paid invoices whose preimage was accepted.
+# TYPE ln_paywall_invoices_redeemed_total counter
+ln_paywall_invoices_redeemed_total{route="/items/*"} 1
+# HELP ln_paywall_preimages_rejected_total Number of requests that were rejected because of an invalid preimage.
+# TYPE ln_paywall_preimages_rejected_total counter
+ln_paywall_preimages_rejected_total{reason="You already sent a request with the same preimage. You have to pay a new invoice for and include the corresponding preimage in each request.",route="/items/*"} 1
+# HELP ln_paywall_redeemed_satoshis_total Sum of the prices of the paid invoices whose preimage was accepted.
+# TYPE ln_paywall_redeemed_satoshis_total counter
+ln_paywall_redeemed_satoshis_total{route="/items/*"} 10
+`
+	err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"ln_paywall_invoiced_satoshis_total", "ln_paywall_invoices_generated_total", "ln_paywall_invoices_redeemed_total",
+		"ln_paywall_preimages_rejected_total", "ln_paywall_redeemed_satoshis_total")
+	if err != nil {
+		t.Error(err)
+	}
+	// One series for each of GenerateInvoice and CheckInvoice, both successful
+	count, err := testutil.GatherAndCount(registry, "ln_paywall_ln_call_duration_seconds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Expected %v, but was %v\n", 2, count)
+	}
+}
+
+// failingStorageClient is a wall.StorageClient whose calls always fail.
+type failingStorageClient struct{}
+
+func (failingStorageClient) Set(string, interface{}) error {
+	return errors.New("connection refused")
+}
+
+func (failingStorageClient) Get(string, interface{}) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+// TestPrometheusCollectorStorageErrors tests if the collector counts failed calls to the storage.
+func TestPrometheusCollectorStorageErrors(t *testing.T) {
+	node := newTestNode(t)
+	collector := metrics.NewPrometheusCollector(metrics.DefaultPrometheusOptions)
+	handlerFunc := newTestHandlerFunc(node, failingStorageClient{}, collector)
+
+	// The invoice isn't sent because its metadata can't be stored
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/items/1", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusInternalServerError, res.Code)
+	}
+	// The metadata for a preimage can't be looked up
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set("X-Preimage", "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8")
+	handlerFunc(httptest.NewRecorder(), req)
+
+	expected := `
+# HELP ln_paywall_storage_errors_total Number of failed calls to the storage.
+# TYPE ln_paywall_storage_errors_total counter
+ln_paywall_storage_errors_total{method="Get"} 1
+ln_paywall_storage_errors_total{method="Set"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "ln_paywall_storage_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestPrometheusCollectorCredentialsAccepted tests if the collector counts the requests with L402 credentials that aren't single-use.
+func TestPrometheusCollectorCredentialsAccepted(t *testing.T) {
+	collector := metrics.NewPrometheusCollector(metrics.DefaultPrometheusOptions)
+	collector.CredentialAccepted("/items/*")
+	collector.CredentialAccepted("/items/*")
+
+	expected := `
+# HELP ln_paywall_credentials_accepted_total Number of requests that were accepted with an L402 credential that isn't single-use.
+# TYPE ln_paywall_credentials_accepted_total counter
+ln_paywall_credentials_accepted_total{route="/items/*"} 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "ln_paywall_credentials_accepted_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,7 @@
 package pay
 
 import (
-	"errors"
-	"io/ioutil"
+	"context"
 	"net/http"
 )
 
@@ -12,6 +11,16 @@ type LNclient interface {
 	Pay(invoice string) (string, error)
 }
 
+// ContextLNclient is an LNclient that additionally supports cancelling payments via a context.
+// The Client and Transport pass the context of the request that led to the invoice,
+// so a payment can be cancelled together with its request.
+// All clients in the ln package implement this interface.
+type ContextLNclient interface {
+	LNclient
+	// PayContext is like Pay, but the payment is cancelled when the context is done.
+	PayContext(ctx context.Context, invoice string) (string, error)
+}
+
 // Client is an HTTP client, which handles "Payment Required" interruptions transparently.
 // It must be initially set up with a connection the Lightning Network node that should handle the payments
 // and from then on it's meant to be used as an alternative to the "net/http.Client".
@@ -20,13 +29,12 @@ type LNclient interface {
 type Client struct {
 	c *http.Client
 	l LNclient
+	o PaymentOptions
+	b *budget
 }
 
 // Get sends an HTTP GET request to the given URL and automatically handles the required payment in the background.
-// It does this by sending its own request to the URL + path of the given request
-// to trigger a "402 Payment Required" response with an invoice.
-// It then pays the invoice via the configured Lightning Network node.
-// Finally it sends the originally intended (given) request with an additional HTTP header and returns the response.
+// See Do(...) for details.
 func (c *Client) Get(url string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -36,55 +44,38 @@ func (c *Client) Get(url string) (*http.Response, error) {
 }
 
 // Do sends the given request and automatically handles the required payment in the background.
-// It does this by sending its own request to the URL + path of the given request
-// to trigger a "402 Payment Required" response with an invoice.
-// It then pays the invoice via the configured Lightning Network node.
-// Finally it sends the originally intended (given) request with an additional HTTP header and returns the response.
+// If the response is "402 Payment Required", it pays the invoice from the response body via the configured Lightning Network node,
+// unless the invoice exceeds a limit of the client's payment options, in which case a PaymentRefusal is returned.
+// It then sends the same request (including query string and body) again with an additional HTTP header and returns the response.
+// Other responses are returned as is, without any payment.
+//
+// If the request's GetBody is set (which is the case for requests created with http.NewRequest(...)
+// with a *bytes.Buffer, *bytes.Reader or *strings.Reader body), it's used for getting a new copy of the body.
+// Otherwise the body is buffered in memory.
+//
+// The request's context is passed to the LN client if it implements ContextLNclient,
+// so cancelling the request also cancels the payment.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	// Send first request, no data (query params or body) required
-
-	invoiceReq, err := http.NewRequest(req.Method, req.URL.Scheme+"://"+req.URL.Host+req.URL.EscapedPath(), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := c.c.Do(invoiceReq)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	// Expect "402 Payment Required" status code in response
-	if res.StatusCode != http.StatusPaymentRequired {
-		return nil, errors.New("Request expected to trigger \"402 Payment Required\" response, but was: " + " " + res.Status)
-	}
-
-	invoice, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Pay invoice
-	hexPreimage, err := c.l.Pay(string(invoice))
-	if err != nil {
-		return nil, err
-	}
-
-	// Add preimage to the original request's headers
-	req.Header.Add("X-Preimage", hexPreimage)
-
-	// Send original request and return response or error
-	return c.c.Do(req)
+	return sendWithPayment(req, c.c.Do, c.l, c.o, c.b)
 }
 
-// NewClient creates a new pay.Client instance.
+// NewClient creates a new pay.Client instance, which pays all invoices without any limit.
 // You can pass nil as httpClient, in which case the http.DefaultClient will be used.
 func NewClient(httpClient *http.Client, lnClient LNclient) Client {
+	return NewClientWithOptions(httpClient, lnClient, DefaultPaymentOptions)
+}
+
+// NewClientWithOptions creates a new pay.Client instance, which only pays invoices within the limits of the payment options.
+// If an invoice isn't paid because of the payment options, the client returns a PaymentRefusal error.
+// You can pass nil as httpClient, in which case the http.DefaultClient will be used.
+func NewClientWithOptions(httpClient *http.Client, lnClient LNclient, paymentOptions PaymentOptions) Client {
 	if httpClient == nil {
 		httpClient = http.DefaultClient
 	}
 	return Client{
 		c: httpClient,
 		l: lnClient,
+		o: assignDefaultValues(paymentOptions),
+		b: newBudget(),
 	}
 }
@@ -0,0 +1,251 @@
+package pay_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// newTestServer returns a server with the middleware in front of a handler that responds with "pong".
+// Each request costs the given amount.
+func newTestServer(t *testing.T, node *lntest.Node, price int64) *httptest.Server {
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.Price = price
+	invoiceOptions.Memo = "ping"
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	return httptest.NewServer(handlerFunc)
+}
+
+func newTestNode(t *testing.T) *lntest.Node {
+	node, err := lntest.NewNode(lntest.DefaultNodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// expectRefusal checks if the error is a PaymentRefusal with the given reason.
+func expectRefusal(t *testing.T, err error, expected pay.RefusalReason) {
+	refusal, ok := err.(pay.PaymentRefusal)
+	if !ok {
+		t.Errorf("Expected a PaymentRefusal, but was %v\n", err)
+		return
+	}
+	if refusal.Reason != expected {
+		t.Errorf("Expected reason %v, but was %v\n", expected, refusal.Reason)
+	}
+}
+
+// TestMaxAmount tests if invoices above the maximum amount aren't paid.
+func TestMaxAmount(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{MaxAmount: 10})
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	res.Body.Close()
+
+	client = pay.NewClientWithOptions(nil, node, pay.PaymentOptions{MaxAmount: 9})
+	_, err = client.Get(server.URL)
+	expectRefusal(t, err, pay.RefusalMaxAmount)
+}
+
+// TestHostBudget tests if the budget is tracked per host and if it's available again after the window passed.
+func TestHostBudget(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+	otherServer := newTestServer(t, node, 10)
+	defer otherServer.Close()
+
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{HostBudget: 25, BudgetWindow: time.Second})
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("An error occurred during the test: %v\n", err)
+		}
+		res.Body.Close()
+	}
+	_, err := client.Get(server.URL)
+	expectRefusal(t, err, pay.RefusalHostBudget)
+
+	// Other hosts have their own budget
+	res, err := client.Get(otherServer.URL)
+	if err != nil {
+		t.Errorf("An error occurred during the test: %v\n", err)
+	} else {
+		res.Body.Close()
+	}
+
+	time.Sleep(time.Second)
+	res, err = client.Get(server.URL)
+	if err != nil {
+		t.Errorf("Expected the budget to be available again after the window, but an error occurred: %v\n", err)
+	} else {
+		res.Body.Close()
+	}
+}
+
+// TestHostBudgetConcurrent tests if concurrent requests can't exceed the budget together.
+func TestHostBudgetConcurrent(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{HostBudget: 50})
+
+	paid := 0
+	lock := sync.Mutex{}
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			defer waitGroup.Done()
+			res, err := client.Get(server.URL)
+			if err == nil {
+				res.Body.Close()
+				lock.Lock()
+				paid++
+				lock.Unlock()
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if paid != 5 {
+		t.Errorf("Expected %v paid requests, but there were %v\n", 5, paid)
+	}
+}
+
+// TestAllowedPayees tests if only invoices of allowed LN nodes are paid.
+func TestAllowedPayees(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{AllowedPayees: []string{node.NodeID()}})
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	res.Body.Close()
+
+	client = pay.NewClientWithOptions(nil, node, pay.PaymentOptions{AllowedPayees: []string{"02aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}})
+	_, err = client.Get(server.URL)
+	expectRefusal(t, err, pay.RefusalPayee)
+}
+
+// TestApprove tests if the approval function gets the decoded invoice and if its decision is respected.
+func TestApprove(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+
+	var payment pay.Payment
+	approved := false
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{
+		Approve: func(p pay.Payment) bool {
+			payment = p
+			return approved
+		},
+	})
+	_, err := client.Get(server.URL)
+	expectRefusal(t, err, pay.RefusalApproval)
+	if payment.Amount != 10 || payment.Memo != "ping" || payment.Payee != node.NodeID() || payment.Host != server.Listener.Addr().String() {
+		t.Errorf("Unexpected payment data: %+v\n", payment)
+	}
+
+	approved = true
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	res.Body.Close()
+}
+
+// TestClientDo tests if the client sends the query string and body of POST requests,
+// and if it only pays when the response is "402 Payment Required".
+func TestClientDo(t *testing.T) {
+	node := newTestNode(t)
+	invoiceOptions := wall.DefaultInvoiceOptions
+	// Makes sure that the second request contains the same query string and body as the first one
+	invoiceOptions.BindRequest = true
+	// Only requests with the query parameter "paid=true" are paywalled
+	invoiceOptions.PricingFunc = func(r *http.Request) (int64, string, error) {
+		if r.URL.Query().Get("paid") != "true" {
+			return 0, "", wall.PricingRejection{StatusCode: http.StatusNotFound, Message: "not found"}
+		}
+		return 10, "ping", nil
+	}
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	server := httptest.NewServer(handlerFunc)
+	defer server.Close()
+	paid := 0
+	client := pay.NewClientWithOptions(nil, node, pay.PaymentOptions{
+		Approve: func(pay.Payment) bool {
+			paid++
+			return true
+		},
+	})
+
+	req, err := http.NewRequest("POST", server.URL+"/?paid=true", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "ping" || paid != 1 {
+		t.Errorf("Expected (%v, %v, %v), but was (%v, %v, %v)\n", http.StatusOK, "ping", 1, res.StatusCode, string(body), paid)
+	}
+
+	res, err = client.Get(server.URL + "/?paid=false")
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound || paid != 1 {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusNotFound, 1, res.StatusCode, paid)
+	}
+}
+
+// TestClientDoContext tests if cancelling the request also cancels the payment.
+func TestClientDoContext(t *testing.T) {
+	node := newTestNode(t)
+	node.InjectLatency(lntest.MethodPay, time.Minute)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+	client := pay.NewClient(nil, node)
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.Do(req.WithContext(ctx))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, but was %v\n", context.DeadlineExceeded, err)
+	}
+}
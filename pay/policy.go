@@ -0,0 +1,214 @@
+package pay
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+)
+
+// PaymentOptions are the options that limit which invoices the client pays.
+// Invoices that exceed a limit aren't paid and lead to a PaymentRefusal error.
+type PaymentOptions struct {
+	// Maximum amount of Satoshis that's paid for a single request.
+	// Values below 1 mean that there's no limit.
+	// Optional (0 by default).
+	MaxAmount int64
+	// Maximum amount of Satoshis that's paid to a single host (e.g. "api.example.com" or "localhost:8080")
+	// within the BudgetWindow, for example 1000 Satoshis per 24 hours.
+	// Values below 1 mean that there's no limit.
+	// Optional (0 by default).
+	HostBudget int64
+	// Rolling time window for the HostBudget.
+	// Values below 1 second are automatically changed to the default value.
+	// Optional (24 hours by default).
+	BudgetWindow time.Duration
+	// Hex encoded public keys of the LN nodes that may be paid.
+	// If empty, invoices of all LN nodes are paid.
+	// Optional (nil by default).
+	AllowedPayees []string
+	// Function that's called before an invoice is paid, after all other limits were checked.
+	// It can be called concurrently for concurrent requests.
+	// The invoice is only paid if it returns true.
+	// This can be used for example for asking the user for approval.
+	// Optional (nil by default).
+	Approve func(Payment) bool
+}
+
+// DefaultPaymentOptions provides default values for PaymentOptions, which don't limit the payments.
+var DefaultPaymentOptions = PaymentOptions{
+	BudgetWindow: 24 * time.Hour,
+}
+
+// Payment contains the data of an invoice that's about to be paid.
+type Payment struct {
+	// Host of the request that led to the invoice, for example "api.example.com" or "localhost:8080".
+	Host string
+	// The invoice in Bech32 encoding.
+	Invoice string
+	// Amount in Satoshis.
+	Amount int64
+	// Note on the invoice, for example "API call to api.example.com".
+	Memo string
+	// Hex encoded public key of the LN node that's paid.
+	Payee string
+}
+
+// RefusalReason is the reason why an invoice wasn't paid.
+type RefusalReason string
+
+// Reasons why an invoice wasn't paid.
+const (
+	// RefusalInvalidInvoice means that the invoice couldn't be decoded, so the limits couldn't be checked.
+	RefusalInvalidInvoice RefusalReason = "The invoice couldn't be decoded"
+	// RefusalMaxAmount means that the invoice's amount exceeds PaymentOptions.MaxAmount.
+	RefusalMaxAmount RefusalReason = "The invoice's amount exceeds the maximum amount per request"
+	// RefusalHostBudget means that paying the invoice would exceed PaymentOptions.HostBudget.
+	RefusalHostBudget RefusalReason = "Paying the invoice would exceed the budget for the host"
+	// RefusalPayee means that the invoice's payee isn't in PaymentOptions.AllowedPayees.
+	RefusalPayee RefusalReason = "The invoice's payee isn't allowed"
+	// RefusalApproval means that PaymentOptions.Approve returned false.
+	RefusalApproval RefusalReason = "The payment wasn't approved"
+)
+
+// PaymentRefusal is the error that's returned when an invoice wasn't paid because of the PaymentOptions.
+type PaymentRefusal struct {
+	Reason RefusalReason
+	// The invoice that wasn't paid.
+	// Only the Host and Invoice are set if the reason is RefusalInvalidInvoice.
+	Payment Payment
+}
+
+// Error returns the refusal reason and the invoice.
+func (pr PaymentRefusal) Error() string {
+	return string(pr.Reason) + ": " + pr.Payment.Invoice
+}
+
+// budget keeps track of the Satoshis that were paid to each host.
+// It's safe for concurrent use.
+type budget struct {
+	spendings map[string][]*spending
+	lock      *sync.Mutex
+}
+
+type spending struct {
+	time   time.Time
+	amount int64
+}
+
+// reserve adds the amount to the host's spendings if the sum of the spendings within the window
+// wouldn't exceed the limit afterwards.
+// It returns false if the limit would be exceeded.
+// Otherwise it returns a function that removes the amount again, for example if the payment failed.
+func (b *budget) reserve(host string, amount int64, limit int64, window time.Duration) (func(), bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Forget spendings that are outside of the window, so the map doesn't grow without bounds
+	now := time.Now()
+	var sum int64
+	var spendings []*spending
+	for _, s := range b.spendings[host] {
+		if now.Sub(s.time) < window {
+			spendings = append(spendings, s)
+			sum += s.amount
+		}
+	}
+	if sum+amount > limit {
+		b.set(host, spendings)
+		return nil, false
+	}
+	s := &spending{time: now, amount: amount}
+	b.set(host, append(spendings, s))
+
+	release := func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		var spendings []*spending
+		for _, other := range b.spendings[host] {
+			if other != s {
+				spendings = append(spendings, other)
+			}
+		}
+		b.set(host, spendings)
+	}
+	return release, true
+}
+
+// set must only be called while holding the lock.
+func (b *budget) set(host string, spendings []*spending) {
+	if len(spendings) == 0 {
+		delete(b.spendings, host)
+	} else {
+		b.spendings[host] = spendings
+	}
+}
+
+func newBudget() *budget {
+	return &budget{
+		spendings: make(map[string][]*spending),
+		lock:      &sync.Mutex{},
+	}
+}
+
+// checkPayment checks the invoice against the payment options and returns a PaymentRefusal if it must not be paid.
+// If the host budget is limited, the invoice's amount is reserved in the budget
+// and the returned function must be called to remove it again if the payment fails.
+// Otherwise the returned function does nothing.
+func checkPayment(paymentOptions PaymentOptions, hostBudget *budget, host string, invoice string) (func(), error) {
+	noop := func() {}
+	if paymentOptions.MaxAmount < 1 && paymentOptions.HostBudget < 1 && len(paymentOptions.AllowedPayees) == 0 && paymentOptions.Approve == nil {
+		return noop, nil
+	}
+
+	payment := Payment{
+		Host:    host,
+		Invoice: invoice,
+	}
+	decodedInvoice, err := ln.DecodeInvoice(invoice)
+	if err != nil {
+		return noop, PaymentRefusal{Reason: RefusalInvalidInvoice, Payment: payment}
+	}
+	payment.Amount = decodedInvoice.Amount
+	payment.Memo = decodedInvoice.Description
+	payment.Payee = decodedInvoice.Payee
+
+	if paymentOptions.MaxAmount > 0 && payment.Amount > paymentOptions.MaxAmount {
+		return noop, PaymentRefusal{Reason: RefusalMaxAmount, Payment: payment}
+	}
+	if len(paymentOptions.AllowedPayees) > 0 {
+		allowed := false
+		for _, allowedPayee := range paymentOptions.AllowedPayees {
+			if strings.EqualFold(allowedPayee, payment.Payee) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return noop, PaymentRefusal{Reason: RefusalPayee, Payment: payment}
+		}
+	}
+	// The amount is reserved before asking for approval, so that concurrent requests can't exceed the budget
+	release := noop
+	if paymentOptions.HostBudget > 0 {
+		var ok bool
+		release, ok = hostBudget.reserve(host, payment.Amount, paymentOptions.HostBudget, paymentOptions.BudgetWindow)
+		if !ok {
+			return noop, PaymentRefusal{Reason: RefusalHostBudget, Payment: payment}
+		}
+	}
+	if paymentOptions.Approve != nil && !paymentOptions.Approve(payment) {
+		release()
+		return noop, PaymentRefusal{Reason: RefusalApproval, Payment: payment}
+	}
+	return release, nil
+}
+
+func assignDefaultValues(paymentOptions PaymentOptions) PaymentOptions {
+	if paymentOptions.BudgetWindow < time.Second {
+		paymentOptions.BudgetWindow = DefaultPaymentOptions.BudgetWindow
+	}
+
+	return paymentOptions
+}
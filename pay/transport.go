@@ -0,0 +1,177 @@
+package pay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxInvoiceLength is the maximum number of bytes that are read from a "402 Payment Required" response body.
+// BOLT11 invoices are usually much shorter, but they can contain for example long descriptions and route hints.
+const maxInvoiceLength = 64 * 1024
+
+// Transport is an http.RoundTripper, which handles "Payment Required" interruptions transparently.
+// It sends each request via an inner http.RoundTripper. If the response is "402 Payment Required",
+// it pays the invoice from the response body via the configured Lightning Network node
+// and sends the same request again, with the preimage in the "X-Preimage" header.
+// This way any http.Client can be used for paywalled APIs:
+//
+//	client := &http.Client{Transport: pay.NewTransport(nil, lnClient, pay.DefaultPaymentOptions)}
+//
+// Request bodies are sent twice. If the request's GetBody is set (which is the case for requests
+// created with http.NewRequest(...) with a *bytes.Buffer, *bytes.Reader or *strings.Reader body),
+// it's used for getting a new copy of the body. Otherwise the body is buffered in memory.
+type Transport struct {
+	t http.RoundTripper
+	l LNclient
+	o PaymentOptions
+	b *budget
+}
+
+// RoundTrip sends the request and automatically handles the required payment, as described for the Transport.
+// If an invoice isn't paid because of the payment options, a PaymentRefusal is returned.
+// The response of the second request is returned as is, even if its status code is "402 Payment Required" again.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return sendWithPayment(req, t.t.RoundTrip, t.l, t.o, t.b)
+}
+
+// sendWithPayment sends the request with the given function. If the response is "402 Payment Required",
+// it pays the invoice from the response body and sends the same request again, with the preimage in the "X-Preimage" header.
+func sendWithPayment(req *http.Request, send func(*http.Request) (*http.Response, error), lnClient LNclient, paymentOptions PaymentOptions, hostBudget *budget) (*http.Response, error) {
+	// The given request must not be modified (an http.RoundTripper isn't allowed to), so a shallow copy is sent instead
+	firstReq := copyRequest(req)
+	getBody, err := rewindableBody(firstReq)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := send(firstReq)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPaymentRequired {
+		return res, nil
+	}
+
+	// Read the invoice from the response body
+	invoice, err := readInvoice(res)
+	if err != nil {
+		return nil, err
+	}
+
+	hexPreimage, err := pay(req.Context(), lnClient, paymentOptions, hostBudget, req.URL.Host, invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the same request again, with the preimage
+	secondReq := copyRequest(req)
+	if getBody != nil {
+		secondReq.Body, err = getBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	secondReq.Header.Set("X-Preimage", hexPreimage)
+	return send(secondReq)
+}
+
+// readInvoice reads the invoice from the body of a "402 Payment Required" response and closes the body.
+// The body is either the plain invoice, or a JSON object with the invoice in its "invoice" field,
+// which ln-paywall sends when the request's "Accept" header contains "application/json".
+func readInvoice(res *http.Response) (string, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxInvoiceLength))
+	res.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return strings.TrimSpace(string(body)), nil
+	}
+	invoiceJSON := struct {
+		Invoice string `json:"invoice"`
+	}{}
+	err = json.Unmarshal(body, &invoiceJSON)
+	if err != nil {
+		return "", err
+	}
+	return invoiceJSON.Invoice, nil
+}
+
+// pay checks the invoice against the payment options and pays it via the LN client.
+// If the LN client is a ContextLNclient, the context is passed to it.
+// It returns the hex encoded preimage, or a PaymentRefusal if the invoice must not be paid.
+func pay(ctx context.Context, lnClient LNclient, paymentOptions PaymentOptions, hostBudget *budget, host string, invoice string) (string, error) {
+	release, err := checkPayment(paymentOptions, hostBudget, host, invoice)
+	if err != nil {
+		return "", err
+	}
+	var hexPreimage string
+	if ctxLNclient, ok := lnClient.(ContextLNclient); ok {
+		hexPreimage, err = ctxLNclient.PayContext(ctx, invoice)
+	} else {
+		hexPreimage, err = lnClient.Pay(invoice)
+	}
+	if err != nil {
+		// Don't count the failed payment towards the host budget
+		release()
+		return "", err
+	}
+	return hexPreimage, nil
+}
+
+// copyRequest returns a shallow copy of the request, with a copy of the headers, so they can be changed.
+func copyRequest(req *http.Request) *http.Request {
+	result := new(http.Request)
+	*result = *req
+	result.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		result.Header[k] = append([]string(nil), v...)
+	}
+	return result
+}
+
+// rewindableBody makes sure that the body of the request can be sent again after it was sent once.
+// It returns a function that returns a new copy of the body, or nil if the request has no body.
+// If the request's GetBody is nil, the body is read into memory and the request's body is replaced by a reader of it.
+func rewindableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	getBody := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = getBody()
+	req.GetBody = getBody
+	return getBody, nil
+}
+
+// NewTransport creates a new pay.Transport instance, which only pays invoices within the limits of the payment options.
+// Pass pay.DefaultPaymentOptions to pay all invoices without any limit.
+// You can pass nil as transport, in which case the http.DefaultTransport will be used.
+func NewTransport(transport http.RoundTripper, lnClient LNclient, paymentOptions PaymentOptions) *Transport {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Transport{
+		t: transport,
+		l: lnClient,
+		o: assignDefaultValues(paymentOptions),
+		b: newBudget(),
+	}
+}
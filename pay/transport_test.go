@@ -0,0 +1,110 @@
+package pay_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestTransport tests if an http.Client with the Transport can pay for requests with and without body.
+func TestTransport(t *testing.T) {
+	node := newTestNode(t)
+	invoiceOptions := wall.DefaultInvoiceOptions
+	// Makes sure that the second request contains the same query string and body as the first one
+	invoiceOptions.BindRequest = true
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.URL.RawQuery + " " + string(body)))
+	})
+	server := httptest.NewServer(handlerFunc)
+	defer server.Close()
+	client := &http.Client{Transport: pay.NewTransport(nil, node, pay.DefaultPaymentOptions)}
+
+	testCases := map[string]func() (*http.Request, error){
+		"without body": func() (*http.Request, error) {
+			return http.NewRequest("GET", server.URL+"/?foo=bar", nil)
+		},
+		"with GetBody": func() (*http.Request, error) {
+			return http.NewRequest("POST", server.URL+"/?foo=bar", strings.NewReader("baz"))
+		},
+		"without GetBody": func() (*http.Request, error) {
+			// ioutil.NopCloser hides the type of the reader, so http.NewRequest(...) can't set GetBody
+			return http.NewRequest("POST", server.URL+"/?foo=bar", ioutil.NopCloser(bytes.NewReader([]byte("baz"))))
+		},
+		"accepting JSON": func() (*http.Request, error) {
+			// Leads to a JSON object with the invoice in the "402 Payment Required" response
+			req, err := http.NewRequest("GET", server.URL+"/?foo=bar", nil)
+			if err == nil {
+				req.Header.Set("Accept", "application/json")
+			}
+			return req, err
+		},
+	}
+	expected := map[string]string{
+		"without body":    "foo=bar ",
+		"with GetBody":    "foo=bar baz",
+		"without GetBody": "foo=bar baz",
+		"accepting JSON":  "foo=bar ",
+	}
+	for name, newRequest := range testCases {
+		req, err := newRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%v: An error occurred during the test: %v\n", name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || string(body) != expected[name] {
+			t.Errorf("%v: Expected (%v, %v), but was (%v, %v)\n", name, http.StatusOK, expected[name], res.StatusCode, string(body))
+		}
+	}
+}
+
+// TestTransportWithoutPayment tests if responses other than "402 Payment Required" are returned as is, without paying.
+func TestTransportWithoutPayment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	node := newTestNode(t)
+	paid := false
+	client := &http.Client{Transport: pay.NewTransport(nil, node, pay.PaymentOptions{
+		Approve: func(pay.Payment) bool {
+			paid = true
+			return true
+		},
+	})}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound || paid {
+		t.Errorf("Expected status code %v without payment, but was %v (paid: %v)\n", http.StatusNotFound, res.StatusCode, paid)
+	}
+}
+
+// TestTransportRefusal tests if a refused payment leads to an error that contains the PaymentRefusal.
+func TestTransportRefusal(t *testing.T) {
+	node := newTestNode(t)
+	server := newTestServer(t, node, 10)
+	defer server.Close()
+	client := &http.Client{Transport: pay.NewTransport(nil, node, pay.PaymentOptions{MaxAmount: 1})}
+
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), string(pay.RefusalMaxAmount)) {
+		t.Errorf("Expected an error with the reason %v, but was %v\n", pay.RefusalMaxAmount, err)
+	}
+}
@@ -1,20 +1,33 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
 	"sync"
+	"time"
 
 	bolt "github.com/coreos/bbolt"
 )
 
 var bucketName = "ln-paywall"
 
+// expiryBucketName is the name of the bucket that contains the expiry (Unix time in nanoseconds, big endian)
+// of objects that were stored with a TTL, with the same key as the object.
+var expiryBucketName = "ln-paywall-expiry"
+
 // BoltClient is a StorageClient implementation for bbolt (formerly known as Bolt / Bolt DB).
 type BoltClient struct {
-	db   *bolt.DB
-	lock *sync.Mutex
+	db     *bolt.DB
+	lock   *sync.Mutex
+	logger *slog.Logger
+	// Closed by Close for stopping the sweeper
+	stop      chan struct{}
+	closeOnce *sync.Once
 }
 
 // Set stores the given object for the given key.
+// If an expiring object was stored for the key before, the new one doesn't expire.
 func (c BoltClient) Set(k string, v interface{}) error {
 	// First turn the passed object into something that Bolt can handle
 	data, err := toJSON(v)
@@ -28,7 +41,10 @@ func (c BoltClient) Set(k string, v interface{}) error {
 	err = c.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 		err := b.Put([]byte(k), data)
-		return err
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(expiryBucketName)).Delete([]byte(k))
 	})
 	if err != nil {
 		return err
@@ -36,11 +52,41 @@ func (c BoltClient) Set(k string, v interface{}) error {
 	return nil
 }
 
+// SetWithTTL stores the given object for the given key, which expires after the given TTL.
+// If the TTL is 0 or negative, the object doesn't expire.
+// Expired objects aren't returned by Get anymore and are periodically deleted by a background goroutine.
+func (c BoltClient) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return c.Set(k, v)
+	}
+	data, err := toJSON(v)
+	if err != nil {
+		return err
+	}
+	expiry := make([]byte, 8)
+	binary.BigEndian.PutUint64(expiry, uint64(time.Now().Add(ttl).UnixNano()))
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		err := b.Put([]byte(k), data)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(expiryBucketName)).Put([]byte(k), expiry)
+	})
+}
+
 // Get retrieves the stored object for the given key and populates the fields of the object that v points to
 // with the values of the retrieved object's values.
 func (c BoltClient) Get(k string, v interface{}) (bool, error) {
 	var data []byte
 	err := c.db.View(func(tx *bolt.Tx) error {
+		if isExpired(tx, []byte(k)) {
+			return nil
+		}
 		b := tx.Bucket([]byte(bucketName))
 		data = b.Get([]byte(k))
 		return nil
@@ -57,11 +103,143 @@ func (c BoltClient) Get(k string, v interface{}) (bool, error) {
 	return true, fromJSON(data, v)
 }
 
+// Delete deletes the stored object for the given key.
+// Deleting a key that doesn't exist is not an error.
+func (c BoltClient) Delete(k string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return deleteKey(tx, []byte(k))
+	})
+}
+
+// CompareAndSwap stores the new object for the given key, but only if the currently stored object equals the old one.
+// If old is nil, the new object is only stored if no object exists for the key yet.
+// The expiry of the currently stored object is kept.
+// Returns true if the new object was stored.
+func (c BoltClient) CompareAndSwap(k string, old, new interface{}) (bool, error) {
+	oldData, newData, err := toJSONpair(old, new)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	swapped := false
+	// Comparing and storing in the same transaction makes the operation atomic.
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		// An expired object that wasn't deleted by the sweeper yet must be treated as if it doesn't exist.
+		if isExpired(tx, []byte(k)) {
+			err := deleteKey(tx, []byte(k))
+			if err != nil {
+				return err
+			}
+		}
+		b := tx.Bucket([]byte(bucketName))
+		data := b.Get([]byte(k))
+		if old == nil {
+			if data != nil {
+				return nil
+			}
+		} else if data == nil || !bytes.Equal(data, oldData) {
+			return nil
+		}
+		swapped = true
+		return b.Put([]byte(k), newData)
+	})
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// Close stops the background goroutine that deletes expired objects and closes the Bolt DB,
+// which releases the lock on the DB file. The BoltClient can't be used anymore afterwards.
+// Calling Close multiple times is not an error.
+func (c BoltClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		// Wait for a running sweep to finish
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		err = c.db.Close()
+	})
+	return err
+}
+
+// sweep periodically deletes expired objects until Close is called, so it must be executed in a goroutine.
+func (c BoltClient) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		c.lock.Lock()
+		select {
+		case <-c.stop:
+			// Close was called while waiting for the lock, so the DB might be closed already
+			c.lock.Unlock()
+			return
+		default:
+		}
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			// Keys must not be deleted while iterating over the bucket, so collect them first
+			var expiredKeys [][]byte
+			now := uint64(time.Now().UnixNano())
+			err := tx.Bucket([]byte(expiryBucketName)).ForEach(func(k, v []byte) error {
+				if now > binary.BigEndian.Uint64(v) {
+					// k is only valid during the transaction, but that's where it's used
+					expiredKeys = append(expiredKeys, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expiredKeys {
+				err = deleteKey(tx, k)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		c.lock.Unlock()
+		if err != nil {
+			c.logger.Error("Couldn't delete expired objects from the Bolt DB", "error", err)
+		}
+	}
+}
+
+// isExpired returns true if an expiry is stored for the given key and it passed.
+func isExpired(tx *bolt.Tx, k []byte) bool {
+	expiry := tx.Bucket([]byte(expiryBucketName)).Get(k)
+	return expiry != nil && uint64(time.Now().UnixNano()) > binary.BigEndian.Uint64(expiry)
+}
+
+// deleteKey deletes the object and its expiry for the given key.
+func deleteKey(tx *bolt.Tx, k []byte) error {
+	err := tx.Bucket([]byte(bucketName)).Delete(k)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(expiryBucketName)).Delete(k)
+}
+
 // BoltOptions are the options for the BoltClient.
 type BoltOptions struct {
 	// Path of the DB file.
 	// Optional ("ln-paywall.db" by default).
 	Path string
+	// Logger for errors that occur in the background, like during deleting expired objects.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
 }
 
 // DefaultBoltOptions is a BoltOptions object with default values.
@@ -80,16 +258,20 @@ var DefaultBoltOptions = BoltOptions{
 //  if err != nil {
 //      panic(err)
 //  }
-//  cheapPaywall := wall.NewGinMiddleware(cheapInvoiceOptions, lnClient, storageClient)
-//  expensivePaywall := wall.NewGinMiddleware(expensiveInvoiceOptions, lnClient, storageClient)
+//  cheapPaywall := wall.NewGinMiddleware(cheapInvoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions)
+//  expensivePaywall := wall.NewGinMiddleware(expensiveInvoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions)
 //  router.GET("/ping", cheapPaywall, pingHandler)
 //  router.GET("/compute", expensivePaywall, computeHandler)
 //  // ...
 // If you want to start an additional web service, this would be an additional process, so you can't use the same
 // DB file. You should look into the other storage options in this case, for example Redis.
 //
-// Don't worry about closing the Bolt DB, the middleware opens it once and uses it for the duration of its lifetime.
+// Usually you don't have to close the Bolt DB, because the middleware uses it for the duration of its lifetime.
 // When the web service is stopped, the DB file lock is released automatically.
+// If you create BoltClients that aren't needed until the web service is stopped, for example in tests, close them with Close.
+//
+// A goroutine is started that periodically deletes objects that were stored with SetWithTTL and expired.
+// It's stopped by Close.
 func NewBoltClient(boltOptions BoltOptions) (BoltClient, error) {
 	result := BoltClient{}
 
@@ -97,6 +279,9 @@ func NewBoltClient(boltOptions BoltOptions) (BoltClient, error) {
 	if boltOptions.Path == "" {
 		boltOptions.Path = DefaultBoltOptions.Path
 	}
+	if boltOptions.Logger == nil {
+		boltOptions.Logger = slog.Default()
+	}
 
 	// Open DB
 	db, err := bolt.Open(boltOptions.Path, 0600, nil)
@@ -104,13 +289,17 @@ func NewBoltClient(boltOptions BoltOptions) (BoltClient, error) {
 		return result, err
 	}
 
-	// Create a bucket if it doesn't exist yet.
+	// Create the buckets if they don't exist yet.
 	// In Bolt key/value pairs are stored to and read from buckets.
 	err = db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
 		if err != nil {
 			return err
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte(expiryBucketName))
+		if err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {
@@ -118,9 +307,13 @@ func NewBoltClient(boltOptions BoltOptions) (BoltClient, error) {
 	}
 
 	result = BoltClient{
-		db:   db,
-		lock: &sync.Mutex{},
+		db:        db,
+		lock:      &sync.Mutex{},
+		logger:    boltOptions.Logger,
+		stop:      make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
+	go result.sweep()
 
 	return result, nil
 }
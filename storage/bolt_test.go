@@ -17,12 +17,13 @@ import (
 func TestBoltClientImpl(t *testing.T) {
 	t.SkipNow()
 	invoiceOptions := wall.InvoiceOptions{}
+	middlewareOptions := wall.MiddlewareOptions{}
 	lnClient := ln.LNDclient{}
 	boltClient, _ := storage.NewBoltClient(storage.DefaultBoltOptions)
-	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, boltClient)
-	wall.NewHandlerMiddleware(invoiceOptions, lnClient, boltClient)
-	wall.NewGinMiddleware(invoiceOptions, lnClient, boltClient)
-	wall.NewEchoMiddleware(invoiceOptions, lnClient, boltClient, nil)
+	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, boltClient, middlewareOptions)
+	wall.NewHandlerMiddleware(invoiceOptions, lnClient, boltClient, middlewareOptions)
+	wall.NewGinMiddleware(invoiceOptions, lnClient, boltClient, middlewareOptions)
+	wall.NewEchoMiddleware(invoiceOptions, lnClient, boltClient, middlewareOptions, nil)
 }
 
 // TestBoltClient tests if reading and writing to the storage works properly.
@@ -38,6 +39,59 @@ func TestBoltClient(t *testing.T) {
 	testStorageClient(boltClient, t)
 }
 
+// TestBoltClientCompareAndSwap tests if the compare-and-swap operation works properly.
+func TestBoltClientCompareAndSwap(t *testing.T) {
+	boltOptions := storage.BoltOptions{
+		Path: generateRandomTempDbPath(),
+	}
+	boltClient, err := storage.NewBoltClient(boltOptions)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testCompareAndSwap(boltClient, t)
+}
+
+// TestBoltClientCompareAndSwapConcurrent tests if only one of multiple concurrent compare-and-swap operations succeeds.
+func TestBoltClientCompareAndSwapConcurrent(t *testing.T) {
+	boltOptions := storage.BoltOptions{
+		Path: generateRandomTempDbPath(),
+	}
+	boltClient, err := storage.NewBoltClient(boltOptions)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testCompareAndSwapConcurrent(boltClient, t)
+}
+
+// TestBoltClientTTL tests if expiring and deleting objects works properly.
+func TestBoltClientTTL(t *testing.T) {
+	boltOptions := storage.BoltOptions{
+		Path: generateRandomTempDbPath(),
+	}
+	boltClient, err := storage.NewBoltClient(boltOptions)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testExtendedStorageClient(boltClient, t)
+}
+
+// TestBoltClientClose tests if closing a BoltClient stops its goroutine for deleting expired objects.
+func TestBoltClientClose(t *testing.T) {
+	testClose(func() closableStorageClient {
+		boltOptions := storage.BoltOptions{
+			Path: generateRandomTempDbPath(),
+		}
+		boltClient, err := storage.NewBoltClient(boltOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return boltClient
+	}, t)
+}
+
 // TestBoltClientConcurrent launches a bunch of goroutines that concurrently work with one BoltClient.
 // The BoltClient works with a single file, so everything should be locked properly.
 // The locking is implemented in the bbolt package, but test it nonetheless.
@@ -1,38 +1,165 @@
 package storage
 
 import (
+	"bytes"
 	"sync"
+	"time"
 )
 
 // GoMap is a StorageClient implementation for a simple Go sync.Map.
 type GoMap struct {
-	m *sync.Map
+	m           *sync.Map
+	lock        *sync.Mutex
+	sweeperOnce *sync.Once
+	// Closed by Close for stopping the sweeper
+	stop      chan struct{}
+	closeOnce *sync.Once
+}
+
+// goMapEntry is a value that's stored in the sync.Map.
+type goMapEntry struct {
+	data []byte
+	// Zero if the entry doesn't expire.
+	expiresAt time.Time
+}
+
+// expired returns true if the entry has an expiry and it passed.
+func (e goMapEntry) expired() bool {
+	return !e.expiresAt.IsZero() && time.Now().After(e.expiresAt)
 }
 
 // Set stores the given object for the given key.
+// If an expiring object was stored for the key before, the new one doesn't expire.
 func (m GoMap) Set(k string, v interface{}) error {
 	data, err := toJSON(v)
 	if err != nil {
 		return err
 	}
-	m.m.Store(k, data)
+
+	// Required for CompareAndSwap to be atomic
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.m.Store(k, goMapEntry{data: data})
+	return nil
+}
+
+// SetWithTTL stores the given object for the given key, which expires after the given TTL.
+// If the TTL is 0 or negative, the object doesn't expire.
+// Expired objects aren't returned by Get anymore and are periodically deleted by a background goroutine,
+// which is started with the first call of this method and stopped by Close.
+func (m GoMap) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return m.Set(k, v)
+	}
+	data, err := toJSON(v)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.m.Store(k, goMapEntry{data: data, expiresAt: time.Now().Add(ttl)})
+	m.sweeperOnce.Do(func() {
+		go m.sweep()
+	})
 	return nil
 }
 
 // Get retrieves the stored object for the given key and populates the fields of the object that v points to
 // with the values of the retrieved object's values.
 func (m GoMap) Get(k string, v interface{}) (bool, error) {
-	data, found := m.m.Load(k)
-	if !found {
+	entry, found := m.m.Load(k)
+	if !found || entry.(goMapEntry).expired() {
 		return false, nil
 	}
 
-	return true, fromJSON(data.([]byte), v)
+	return true, fromJSON(entry.(goMapEntry).data, v)
+}
+
+// Delete deletes the stored object for the given key.
+// Deleting a key that doesn't exist is not an error.
+func (m GoMap) Delete(k string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.m.Delete(k)
+	return nil
+}
+
+// CompareAndSwap stores the new object for the given key, but only if the currently stored object equals the old one.
+// If old is nil, the new object is only stored if no object exists for the key yet.
+// The expiry of the currently stored object is kept.
+// Returns true if the new object was stored.
+func (m GoMap) CompareAndSwap(k string, old, new interface{}) (bool, error) {
+	oldData, newData, err := toJSONpair(old, new)
+	if err != nil {
+		return false, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var entry goMapEntry
+	value, found := m.m.Load(k)
+	if found {
+		entry = value.(goMapEntry)
+		found = !entry.expired()
+	}
+	if old == nil {
+		if found {
+			return false, nil
+		}
+		// Don't keep the expiry of an object that already expired
+		entry = goMapEntry{}
+	} else if !found || !bytes.Equal(entry.data, oldData) {
+		return false, nil
+	}
+	entry.data = newData
+	m.m.Store(k, entry)
+	return true, nil
+}
+
+// Close stops the background goroutine that deletes expired objects.
+// The GoMap can still be used afterwards, but expired objects aren't deleted anymore
+// (they're still not returned by Get though).
+// Calling Close multiple times is not an error.
+func (m GoMap) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+	return nil
+}
+
+// sweep periodically deletes expired objects until Close is called, so it must be executed in a goroutine.
+func (m GoMap) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		m.lock.Lock()
+		m.m.Range(func(k, v interface{}) bool {
+			if v.(goMapEntry).expired() {
+				m.m.Delete(k)
+			}
+			return true
+		})
+		m.lock.Unlock()
+	}
 }
 
 // NewGoMap creates a new GoMap.
 func NewGoMap() GoMap {
 	return GoMap{
-		m: &sync.Map{},
+		m:           &sync.Map{},
+		lock:        &sync.Mutex{},
+		sweeperOnce: &sync.Once{},
+		stop:        make(chan struct{}),
+		closeOnce:   &sync.Once{},
 	}
 }
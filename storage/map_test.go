@@ -15,11 +15,12 @@ import (
 func TestGoMapImpl(t *testing.T) {
 	t.SkipNow()
 	invoiceOptions := wall.InvoiceOptions{}
+	middlewareOptions := wall.MiddlewareOptions{}
 	lnClient := ln.LNDclient{}
 	goMap := storage.GoMap{}
-	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, goMap)
-	wall.NewHandlerMiddleware(invoiceOptions, lnClient, goMap)
-	wall.NewGinMiddleware(invoiceOptions, lnClient, goMap)
+	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, goMap, middlewareOptions)
+	wall.NewHandlerMiddleware(invoiceOptions, lnClient, goMap, middlewareOptions)
+	wall.NewGinMiddleware(invoiceOptions, lnClient, goMap, middlewareOptions)
 }
 
 // TestGoMap tests if reading and writing to the storage works properly.
@@ -29,6 +30,34 @@ func TestGoMap(t *testing.T) {
 	testStorageClient(goMap, t)
 }
 
+// TestGoMapCompareAndSwap tests if the compare-and-swap operation works properly.
+func TestGoMapCompareAndSwap(t *testing.T) {
+	goMap := storage.NewGoMap()
+
+	testCompareAndSwap(goMap, t)
+}
+
+// TestGoMapCompareAndSwapConcurrent tests if only one of multiple concurrent compare-and-swap operations succeeds.
+func TestGoMapCompareAndSwapConcurrent(t *testing.T) {
+	goMap := storage.NewGoMap()
+
+	testCompareAndSwapConcurrent(goMap, t)
+}
+
+// TestGoMapTTL tests if expiring and deleting objects works properly.
+func TestGoMapTTL(t *testing.T) {
+	goMap := storage.NewGoMap()
+
+	testExtendedStorageClient(goMap, t)
+}
+
+// TestGoMapClose tests if closing a GoMap stops its goroutine for deleting expired objects.
+func TestGoMapClose(t *testing.T) {
+	testClose(func() closableStorageClient {
+		return storage.NewGoMap()
+	}, t)
+}
+
 // TestGoMapConcurrent launches a bunch of goroutines that concurrently work with one GoMap.
 // The GoMap is a sync.Map, so the concurrency should be supported by the used package.
 func TestGoMapConcurrent(t *testing.T) {
@@ -1,15 +1,36 @@
 package storage
 
 import (
+	"time"
+
 	"github.com/go-redis/redis"
 )
 
+// compareAndSwapScript stores ARGV[2] for the key KEYS[1], but only if the currently stored value is ARGV[1]
+// or if ARGV[1] is empty and no value is stored for the key.
+// The TTL of the key is kept ("SET" removes it otherwise, and "KEEPTTL" requires Redis 6).
+// Lua scripts are executed atomically by Redis.
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if (ARGV[1] == "" and current == false) or current == ARGV[1] then
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ttl)
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0
+`)
+
 // RedisClient is a StorageClient implementation for Redis.
 type RedisClient struct {
 	c *redis.Client
 }
 
 // Set stores the given object for the given key.
+// If an expiring object was stored for the key before, the new one doesn't expire.
 func (c RedisClient) Set(k string, v interface{}) error {
 	// First turn the passed object into something that Redis can handle
 	// (the Set method takes an interface{}, but the Get method only returns a string,
@@ -27,6 +48,21 @@ func (c RedisClient) Set(k string, v interface{}) error {
 	return nil
 }
 
+// SetWithTTL stores the given object for the given key, which expires after the given TTL.
+// If the TTL is 0 or negative, the object doesn't expire.
+// The expiry is handled by Redis.
+func (c RedisClient) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return c.Set(k, v)
+	}
+	data, err := toJSON(v)
+	if err != nil {
+		return err
+	}
+
+	return c.c.Set(k, string(data), ttl).Err()
+}
+
 // Get retrieves the object for the given key and points the passed pointer to it.
 func (c RedisClient) Get(k string, v interface{}) (bool, error) {
 	data, err := c.c.Get(k).Result()
@@ -40,6 +76,29 @@ func (c RedisClient) Get(k string, v interface{}) (bool, error) {
 	return true, fromJSON([]byte(data), v)
 }
 
+// Delete deletes the stored object for the given key.
+// Deleting a key that doesn't exist is not an error.
+func (c RedisClient) Delete(k string) error {
+	return c.c.Del(k).Err()
+}
+
+// CompareAndSwap stores the new object for the given key, but only if the currently stored object equals the old one.
+// If old is nil, the new object is only stored if no object exists for the key yet.
+// The TTL of the currently stored object is kept.
+// Returns true if the new object was stored.
+func (c RedisClient) CompareAndSwap(k string, old, new interface{}) (bool, error) {
+	oldData, newData, err := toJSONpair(old, new)
+	if err != nil {
+		return false, err
+	}
+
+	swapped, err := compareAndSwapScript.Run(c.c, []string{k}, string(oldData), string(newData)).Int()
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
+
 // RedisOptions are the options for the Redis DB.
 type RedisOptions struct {
 	// Address of the Redis server, including the port.
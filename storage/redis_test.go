@@ -22,11 +22,12 @@ var testDbNumber = 15 // 16 DBs by default (unchanged config), starting with 0
 func TestRedisClientImpl(t *testing.T) {
 	t.SkipNow()
 	invoiceOptions := wall.InvoiceOptions{}
+	middlewareOptions := wall.MiddlewareOptions{}
 	lnClient := ln.LNDclient{}
 	redisClient := storage.RedisClient{}
-	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, redisClient)
-	wall.NewHandlerMiddleware(invoiceOptions, lnClient, redisClient)
-	wall.NewGinMiddleware(invoiceOptions, lnClient, redisClient)
+	wall.NewHandlerFuncMiddleware(invoiceOptions, lnClient, redisClient, middlewareOptions)
+	wall.NewHandlerMiddleware(invoiceOptions, lnClient, redisClient, middlewareOptions)
+	wall.NewGinMiddleware(invoiceOptions, lnClient, redisClient, middlewareOptions)
 }
 
 // TestRedisClient tests if reading and writing to the storage works properly.
@@ -46,6 +47,57 @@ func TestRedisClient(t *testing.T) {
 	testStorageClient(redisClient, t)
 }
 
+// TestRedisClientCompareAndSwap tests if the compare-and-swap operation works properly.
+//
+// Note: This test is only executed if the initial connection to Redis works.
+func TestRedisClientCompareAndSwap(t *testing.T) {
+	if !checkRedisConnection(testDbNumber) {
+		t.Skip("No connection to Redis could be established. Probably not running in a proper test environment.")
+	}
+
+	deleteRedisDb(testDbNumber) // Prep for previous test runs
+	redisOptions := storage.RedisOptions{
+		DB: testDbNumber,
+	}
+	redisClient := storage.NewRedisClient(redisOptions)
+
+	testCompareAndSwap(redisClient, t)
+}
+
+// TestRedisClientCompareAndSwapConcurrent tests if only one of multiple concurrent compare-and-swap operations succeeds.
+//
+// Note: This test is only executed if the initial connection to Redis works.
+func TestRedisClientCompareAndSwapConcurrent(t *testing.T) {
+	if !checkRedisConnection(testDbNumber) {
+		t.Skip("No connection to Redis could be established. Probably not running in a proper test environment.")
+	}
+
+	deleteRedisDb(testDbNumber) // Prep for previous test runs
+	redisOptions := storage.RedisOptions{
+		DB: testDbNumber,
+	}
+	redisClient := storage.NewRedisClient(redisOptions)
+
+	testCompareAndSwapConcurrent(redisClient, t)
+}
+
+// TestRedisClientTTL tests if expiring and deleting objects works properly.
+//
+// Note: This test is only executed if the initial connection to Redis works.
+func TestRedisClientTTL(t *testing.T) {
+	if !checkRedisConnection(testDbNumber) {
+		t.Skip("No connection to Redis could be established. Probably not running in a proper test environment.")
+	}
+
+	deleteRedisDb(testDbNumber) // Prep for previous test runs
+	redisOptions := storage.RedisOptions{
+		DB: testDbNumber,
+	}
+	redisClient := storage.NewRedisClient(redisOptions)
+
+	testExtendedStorageClient(redisClient, t)
+}
+
 // TestRedisClientConcurrent launches a bunch of goroutines that concurrently work with the Redis client.
 func TestRedisClientConcurrent(t *testing.T) {
 	if !checkRedisConnection(testDbNumber) {
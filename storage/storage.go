@@ -2,8 +2,13 @@ package storage
 
 import (
 	"encoding/json"
+	"time"
 )
 
+// sweepInterval is the interval in which the storage clients without native support for expiring objects
+// delete the expired ones.
+const sweepInterval = time.Minute
+
 func toJSON(v interface{}) ([]byte, error) {
 	return json.Marshal(v)
 }
@@ -11,3 +16,21 @@ func toJSON(v interface{}) ([]byte, error) {
 func fromJSON(data []byte, v interface{}) error {
 	return json.Unmarshal(data, v)
 }
+
+// toJSONpair converts the old and new objects of a compare-and-swap operation to JSON.
+// If old is nil, the returned JSON for it is nil as well.
+func toJSONpair(old, new interface{}) ([]byte, []byte, error) {
+	var oldData []byte
+	if old != nil {
+		var err error
+		oldData, err = toJSON(old)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	newData, err := toJSON(new)
+	if err != nil {
+		return nil, nil, err
+	}
+	return oldData, newData, nil
+}
@@ -2,9 +2,12 @@ package storage_test
 
 import (
 	"math/rand"
+	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/philippgille/ln-paywall/wall"
 )
@@ -51,6 +54,180 @@ func testStorageClient(storageClient wall.StorageClient, t *testing.T) {
 	}
 }
 
+// testCompareAndSwap tests if the compare-and-swap operation of the storage works properly.
+func testCompareAndSwap(storageClient wall.AtomicStorageClient, t *testing.T) {
+	key := strconv.FormatInt(rand.Int63(), 10)
+
+	// Initially the key shouldn't exist, so swapping with nil as old value should work
+	val := foo{
+		Bar: "baz",
+	}
+	swapped, err := storageClient.CompareAndSwap(key, nil, val)
+	if err != nil {
+		t.Error(err)
+	}
+	if !swapped {
+		t.Errorf("The value wasn't stored, but should have been")
+	}
+	// Now the key exists, so swapping with nil as old value shouldn't work anymore
+	swapped, err = storageClient.CompareAndSwap(key, nil, foo{Bar: "qux"})
+	if err != nil {
+		t.Error(err)
+	}
+	if swapped {
+		t.Errorf("The value was stored, but shouldn't have been")
+	}
+
+	// Swapping with a wrong old value shouldn't work
+	swapped, err = storageClient.CompareAndSwap(key, foo{Bar: "qux"}, foo{Bar: "quux"})
+	if err != nil {
+		t.Error(err)
+	}
+	if swapped {
+		t.Errorf("The value was stored, but shouldn't have been")
+	}
+
+	// Swapping with the correct old value should work
+	expected := foo{
+		Bar: "quux",
+	}
+	swapped, err = storageClient.CompareAndSwap(key, val, expected)
+	if err != nil {
+		t.Error(err)
+	}
+	if !swapped {
+		t.Errorf("The value wasn't stored, but should have been")
+	}
+	actualPtr := new(foo)
+	_, err = storageClient.Get(key, actualPtr)
+	if err != nil {
+		t.Error(err)
+	}
+	actual := *actualPtr
+	if actual != expected {
+		t.Errorf("Expected: %v, but was: %v", expected, actual)
+	}
+}
+
+// testCompareAndSwapConcurrent tests if only one of multiple concurrent compare-and-swap operations
+// with the same old value succeeds.
+func testCompareAndSwapConcurrent(storageClient wall.AtomicStorageClient, t *testing.T) {
+	key := strconv.FormatInt(rand.Int63(), 10)
+	old := foo{
+		Bar: "baz",
+	}
+	err := storageClient.Set(key, old)
+	if err != nil {
+		t.Error(err)
+	}
+
+	goroutineCount := 100
+	var swapCount int32
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(goroutineCount) // Must be called before any goroutine is started
+	for i := 0; i < goroutineCount; i++ {
+		go func(i int) {
+			defer waitGroup.Done()
+			swapped, err := storageClient.CompareAndSwap(key, old, foo{Bar: strconv.Itoa(i)})
+			if err != nil {
+				t.Error(err)
+			}
+			if swapped {
+				atomic.AddInt32(&swapCount, 1)
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+
+	if swapCount != 1 {
+		t.Errorf("Expected exactly one successful compare-and-swap, but there were %v", swapCount)
+	}
+}
+
+// testExtendedStorageClient tests if expiring and deleting objects works properly.
+func testExtendedStorageClient(storageClient wall.ExtendedStorageClient, t *testing.T) {
+	ttl := 500 * time.Millisecond
+	val := foo{
+		Bar: "baz",
+	}
+
+	// An object stored with a TTL should be found until it expires
+	expiringKey := strconv.FormatInt(rand.Int63(), 10)
+	err := storageClient.SetWithTTL(expiringKey, val, ttl)
+	if err != nil {
+		t.Error(err)
+	}
+	found, err := storageClient.Get(expiringKey, new(foo))
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Errorf("No value was found, but should have been")
+	}
+	// Compare-and-swap should keep the TTL
+	atomicKey := strconv.FormatInt(rand.Int63(), 10)
+	err = storageClient.SetWithTTL(atomicKey, val, ttl)
+	if err != nil {
+		t.Error(err)
+	}
+	if atomicStorageClient, ok := storageClient.(wall.AtomicStorageClient); ok {
+		swapped, err := atomicStorageClient.CompareAndSwap(atomicKey, val, foo{Bar: "qux"})
+		if err != nil {
+			t.Error(err)
+		}
+		if !swapped {
+			t.Errorf("The value wasn't stored, but should have been")
+		}
+	}
+	// Set should remove the TTL
+	persistentKey := strconv.FormatInt(rand.Int63(), 10)
+	err = storageClient.SetWithTTL(persistentKey, val, ttl)
+	if err != nil {
+		t.Error(err)
+	}
+	err = storageClient.Set(persistentKey, val)
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(2 * ttl)
+	for _, key := range []string{expiringKey, atomicKey} {
+		found, err = storageClient.Get(key, new(foo))
+		if err != nil {
+			t.Error(err)
+		}
+		if found {
+			t.Errorf("A value was found, but no value was expected")
+		}
+	}
+	found, err = storageClient.Get(persistentKey, new(foo))
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Errorf("No value was found, but should have been")
+	}
+
+	// Delete the object
+	err = storageClient.Delete(persistentKey)
+	if err != nil {
+		t.Error(err)
+	}
+	found, err = storageClient.Get(persistentKey, new(foo))
+	if err != nil {
+		t.Error(err)
+	}
+	if found {
+		t.Errorf("A value was found, but no value was expected")
+	}
+	// Deleting a key that doesn't exist shouldn't lead to an error
+	err = storageClient.Delete(persistentKey)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 // interactWithStorage reads from and writes to the DB. Meant to be executed in a goroutine.
 // Does NOT check if the DB works correctly (that's done elsewhere),
 // only checks for errors that might occur due to concurrent access.
@@ -73,3 +250,40 @@ func interactWithStorage(storageClient wall.StorageClient, key string, t *testin
 		t.Error(err)
 	}
 }
+
+// closableStorageClient is a storage client that runs a goroutine for deleting expired objects,
+// which is stopped by Close().
+type closableStorageClient interface {
+	wall.ExtendedStorageClient
+	Close() error
+}
+
+// testClose tests if closing storage clients stops their goroutines, so they don't leak.
+func testClose(newStorageClient func() closableStorageClient, t *testing.T) {
+	goroutineCountBefore := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		storageClient := newStorageClient()
+		err := storageClient.SetWithTTL("foo", foo{}, time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		err = storageClient.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		// Closing a second time shouldn't lead to an error or panic
+		err = storageClient.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// The goroutines return asynchronously
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutineCountBefore && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if goroutineCountAfter := runtime.NumGoroutine(); goroutineCountAfter > goroutineCountBefore {
+		t.Errorf("Expected at most %v goroutines, but there were %v\n", goroutineCountBefore, goroutineCountAfter)
+	}
+}
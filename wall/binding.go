@@ -0,0 +1,63 @@
+package wall
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// hashRequest calculates a canonical hash of the query string, the given headers and the body of the request.
+// It's used for binding an invoice to the full request (see InvoiceOptions.BindRequest).
+//
+// The query parameters are sorted by key and the headers by their canonical name,
+// so the order in which a client sends them doesn't matter.
+// The "X-Preimage" header is ignored, because it's only sent in the final request.
+//
+// The request body is read completely and replaced by an unread copy,
+// so it's still available to other middlewares and the final request handler.
+func hashRequest(req *http.Request, headerNames []string) (string, error) {
+	hash := sha256.New()
+
+	// Query string. url.Values.Encode() sorts by key.
+	hash.Write([]byte("query\n"))
+	hash.Write([]byte(req.URL.Query().Encode()))
+	hash.Write([]byte("\n"))
+
+	// Headers
+	canonicalNames := make([]string, 0, len(headerNames))
+	seen := make(map[string]bool)
+	for _, headerName := range headerNames {
+		canonicalName := http.CanonicalHeaderKey(headerName)
+		if canonicalName == "X-Preimage" || seen[canonicalName] {
+			continue
+		}
+		seen[canonicalName] = true
+		canonicalNames = append(canonicalNames, canonicalName)
+	}
+	sort.Strings(canonicalNames)
+	hash.Write([]byte("headers\n"))
+	for _, canonicalName := range canonicalNames {
+		hash.Write([]byte(canonicalName + ":" + strings.Join(req.Header[canonicalName], ",") + "\n"))
+	}
+
+	// Body
+	hash.Write([]byte("body\n"))
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		err = req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,74 @@
+package wall_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestBindRequest tests if a preimage is rejected for a request with a different query string, bound header or body
+// than the request that the invoice was created for, and if it's accepted for the same request,
+// with the body still being readable by the next handler.
+func TestBindRequest(t *testing.T) {
+	node := newTestNode(t)
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.BindRequest = true
+	invoiceOptions.BindHeaders = []string{"x-size"}
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the body
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	send := func(query string, size string, body string, preimage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/upload?"+query, strings.NewReader(body))
+		req.Header.Set("X-Size", size)
+		req.Header.Set("X-Preimage", preimage)
+		req.Header.Set("Accept", "application/json")
+		res := httptest.NewRecorder()
+		handlerFunc(res, req)
+		return res
+	}
+
+	res := send("a=1&b=2", "small", "small payload", "")
+	if res.Code != http.StatusPaymentRequired {
+		t.Fatalf("Expected status code %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+	}
+	invoice := jsonInvoice{}
+	err := json.Unmarshal(res.Body.Bytes(), &invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage, err := node.Pay(invoice.Invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatch := `{"code":"request_mismatch","message":"Your invoice was created for a request with a different query string, headers or body than the request you're sending"}`
+	testCases := []struct {
+		query          string
+		size           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"a=1&b=3", "small", "small payload", http.StatusBadRequest, mismatch},
+		{"a=1", "small", "small payload", http.StatusBadRequest, mismatch},
+		{"a=1&b=2", "large", "small payload", http.StatusBadRequest, mismatch},
+		{"a=1&b=2", "small", "large payload", http.StatusBadRequest, mismatch},
+		{"a=1&b=2", "small", "", http.StatusBadRequest, mismatch},
+		// The order of the query parameters doesn't matter
+		{"b=2&a=1", "small", "small payload", http.StatusOK, "small payload"},
+	}
+	for _, testCase := range testCases {
+		res = send(testCase.query, testCase.size, testCase.body, preimage)
+		if res.Code != testCase.expectedStatus || res.Body.String() != testCase.expectedBody {
+			t.Errorf("Expected (%v, %v), but was (%v, %v)\n", testCase.expectedStatus, testCase.expectedBody, res.Code, res.Body.String())
+		}
+	}
+}
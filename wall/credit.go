@@ -0,0 +1,201 @@
+package wall
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// maxBalanceUpdateAttempts is the maximum number of attempts for atomically updating a credit balance
+// in case of concurrent updates by other requests.
+const maxBalanceUpdateAttempts = 10
+
+// CreditOptions are the options for prepaid credit balances.
+//
+// Instead of paying one invoice per request, the client pays one invoice to buy a balance of Satoshis,
+// which is then spent over many requests.
+// After the invoice was paid and the client sent the preimage in the "X-Preimage" header (as usual),
+// the response contains an "X-Credit-Token" header with a token and an "X-Credit-Balance" header with the remaining balance.
+// In subsequent requests the client only sends the token in the "X-Credit-Token" header.
+// The price of each request (see InvoiceOptions) is deducted from the balance.
+// When the balance is too low for a request, the response is a "402 Payment Required" with a top-up invoice.
+// For topping up, the client sends both the preimage and the token.
+// Browsers that paid via the HTML paywall page get the token in a cookie instead (see HTMLOptions).
+//
+// The balance is updated atomically if the storage client implements AtomicStorageClient,
+// which all storage clients in the storage package do.
+type CreditOptions struct {
+	// Amount of Satoshis that one invoice buys.
+	// If it's lower than the price of the request that leads to the invoice, that price is used instead.
+	// Optional (if 0, Calls is used).
+	Amount int64
+	// Number of requests that one invoice buys.
+	// The invoice amount is the price of the request that leads to the invoice, multiplied by Calls.
+	// Only used if Amount is 0.
+	// Optional (10 by default).
+	Calls int64
+}
+
+// DefaultCreditOptions provides default values for CreditOptions.
+var DefaultCreditOptions = CreditOptions{
+	Calls: 10,
+}
+
+func assignCreditDefaultValues(creditOptions CreditOptions) CreditOptions {
+	if creditOptions.Amount < 0 {
+		creditOptions.Amount = 0
+	}
+	if creditOptions.Calls <= 0 {
+		creditOptions.Calls = DefaultCreditOptions.Calls
+	}
+
+	return creditOptions
+}
+
+// creditBalance is the balance of a credit token.
+// It's stored with the key returned by getCreditKey.
+// The type itself is not exported, but the fields have to be (for (un-)marshaling).
+type creditBalance struct {
+	Balance int64
+}
+
+// getPurchaseAmount returns the amount of Satoshis that one invoice buys, given the price of the current request.
+func (co CreditOptions) getPurchaseAmount(price int64) int64 {
+	if co.Amount == 0 {
+		return price * co.Calls
+	}
+	if co.Amount < price {
+		return price
+	}
+	return co.Amount
+}
+
+// getCreditKey returns the storage key for the balance of the given credit token.
+func getCreditKey(token string) string {
+	return "credit:" + hashToken(token)
+}
+
+// changeCreditBalance adds delta (which can be negative) to the balance stored for the given key.
+// A balance that doesn't exist yet is treated as 0.
+// The balance is only changed if the result isn't negative.
+// Returns the new balance (or the unchanged one, if the result would've been negative)
+// and true if the balance was changed.
+func changeCreditBalance(storageClient StorageClient, key string, delta int64) (int64, bool, error) {
+	atomicStorageClient, isAtomic := storageClient.(AtomicStorageClient)
+	for i := 0; i < maxBalanceUpdateAttempts; i++ {
+		balance := new(creditBalance)
+		found, err := storageClient.Get(key, balance)
+		if err != nil {
+			return 0, false, err
+		}
+		newBalance := creditBalance{
+			Balance: balance.Balance + delta,
+		}
+		if newBalance.Balance < 0 {
+			return balance.Balance, false, nil
+		}
+
+		if !isAtomic {
+			return newBalance.Balance, true, storageClient.Set(key, newBalance)
+		}
+		var old interface{}
+		if found {
+			old = *balance
+		}
+		swapped, err := atomicStorageClient.CompareAndSwap(key, old, newBalance)
+		if err != nil {
+			return 0, false, err
+		}
+		if swapped {
+			return newBalance.Balance, true, nil
+		}
+		// Another request changed the balance in the meantime, so try again
+	}
+	return 0, false, errors.New("Couldn't update the credit balance because of too many concurrent updates")
+}
+
+// handleCreditToken deducts the price of the current request from the balance of the given credit token.
+// If the balance is too low, it responds with a top-up invoice.
+func handleCreditToken(fa frameworkAbstraction, token string, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient) error {
+	price, _, err := getPriceAndMemo(fa.getHTTPrequest(), invoiceOptions)
+	if err != nil {
+		respondWithPricingError(fa, err, middlewareOptions.Logger)
+		return nil
+	}
+
+	creditKey := getCreditKey(token)
+	balance, ok, err := changeCreditBalance(storageClient, creditKey, -price)
+	if err != nil {
+		errorMsg := fmt.Sprintf("An error occurred during updating the credit balance: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't update the credit balance", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+	if !ok {
+		middlewareOptions.Logger.Info("The credit balance is too low for the request, sending top-up invoice", "balance", balance, "price", price)
+		reportRejection(fa, "The credit balance is too low for the request", invoiceOptions, middlewareOptions)
+		respondWithNewInvoice(fa, invoiceOptions, middlewareOptions, lnClient, storageClient, creditKey)
+		return nil
+	}
+
+	middlewareOptions.Logger.Info("Deducted the price from the credit balance, continuing to the next handler", "outcome", "accepted", "price", price, "balance", balance)
+	fa.setResponseHeader("X-Credit-Balance", strconv.FormatInt(balance, 10))
+	return fa.next()
+}
+
+// redeemCredit adds the amount that was paid with an invoice for prepaid credits to the corresponding balance
+// and deducts the price of the current request.
+// If the invoice wasn't for topping up an existing balance, a new credit token is generated and sent to the client.
+// If the price of the request rose since the invoice was created and the balance is too low for it,
+// the paid amount stays on the balance and the response is a top-up invoice.
+func redeemCredit(fa frameworkAbstraction, metaData *invoiceMetaData, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient) error {
+	price, _, err := getPriceAndMemo(fa.getHTTPrequest(), invoiceOptions)
+	if err != nil {
+		respondWithPricingError(fa, err, middlewareOptions.Logger)
+		return nil
+	}
+
+	creditKey := metaData.CreditKey
+	if creditKey == "" {
+		token, err := newToken()
+		if err != nil {
+			errorMsg := fmt.Sprintf("Couldn't generate credit token: %+v", err)
+			middlewareOptions.Logger.Error("Couldn't generate credit token", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+			return nil
+		}
+		creditKey = getCreditKey(token)
+		// The token is sent with every response from here on, because the paid amount is added to its balance
+		fa.setResponseHeader("X-Credit-Token", token)
+		setTokenCookie(fa, middlewareOptions.HTML, creditCookieSuffix, token, creditCookieMaxAge)
+	}
+
+	// Add the paid amount first, so it's not lost if the price can't be deducted
+	balance, _, err := changeCreditBalance(storageClient, creditKey, metaData.CreditAmount)
+	if err != nil {
+		errorMsg := fmt.Sprintf("An error occurred during updating the credit balance: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't update the credit balance", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+	middlewareOptions.Logger.Info("Added the paid amount to the credit balance", "outcome", "redeemed", "amount", metaData.CreditAmount, "balance", balance)
+
+	balance, ok, err := changeCreditBalance(storageClient, creditKey, -price)
+	if err != nil {
+		errorMsg := fmt.Sprintf("An error occurred during updating the credit balance: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't update the credit balance", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+	fa.setResponseHeader("X-Credit-Balance", strconv.FormatInt(balance, 10))
+	if !ok {
+		// Only happens if the price of the request is higher than when the invoice was created
+		middlewareOptions.Logger.Info("The credit balance is too low for the request, sending top-up invoice", "balance", balance, "price", price)
+		respondWithNewInvoice(fa, invoiceOptions, middlewareOptions, lnClient, storageClient, creditKey)
+		return nil
+	}
+
+	middlewareOptions.Logger.Info("Deducted the price from the credit balance, continuing to the next handler", "outcome", "accepted", "price", price, "balance", balance)
+	return fa.next()
+}
@@ -0,0 +1,158 @@
+package wall_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// newCreditTestHandlerFunc returns a handler func with the middleware with prepaid credits in front of it,
+// which responds with "pong" when the request was paid.
+func newCreditTestHandlerFunc(node *lntest.Node, creditOptions wall.CreditOptions) http.HandlerFunc {
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Credit = &creditOptions
+	return wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+}
+
+// sendCredit sends a request with the given credit token and preimage (each only if it's not empty).
+func sendCredit(handlerFunc http.HandlerFunc, token string, preimage string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		req.Header.Set("X-Credit-Token", token)
+	}
+	if preimage != "" {
+		req.Header.Set("X-Preimage", preimage)
+	}
+	res := httptest.NewRecorder()
+	handlerFunc(res, req)
+	return res
+}
+
+// buyCredit pays the invoice of the given "402 Payment Required" response and redeems it,
+// and returns the response of the redeeming request.
+func buyCredit(t *testing.T, node *lntest.Node, handlerFunc http.HandlerFunc, res *httptest.ResponseRecorder, token string) *httptest.ResponseRecorder {
+	if res.Code != http.StatusPaymentRequired {
+		t.Fatalf("Expected status code %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+	}
+	preimage, err := node.Pay(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sendCredit(handlerFunc, token, preimage)
+}
+
+// TestCredit tests buying credits, spending them over multiple requests and topping up the balance.
+func TestCredit(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newCreditTestHandlerFunc(node, wall.CreditOptions{Calls: 3})
+
+	// Buying credits for 3 requests includes the current one
+	res := buyCredit(t, node, handlerFunc, sendCredit(handlerFunc, "", ""), "")
+	token := res.Header().Get("X-Credit-Token")
+	if res.Code != http.StatusOK || token == "" || res.Header().Get("X-Credit-Balance") != "2" {
+		t.Fatalf("Expected (%v, a token, %v), but was (%v, %v, %v)\n", http.StatusOK, "2", res.Code, token, res.Header().Get("X-Credit-Balance"))
+	}
+
+	// The price is deducted from the balance
+	for _, expectedBalance := range []string{"1", "0"} {
+		res = sendCredit(handlerFunc, token, "")
+		if res.Code != http.StatusOK || res.Header().Get("X-Credit-Balance") != expectedBalance {
+			t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, expectedBalance, res.Code, res.Header().Get("X-Credit-Balance"))
+		}
+	}
+
+	// An insufficient balance leads to a top-up invoice, which is redeemed with the token and the preimage
+	res = sendCredit(handlerFunc, token, "")
+	res = buyCredit(t, node, handlerFunc, res, token)
+	if res.Code != http.StatusOK || res.Header().Get("X-Credit-Token") != "" || res.Header().Get("X-Credit-Balance") != "2" {
+		t.Errorf("Expected (%v, no new token, %v), but was (%v, %v, %v)\n", http.StatusOK, "2", res.Code,
+			res.Header().Get("X-Credit-Token"), res.Header().Get("X-Credit-Balance"))
+	}
+	res = sendCredit(handlerFunc, token, "")
+	if res.Code != http.StatusOK || res.Header().Get("X-Credit-Balance") != "1" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "1", res.Code, res.Header().Get("X-Credit-Balance"))
+	}
+
+	// Unknown tokens have no balance
+	res = sendCredit(handlerFunc, "unknown", "")
+	if res.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+	}
+}
+
+// TestCreditConcurrent sends many concurrent requests with the same credit token
+// and tests if the balance isn't overdrawn.
+func TestCreditConcurrent(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newCreditTestHandlerFunc(node, wall.CreditOptions{Calls: 10})
+	res := buyCredit(t, node, handlerFunc, sendCredit(handlerFunc, "", ""), "")
+	token := res.Header().Get("X-Credit-Token")
+	if res.Code != http.StatusOK || token == "" {
+		t.Fatalf("Expected (%v, a token), but was (%v, %v)\n", http.StatusOK, res.Code, token)
+	}
+
+	goroutineCount := 50
+	var successCount int32
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(goroutineCount)
+	for i := 0; i < goroutineCount; i++ {
+		go func() {
+			defer waitGroup.Done()
+			res := sendCredit(handlerFunc, token, "")
+			// Requests that exceed the maximum number of update attempts lead to an internal server error,
+			// which doesn't change the balance
+			if res.Code == http.StatusOK {
+				atomic.AddInt32(&successCount, 1)
+			} else if res.Code != http.StatusPaymentRequired && res.Code != http.StatusInternalServerError {
+				t.Errorf("Expected status code %v, %v or %v, but was %v\n", http.StatusOK, http.StatusPaymentRequired, http.StatusInternalServerError, res.Code)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	// Spend the rest of the balance, if there's any
+	for sendCredit(handlerFunc, token, "").Code == http.StatusOK {
+		successCount++
+	}
+	if successCount != 9 {
+		t.Errorf("Expected the remaining balance to be spent by exactly %v requests, but there were %v\n", 9, successCount)
+	}
+}
+
+// TestCreditPriceIncrease tests if the paid amount is kept and a top-up invoice is sent
+// when the price of the request rose between creating and redeeming the invoice.
+func TestCreditPriceIncrease(t *testing.T) {
+	node := newTestNode(t)
+	var price int64 = 2
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.PricingFunc = func(*http.Request) (int64, string, error) {
+		return atomic.LoadInt64(&price), "API call", nil
+	}
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Credit = &wall.CreditOptions{Amount: 5}
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	res := sendCredit(handlerFunc, "", "")
+	atomic.StoreInt64(&price, 8)
+	res = buyCredit(t, node, handlerFunc, res, "")
+	token := res.Header().Get("X-Credit-Token")
+	if res.Code != http.StatusPaymentRequired || token == "" || res.Header().Get("X-Credit-Balance") != "5" {
+		t.Fatalf("Expected (%v, a token, %v), but was (%v, %v, %v)\n", http.StatusPaymentRequired, "5", res.Code, token, res.Header().Get("X-Credit-Balance"))
+	}
+
+	// The top-up invoice is for the new price, and the paid amount is still on the balance
+	res = buyCredit(t, node, handlerFunc, res, token)
+	if res.Code != http.StatusOK || res.Body.String() != "pong" || res.Header().Get("X-Credit-Balance") != "5" {
+		t.Errorf("Expected (%v, %v, %v), but was (%v, %v, %v)\n", http.StatusOK, "pong", "5", res.Code, res.Body.String(), res.Header().Get("X-Credit-Balance"))
+	}
+}
@@ -34,7 +34,7 @@ For more examples check out the "examples" directory in the GitHub repository of
 			panic(err)
 		}
 		// Use middleware
-		r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient))
+		r.Use(wall.NewGinMiddleware(invoiceOptions, lnClient, storageClient, wall.DefaultMiddlewareOptions))
 
 		r.GET("/ping", func(c *gin.Context) {
 			c.String(http.StatusOK, "pong")
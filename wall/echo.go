@@ -8,8 +8,9 @@ import (
 )
 
 // NewEchoMiddleware returns an Echo middleware in the form of an echo.MiddlewareFunc.
-func NewEchoMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, skipper middleware.Skipper) echo.MiddlewareFunc {
+func NewEchoMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, middlewareOptions MiddlewareOptions, skipper middleware.Skipper) echo.MiddlewareFunc {
 	invoiceOptions = assignDefaultValues(invoiceOptions)
+	middlewareOptions = assignMiddlewareDefaultValues(middlewareOptions)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		if skipper == nil {
 			skipper = middleware.DefaultSkipper
@@ -22,7 +23,7 @@ func NewEchoMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storage
 				ctx:         ctx,
 				nextHandler: next,
 			}
-			return commonHandler(fa, invoiceOptions, lnClient, storageClient)
+			return commonHandler(fa, invoiceOptions, middlewareOptions, lnClient, storageClient)
 		}
 	}
 }
@@ -44,13 +45,21 @@ func (fa echoAbstraction) getHTTPrequest() *http.Request {
 	return fa.ctx.Request()
 }
 
-func (fa echoAbstraction) respondWithInvoice(headers map[string]string, statusCode int, body []byte) {
+func (fa echoAbstraction) respond(headers map[string]string, statusCode int, body []byte) {
 	for k, v := range headers {
 		fa.ctx.Response().Header().Set(k, v)
 	}
 	fa.ctx.String(statusCode, string(body))
 }
 
+func (fa echoAbstraction) setResponseHeader(key string, value string) {
+	fa.ctx.Response().Header().Set(key, value)
+}
+
+func (fa echoAbstraction) setCookie(cookie *http.Cookie) {
+	fa.ctx.SetCookie(cookie)
+}
+
 func (fa echoAbstraction) next() error {
 	return fa.nextHandler(fa.ctx)
 }
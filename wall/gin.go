@@ -7,13 +7,14 @@ import (
 )
 
 // NewGinMiddleware returns a Gin middleware in the form of a gin.HandlerFunc.
-func NewGinMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient) gin.HandlerFunc {
+func NewGinMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, middlewareOptions MiddlewareOptions) gin.HandlerFunc {
 	invoiceOptions = assignDefaultValues(invoiceOptions)
+	middlewareOptions = assignMiddlewareDefaultValues(middlewareOptions)
 	return func(ctx *gin.Context) {
 		fa := ginAbstraction{
 			ctx: ctx,
 		}
-		commonHandler(fa, invoiceOptions, lnClient, storageClient)
+		commonHandler(fa, invoiceOptions, middlewareOptions, lnClient, storageClient)
 	}
 }
 
@@ -34,7 +35,7 @@ func (fa ginAbstraction) getHTTPrequest() *http.Request {
 	return fa.ctx.Request
 }
 
-func (fa ginAbstraction) respondWithInvoice(headers map[string]string, statusCode int, body []byte) {
+func (fa ginAbstraction) respond(headers map[string]string, statusCode int, body []byte) {
 	for k, v := range headers {
 		fa.ctx.Header(k, v)
 	}
@@ -44,6 +45,14 @@ func (fa ginAbstraction) respondWithInvoice(headers map[string]string, statusCod
 	fa.ctx.Abort()
 }
 
+func (fa ginAbstraction) setResponseHeader(key string, value string) {
+	fa.ctx.Header(key, value)
+}
+
+func (fa ginAbstraction) setCookie(cookie *http.Cookie) {
+	http.SetCookie(fa.ctx.Writer, cookie)
+}
+
 func (fa ginAbstraction) next() error {
 	fa.ctx.Next()
 	return nil
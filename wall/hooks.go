@@ -0,0 +1,41 @@
+package wall
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/philippgille/ln-paywall/ln"
+)
+
+// Hooks is an interface for reacting to events of the middleware,
+// for example for recording revenue per customer, triggering fulfilment or alerting on abuse.
+// Each method gets the context of the HTTP request (which is the same as the request's Context()),
+// so values that previous middlewares attached to it, like a user ID, are available.
+// The methods are called synchronously while a request is handled, before the response is sent,
+// so they should return quickly. They're called concurrently for concurrent requests.
+type Hooks interface {
+	// InvoiceGenerated is called when an invoice was generated for the request, before it's sent to the client.
+	InvoiceGenerated(ctx context.Context, req *http.Request, invoice ln.Invoice)
+	// PreimageRedeemed is called when the request's preimage of a paid invoice was accepted,
+	// with the payment hash and price (in Satoshis) of the invoice.
+	// It's called in the same cases as Metrics.InvoiceRedeemed.
+	// For a preimage that's not redeemed for a pass or credits, it's called before the request is passed to the next handler.
+	PreimageRedeemed(ctx context.Context, req *http.Request, paymentHash string, amount int64)
+	// CredentialAccepted is called when the request's L402 credential that isn't single-use was accepted,
+	// with the payment hash of the credential's invoice, before the request is passed to the next handler.
+	// It's called in the same cases as Metrics.CredentialAccepted.
+	CredentialAccepted(ctx context.Context, req *http.Request, paymentHash string)
+	// RedemptionRejected is called when the request's preimage was rejected, before the response is sent to the client.
+	// It's called in the same cases as Metrics.PreimageRejected.
+	// The reason is a message like the one that's sent to the client, but without details about the request,
+	// for example "The invoice expired before it was paid. Send a request without preimage to get a new invoice.".
+	RedemptionRejected(ctx context.Context, req *http.Request, reason string)
+}
+
+// noopHooks is the Hooks implementation that's used when MiddlewareOptions.Hooks isn't set.
+type noopHooks struct{}
+
+func (noopHooks) InvoiceGenerated(context.Context, *http.Request, ln.Invoice)    {}
+func (noopHooks) PreimageRedeemed(context.Context, *http.Request, string, int64) {}
+func (noopHooks) CredentialAccepted(context.Context, *http.Request, string)      {}
+func (noopHooks) RedemptionRejected(context.Context, *http.Request, string)      {}
@@ -0,0 +1,260 @@
+package wall
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// HTMLOptions are the options for the HTML paywall page.
+//
+// When a browser sends a GET request (with "text/html" in the "Accept" header) without a credential,
+// the middleware responds with an HTML page instead of the plain invoice.
+// The page shows the invoice as QR code and "lightning:" link and polls the status handler (see NewStatusHandler)
+// until the invoice is paid. Then it reloads the page, and the middleware redeems the invoice
+// with the help of a cookie that was set together with the page.
+// The cookie contains a random token, so only the browser that got the page can redeem the invoice this way.
+// If the invoice was for a pass or prepaid credits, their token is then stored in another cookie
+// (named like the cookie above, with the suffix "-pass" or "-credit"), because browsers don't send the
+// "X-Pass-Token" and "X-Credit-Token" headers.
+type HTMLOptions struct {
+	// URL of the status handler, which the page long-polls until the invoice is paid.
+	// The handler is created with NewStatusHandler and must be reachable without payment.
+	// Optional ("/ln-paywall/status" by default).
+	StatusURL string
+	// Template for the page. It's executed with an HTMLPageData object.
+	// Optional (a built-in template by default).
+	Template *template.Template
+	// Name of the cookie that's used for redeeming the invoice after it was paid.
+	// Optional ("ln-paywall" by default).
+	CookieName string
+}
+
+// DefaultHTMLOptions provides default values for HTMLOptions.
+var DefaultHTMLOptions = HTMLOptions{
+	StatusURL:  "/ln-paywall/status",
+	Template:   defaultHTMLTemplate,
+	CookieName: "ln-paywall",
+}
+
+func assignHTMLDefaultValues(htmlOptions HTMLOptions) HTMLOptions {
+	if htmlOptions.StatusURL == "" {
+		htmlOptions.StatusURL = DefaultHTMLOptions.StatusURL
+	}
+	if htmlOptions.Template == nil {
+		htmlOptions.Template = DefaultHTMLOptions.Template
+	}
+	if htmlOptions.CookieName == "" {
+		htmlOptions.CookieName = DefaultHTMLOptions.CookieName
+	}
+
+	return htmlOptions
+}
+
+// HTMLPageData is the data that the template of the HTML paywall page is executed with.
+type HTMLPageData struct {
+	// The invoice (a.k.a. payment request).
+	Invoice string
+	// Hex encoded payment hash of the invoice.
+	PaymentHash string
+	// Price of the invoice in Satoshis.
+	Amount int64
+	// Memo of the invoice.
+	Memo string
+	// Time at which the invoice expires.
+	ExpiresAt time.Time
+	// "lightning:" URL of the invoice, which opens it in a Lightning wallet.
+	LightningURL template.URL
+	// QR code of the "lightning:" URL, as PNG image in a data URL, which can be used as "src" of an "img" element.
+	QRCode template.URL
+	// URL of the status handler (see HTMLOptions).
+	StatusURL string
+}
+
+// wantsHTML returns true if the request is a browser request for a page, which should get the HTML paywall page.
+// Clients that explicitly accept JSON get a JSON response instead.
+func wantsHTML(req *http.Request) bool {
+	return req.Method == http.MethodGet && acceptsMediaType(req, "text/html") && !acceptsMediaType(req, "application/json")
+}
+
+// respondWithHTMLpage sends the HTML paywall page in a "402 Payment Required" response,
+// together with the cookie that's used for redeeming the invoice after it was paid.
+// The fields of the page data that are derived from the invoice or the HTMLOptions are set by this function.
+func respondWithHTMLpage(fa frameworkAbstraction, headers map[string]string, htmlOptions HTMLOptions, data HTMLPageData, cookie *http.Cookie) {
+	data.LightningURL = template.URL("lightning:" + data.Invoice)
+	// Uppercase letters are encoded more efficiently in QR codes, and invoices and URL schemes are case insensitive
+	qrCode, err := qrcode.Encode(strings.ToUpper(string(data.LightningURL)), qrcode.Medium, 256)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't create the QR code: %+v", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	data.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode))
+	data.StatusURL = htmlOptions.StatusURL
+
+	body := new(bytes.Buffer)
+	err = htmlOptions.Template.Execute(body, data)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't render the paywall page: %+v", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	headers["Content-Type"] = "text/html; charset=utf-8"
+	headers["Cache-Control"] = "no-store"
+	fa.setCookie(cookie)
+	fa.respond(headers, http.StatusPaymentRequired, body.Bytes())
+}
+
+const (
+	// passCookieSuffix is appended to HTMLOptions.CookieName for the name of the cookie with a pass token.
+	passCookieSuffix = "-pass"
+	// creditCookieSuffix is appended to HTMLOptions.CookieName for the name of the cookie with a credit token.
+	creditCookieSuffix = "-credit"
+	// creditCookieMaxAge is the lifetime of the cookie with a credit token.
+	// Credit balances don't expire, but the cookie shouldn't be deleted when the browser is closed.
+	creditCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// newCookie returns the cookie for redeeming the invoice with the given payment hash after it was paid.
+// Its value is the payment hash and the token, separated by a dot.
+func newCookie(req *http.Request, htmlOptions HTMLOptions, paymentHash string, token string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     htmlOptions.CookieName,
+		Value:    paymentHash + "." + token,
+		Path:     req.URL.Path,
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// expiredCookie returns a cookie that makes the browser delete the cookie for the current request path.
+func expiredCookie(req *http.Request, htmlOptions HTMLOptions) *http.Cookie {
+	return &http.Cookie{
+		Name:     htmlOptions.CookieName,
+		Path:     req.URL.Path,
+		MaxAge:   -1,
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// setTokenCookie sets a cookie with the given pass or credit token if the HTML paywall page is enabled and the request is from a browser.
+// The cookie is sent for all paths, because passes and credits can be used for other paths than the one of the current request.
+func setTokenCookie(fa frameworkAbstraction, htmlOptions *HTMLOptions, suffix string, token string, maxAge time.Duration) {
+	if htmlOptions == nil || !wantsHTML(fa.getHTTPrequest()) {
+		return
+	}
+	fa.setCookie(&http.Cookie{
+		Name:     htmlOptions.CookieName + suffix,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   fa.getHTTPrequest().TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getTokenCookie returns the pass or credit token of the cookie with the given suffix,
+// or an empty string if the request doesn't have it.
+func getTokenCookie(req *http.Request, htmlOptions HTMLOptions, suffix string) string {
+	cookie, err := req.Cookie(htmlOptions.CookieName + suffix)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// getCookieCredential returns the value of the cookie for redeeming an invoice, or an empty string if the request doesn't have it.
+func getCookieCredential(req *http.Request, htmlOptions HTMLOptions) string {
+	cookie, err := req.Cookie(htmlOptions.CookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// handleCookieCredential checks if the invoice of the cookie credential can be redeemed for the current request.
+// It does the same checks as handlePreimage, but instead of checking a preimage it checks the token of the cookie.
+// Returns the payment hash of the invoice, and the invoice metadata, a rejection and an error with the same meaning as in handlePreimage.
+func handleCookieCredential(req *http.Request, credential string, invoiceOptions InvoiceOptions, storageClient StorageClient, lnClient LNclient) (string, *invoiceMetaData, rejection, error) {
+	invalidCookie := newRejection(ErrorCodeCredentialInvalid, "The cookie isn't valid")
+	paymentHash, token, _ := strings.Cut(credential, ".")
+	// The same format as a preimage
+	if validatePreimageFormat(paymentHash).reason != "" || token == "" {
+		return "", nil, invalidCookie, nil
+	}
+
+	metaData := new(invoiceMetaData)
+	found, err := storageClient.Get(paymentHash, metaData)
+	if err != nil {
+		return "", nil, rejection{}, err
+	}
+	if !found || metaData.CookieTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(metaData.CookieTokenHash)) != 1 {
+		return "", nil, invalidCookie, nil
+	}
+
+	metaData, invalidCredential, err := checkInvoice(req, paymentHash, metaData, invoiceOptions, storageClient, lnClient)
+	return paymentHash, metaData, invalidCredential, err
+}
+
+var defaultHTMLTemplate = template.Must(template.New("paywall").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Payment required</title>
+<style>
+body { font-family: sans-serif; text-align: center; max-width: 30em; margin: 2em auto; padding: 0 1em; }
+img { width: 256px; height: 256px; }
+.invoice { font-family: monospace; font-size: 0.8em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Payment required</h1>
+{{if .Memo}}<p>{{.Memo}}</p>{{end}}
+<p>Pay <strong>{{.Amount}} sat</strong> with a Lightning wallet to continue.</p>
+<a href="{{.LightningURL}}"><img src="{{.QRCode}}" alt="QR code of the Lightning invoice"></a>
+<p><a href="{{.LightningURL}}">Open in wallet</a></p>
+<p class="invoice">{{.Invoice}}</p>
+<p id="status">Waiting for the payment...</p>
+<script>
+(function() {
+	var statusURL = new URL({{.StatusURL}}, location.href);
+	statusURL.searchParams.set("payment_hash", {{.PaymentHash}});
+	// Long-polling, so the page reacts to the payment immediately
+	statusURL.searchParams.set("wait", "30");
+	function poll() {
+		fetch(statusURL, {headers: {"Accept": "application/json"}, cache: "no-store"})
+			.then(function(res) { return res.json(); })
+			.then(function(body) {
+				if (body.status === "unpaid") {
+					poll();
+					return;
+				}
+				// When the invoice is paid, the reloaded page is redeemed with the cookie.
+				// Otherwise (for example when it expired) the reloaded page shows a new invoice.
+				document.getElementById("status").textContent = body.status === "paid" ? "Paid! Loading..." : "Loading...";
+				location.reload();
+			})
+			.catch(function() {
+				setTimeout(poll, 5000);
+			});
+	}
+	poll();
+})();
+</script>
+</body>
+</html>
+`))
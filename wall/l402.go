@@ -0,0 +1,271 @@
+package wall
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/philippgille/ln-paywall/ln"
+)
+
+// l402Location is the location that's added to all macaroons that the middleware issues.
+const l402Location = "ln-paywall"
+
+// l402IdentifierVersion is the version of the macaroon identifier format.
+// Version 0 is: version (2 bytes, big endian) + payment hash (32 bytes) + token ID (32 bytes).
+// It's the same format that's used by other L402 implementations, like Lightning Labs' Aperture.
+const l402IdentifierVersion uint16 = 0
+
+// L402Options are the options for the L402 (formerly known as LSAT) protocol.
+//
+// With L402 the "402 Payment Required" response contains a "WWW-Authenticate" header with a macaroon
+// and the invoice, like this:
+//
+//	WWW-Authenticate: L402 macaroon="AgEKbG4tcGF5d2FsbA...", invoice="lnbc10n1pd..."
+//
+// After paying the invoice, the client sends the macaroon and the (hex encoded) preimage in the "Authorization" header:
+//
+//	Authorization: L402 AgEKbG4tcGF5d2FsbA...:1234abcd...
+//
+// The previous name "LSAT" is accepted as well.
+//
+// The verification is stateless: The middleware checks the macaroon's signature and its caveats
+// (HTTP method, URL path and expiry) and if the preimage belongs to the payment hash in the macaroon.
+// No storage or LN node lookup is required for that.
+// As with other L402 implementations, a credential can be used for multiple requests until it expires,
+// unless SingleUse is set.
+type L402Options struct {
+	// Secret key for signing and verifying the macaroons.
+	// Should be at least 32 random bytes. Use the same key in all instances of your web service
+	// and keep it secret, because anyone with the key can create valid macaroons.
+	// Optional (if empty, a random key is generated when the middleware is created,
+	// which means that credentials aren't valid anymore after a restart and not across multiple instances of your web service).
+	RootKey []byte
+	// Duration after which a credential expires.
+	// Optional (1 hour by default).
+	Validity time.Duration
+	// Allows each credential to be used for only one request, like the preimage in the "X-Preimage" header.
+	// This requires a storage lookup, so the verification isn't stateless anymore.
+	// If the storage client implements ExtendedStorageClient, credentials are rejected after the invoice metadata
+	// was deleted (see InvoiceOptions.RedemptionWindow), even if they didn't expire yet.
+	// Optional (false by default).
+	SingleUse bool
+}
+
+// DefaultL402Options provides default values for L402Options.
+var DefaultL402Options = L402Options{
+	Validity: time.Hour,
+}
+
+func assignL402DefaultValues(l402Options L402Options, logger *slog.Logger) L402Options {
+	if len(l402Options.RootKey) == 0 {
+		logger.Warn("No L402 root key was configured, generating a random one. L402 credentials won't be valid after a restart.")
+		l402Options.RootKey = make([]byte, 32)
+		_, err := rand.Read(l402Options.RootKey)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if l402Options.Validity <= 0 {
+		l402Options.Validity = DefaultL402Options.Validity
+	}
+
+	return l402Options
+}
+
+// newL402Macaroon creates a base64 encoded macaroon for the given payment hash,
+// with caveats for the HTTP method and URL path of the request and the expiry.
+func newL402Macaroon(req *http.Request, paymentHashHex string, l402Options L402Options) (string, error) {
+	paymentHash, err := hex.DecodeString(paymentHashHex)
+	if err != nil {
+		return "", err
+	}
+	if len(paymentHash) != sha256.Size {
+		return "", errors.New("The payment hash of the invoice doesn't have a length of 32 bytes")
+	}
+	tokenID := make([]byte, 32)
+	_, err = rand.Read(tokenID)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 2, 2+len(paymentHash)+len(tokenID))
+	binary.BigEndian.PutUint16(id, l402IdentifierVersion)
+	id = append(id, paymentHash...)
+	id = append(id, tokenID...)
+
+	m, err := macaroon.New(deriveL402Key(l402Options.RootKey, tokenID), id, l402Location, macaroon.LatestVersion)
+	if err != nil {
+		return "", err
+	}
+	caveats := []string{
+		"method=" + req.Method,
+		"path=" + req.URL.Path,
+		"expires=" + strconv.FormatInt(time.Now().Add(l402Options.Validity).Unix(), 10),
+	}
+	for _, caveat := range caveats {
+		err = m.AddFirstPartyCaveat([]byte(caveat))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	macaroonBytes, err := m.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(macaroonBytes), nil
+}
+
+// deriveL402Key derives the key for a single macaroon from the root key and the macaroon's token ID.
+// This way each macaroon has its own key without the need to store it.
+func deriveL402Key(rootKey []byte, tokenID []byte) []byte {
+	mac := hmac.New(sha256.New, rootKey)
+	mac.Write(tokenID)
+	return mac.Sum(nil)
+}
+
+// getL402Credential returns the credential part of an "Authorization: L402 <macaroon>:<preimage>" header,
+// or an empty string if the request doesn't contain such a header.
+// The previous name of the protocol, "LSAT", is accepted as well.
+func getL402Credential(req *http.Request) string {
+	authHeader := req.Header.Get("Authorization")
+	for _, scheme := range []string{"L402 ", "LSAT "} {
+		if len(authHeader) > len(scheme) && strings.EqualFold(authHeader[:len(scheme)], scheme) {
+			return strings.TrimSpace(authHeader[len(scheme):])
+		}
+	}
+	return ""
+}
+
+// handleL402 does the following:
+// 1) Validate the credential format and the preimage format
+// 2) Verify the macaroon's signature
+// 3) Check the macaroon's caveats (HTTP method, URL path, expiry)
+// 4) Check if the preimage belongs to the payment hash in the macaroon
+// 5) If the credential is only allowed to be used once: Check if it was already used and mark it as used
+//
+// Returns the hex encoded payment hash, the invoice metadata, a rejection and an error,
+// with the same meaning as in handlePreimage. The payment hash is only non-empty if the credential is valid,
+// and the metadata is only non-nil if it was looked up because the credential is only allowed to be used once.
+func handleL402(req *http.Request, credential string, l402Options L402Options, invoiceOptions InvoiceOptions, storageClient StorageClient) (string, *invoiceMetaData, rejection, error) {
+	// 1) Validate the credential format and the preimage format
+	separatorIndex := strings.LastIndex(credential, ":")
+	if separatorIndex == -1 {
+		return "", nil, newRejection(ErrorCodeCredentialMalformed, "The provided L402 credential isn't properly formatted. The expected format is \"<macaroon>:<preimage>\""), nil
+	}
+	macaroonBase64 := credential[:separatorIndex]
+	preimageHex := credential[separatorIndex+1:]
+	invalidPreimage := validatePreimageFormat(preimageHex)
+	if invalidPreimage.reason != "" {
+		return "", nil, invalidPreimage, nil
+	}
+	macaroonBytes, err := macaroon.Base64Decode([]byte(macaroonBase64))
+	if err != nil {
+		return "", nil, newRejection(ErrorCodeCredentialMalformed, "The provided L402 macaroon isn't properly base64 encoded"), nil
+	}
+	m := new(macaroon.Macaroon)
+	err = m.UnmarshalBinary(macaroonBytes)
+	if err != nil {
+		return "", nil, newRejection(ErrorCodeCredentialMalformed, "The provided L402 macaroon isn't properly formatted"), nil
+	}
+	id := m.Id()
+	if len(id) != 2+32+32 || binary.BigEndian.Uint16(id[:2]) != l402IdentifierVersion {
+		return "", nil, newRejection(ErrorCodeCredentialMalformed, "The provided L402 macaroon has an unknown identifier format"), nil
+	}
+	paymentHash := id[2:34]
+	tokenID := id[34:]
+
+	// 2) Verify the macaroon's signature and 3) check its caveats
+	err = m.Verify(deriveL402Key(l402Options.RootKey, tokenID), newL402CaveatChecker(req), nil)
+	if err != nil {
+		return "", nil, rejection{
+			code:    ErrorCodeCredentialInvalid,
+			reason:  "The provided L402 macaroon isn't valid for this request",
+			message: "The provided L402 macaroon isn't valid for this request: " + err.Error(),
+		}, nil
+	}
+
+	// 4) Check if the preimage belongs to the payment hash in the macaroon
+	// Ignore error because we already validated the preimage format.
+	preimageHash, _ := ln.HashPreimage(preimageHex)
+	// Ignore error because HashPreimage always returns a valid hex string.
+	preimageHashBytes, _ := hex.DecodeString(preimageHash)
+	if !bytes.Equal(preimageHashBytes, paymentHash) {
+		return "", nil, newRejection(ErrorCodeCredentialInvalid, "The provided preimage doesn't belong to the invoice of the provided L402 macaroon"), nil
+	}
+
+	// 5) Check if the credential was already used and mark it as used.
+	// The invoice metadata is stored for every issued invoice, so its "Used" flag can be used for that.
+	var metaData *invoiceMetaData
+	if l402Options.SingleUse {
+		metaData = new(invoiceMetaData)
+		found, err := storageClient.Get(preimageHash, metaData)
+		if err != nil {
+			return "", nil, rejection{}, err
+		}
+		if !found {
+			return "", nil, newRejection(ErrorCodeCredentialUnknown, "No corresponding invoice was found for the provided L402 credential"), nil
+		}
+		alreadyUsed := newRejection(ErrorCodeCredentialAlreadyUsed, "You already sent a request with the same L402 credential. You have to pay a new invoice for each request.")
+		if metaData.Used {
+			return "", nil, alreadyUsed, nil
+		}
+		marked, err := markInvoiceUsed(storageClient, preimageHash, metaData, invoiceOptions)
+		if err != nil {
+			return "", nil, rejection{}, err
+		}
+		if !marked {
+			return "", nil, alreadyUsed, nil
+		}
+	}
+
+	return preimageHash, metaData, rejection{}, nil
+}
+
+// newL402CaveatChecker returns a function that checks if a first party caveat of a macaroon is satisfied by the given request.
+// Caveats have the format "key=value".
+// Unknown caveats are never satisfied, so clients can't circumvent restrictions we don't know about.
+func newL402CaveatChecker(req *http.Request) func(string) error {
+	return func(caveat string) error {
+		keyValue := strings.SplitN(caveat, "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("unknown caveat %q", caveat)
+		}
+		key, value := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])
+		switch key {
+		case "method":
+			if value != req.Method {
+				return fmt.Errorf("the credential is only valid for %v requests", value)
+			}
+		case "path":
+			if value != req.URL.Path {
+				return fmt.Errorf("the credential is only valid for the path %q", value)
+			}
+		case "expires":
+			expiry, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid expiry %q", value)
+			}
+			if time.Now().Unix() > expiry {
+				return errors.New("the credential expired")
+			}
+		default:
+			return fmt.Errorf("unknown caveat %q", caveat)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+package wall_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	macaroon "gopkg.in/macaroon.v2"
+
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+var testRootKey = []byte("0123456789abcdef0123456789abcdef")
+
+// newL402TestHandlerFunc returns a handler func with the middleware with L402 in front of it,
+// which responds with "pong" when the request was paid.
+func newL402TestHandlerFunc(node *lntest.Node, l402Options wall.L402Options) http.HandlerFunc {
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.L402 = &l402Options
+	return wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+}
+
+// getL402Credential sends a request without credential, pays the invoice of the "WWW-Authenticate" header
+// and returns the macaroon and the preimage.
+func getL402Credential(t *testing.T, node *lntest.Node, handlerFunc http.HandlerFunc, method string, path string) (*macaroon.Macaroon, string) {
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest(method, path, nil))
+	challenge := res.Header().Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "L402 ") {
+		t.Fatalf("Expected an L402 challenge, but was %v\n", challenge)
+	}
+	macaroonBase64 := strings.SplitN(challenge, `macaroon="`, 2)[1]
+	macaroonBase64 = strings.SplitN(macaroonBase64, `"`, 2)[0]
+	invoice := strings.SplitN(challenge, `invoice="`, 2)[1]
+	invoice = strings.SplitN(invoice, `"`, 2)[0]
+
+	macaroonBytes, err := base64.StdEncoding.DecodeString(macaroonBase64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(macaroon.Macaroon)
+	err = m.UnmarshalBinary(macaroonBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage, err := node.Pay(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, preimage
+}
+
+// encodeMacaroon returns the base64 encoded binary representation of the macaroon.
+func encodeMacaroon(t *testing.T, m *macaroon.Macaroon) string {
+	macaroonBytes, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(macaroonBytes)
+}
+
+// sendL402 sends a request with the given "Authorization" header and returns the status code and the error code of the response,
+// or the body if the request was successful.
+func sendL402(t *testing.T, handlerFunc http.HandlerFunc, method string, path string, authorization string) (int, string) {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+	res := httptest.NewRecorder()
+	handlerFunc(res, req)
+	if res.Code == http.StatusOK {
+		return res.Code, res.Body.String()
+	}
+	errorResponse := struct {
+		Code string `json:"code"`
+	}{}
+	err := json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	if err != nil {
+		t.Fatalf("Expected a JSON body, but was %v (%v)\n", res.Body.String(), err)
+	}
+	return res.Code, errorResponse.Code
+}
+
+// TestL402 tests if valid L402 credentials are accepted and invalid ones are rejected.
+func TestL402(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newL402TestHandlerFunc(node, wall.L402Options{RootKey: testRootKey})
+	otherKeyHandlerFunc := newL402TestHandlerFunc(node, wall.L402Options{RootKey: []byte("fedcba9876543210fedcba9876543210")})
+
+	m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/items/1")
+	valid := encodeMacaroon(t, m)
+	_, otherPreimage := getL402Credential(t, node, handlerFunc, "GET", "/items/1")
+
+	// The signature doesn't match anymore when a caveat is changed
+	tampered, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(tampered, []byte("path=/items/1")) {
+		t.Fatalf("Expected the macaroon to contain the caveat %v\n", "path=/items/1")
+	}
+	tampered = bytes.Replace(tampered, []byte("path=/items/1"), []byte("path=/items/2"), 1)
+	// Adding caveats keeps the signature valid, but they must be satisfied
+	withCaveat := func(caveat string) string {
+		clone := m.Clone()
+		err := clone.AddFirstPartyCaveat([]byte(caveat))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encodeMacaroon(t, clone)
+	}
+
+	testCases := []struct {
+		name          string
+		handlerFunc   http.HandlerFunc
+		method        string
+		path          string
+		authorization string
+		expectedCode  int
+		expected      string
+	}{
+		{"valid", handlerFunc, "GET", "/items/1", "L402 " + valid + ":" + preimage, http.StatusOK, "pong"},
+		{"valid again", handlerFunc, "GET", "/items/1", "L402 " + valid + ":" + preimage, http.StatusOK, "pong"},
+		{"LSAT alias", handlerFunc, "GET", "/items/1", "LSAT " + valid + ":" + preimage, http.StatusOK, "pong"},
+		{"satisfied extra caveat", handlerFunc, "GET", "/items/1", "L402 " + withCaveat("method=GET") + ":" + preimage, http.StatusOK, "pong"},
+		{"different root key", otherKeyHandlerFunc, "GET", "/items/1", "L402 " + valid + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"tampered", handlerFunc, "GET", "/items/2", "L402 " + base64.StdEncoding.EncodeToString(tampered) + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"unsatisfied extra caveat", handlerFunc, "GET", "/items/1", "L402 " + withCaveat("path=/items/2") + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"unknown caveat", handlerFunc, "GET", "/items/1", "L402 " + withCaveat("tier=gold") + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"caveat without value", handlerFunc, "GET", "/items/1", "L402 " + withCaveat("admin") + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"method mismatch", handlerFunc, "POST", "/items/1", "L402 " + valid + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"path mismatch", handlerFunc, "GET", "/items/2", "L402 " + valid + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"expired", handlerFunc, "GET", "/items/1", "L402 " + withCaveat("expires="+strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)) + ":" + preimage, http.StatusUnauthorized, "credential_invalid"},
+		{"wrong preimage", handlerFunc, "GET", "/items/1", "L402 " + valid + ":" + otherPreimage, http.StatusUnauthorized, "credential_invalid"},
+		{"malformed preimage", handlerFunc, "GET", "/items/1", "L402 " + valid + ":abc", http.StatusUnauthorized, "preimage_malformed"},
+		{"missing preimage", handlerFunc, "GET", "/items/1", "L402 " + valid, http.StatusUnauthorized, "credential_malformed"},
+		{"malformed macaroon", handlerFunc, "GET", "/items/1", "L402 !!!:" + preimage, http.StatusUnauthorized, "credential_malformed"},
+	}
+	for _, testCase := range testCases {
+		code, result := sendL402(t, testCase.handlerFunc, testCase.method, testCase.path, testCase.authorization)
+		if code != testCase.expectedCode || result != testCase.expected {
+			t.Errorf("%v: Expected (%v, %v), but was (%v, %v)\n", testCase.name, testCase.expectedCode, testCase.expected, code, result)
+		}
+	}
+}
+
+// TestL402GeneratedRootKey tests if handlers that are wrapped by the same middleware share the generated root key,
+// so a credential that was bought via one handler is valid for the others as well.
+func TestL402GeneratedRootKey(t *testing.T) {
+	node := newTestNode(t)
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.L402 = &wall.L402Options{}
+	middleware := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)
+	handlerFunc := middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	otherHandlerFunc := middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other pong"))
+	})
+
+	m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/items/1")
+	code, body := sendL402(t, otherHandlerFunc, "GET", "/items/1", "L402 "+encodeMacaroon(t, m)+":"+preimage)
+	if code != http.StatusOK || body != "other pong" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "other pong", code, body)
+	}
+}
+
+// TestL402SingleUse tests if a single-use L402 credential is rejected when it's replayed.
+func TestL402SingleUse(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newL402TestHandlerFunc(node, wall.L402Options{RootKey: testRootKey, SingleUse: true})
+
+	m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/")
+	authorization := "L402 " + encodeMacaroon(t, m) + ":" + preimage
+	code, result := sendL402(t, handlerFunc, "GET", "/", authorization)
+	if code != http.StatusOK || result != "pong" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "pong", code, result)
+	}
+	code, result = sendL402(t, handlerFunc, "GET", "/", authorization)
+	if code != http.StatusUnauthorized || result != "credential_already_used" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusUnauthorized, "credential_already_used", code, result)
+	}
+}
@@ -0,0 +1,151 @@
+package wall
+
+import (
+	"net/http"
+	"time"
+)
+
+// Metrics is a hook for collecting metrics about the middleware, like the number of generated invoices
+// and the latency of the LN node.
+// The metrics package contains an implementation for Prometheus.
+// The methods are called synchronously while a request is handled, so they should return quickly.
+// They're called concurrently for concurrent requests.
+//
+// The route that's passed to some of the methods is the path pattern of the first entry of InvoiceOptions.PricingTable
+// that matches the request, or the URL path of the request if no entry matches.
+// If the middleware is used for many different URL paths, for example paths that contain IDs,
+// add pricing table entries for them, so the number of different routes is limited.
+type Metrics interface {
+	// InvoiceGenerated is called when an invoice is sent to the client,
+	// with the route of the request and the price of the invoice in Satoshis.
+	InvoiceGenerated(route string, amount int64)
+	// InvoiceRedeemed is called when a preimage of a paid invoice is accepted,
+	// with the route of the request and the price of the invoice in Satoshis.
+	// This includes preimages that are redeemed for a pass or prepaid credits, via the cookie of the HTML paywall page
+	// or in a single-use L402 credential (see L402Options.SingleUse).
+	InvoiceRedeemed(route string, amount int64)
+	// CredentialAccepted is called when a request with an L402 credential that isn't single-use is accepted,
+	// with the route of the request.
+	// Such a credential can be used for multiple requests and is verified without a storage lookup,
+	// so its invoice isn't reported as redeemed, and the price of the invoice isn't known.
+	CredentialAccepted(route string)
+	// PreimageRejected is called when a request with an invalid preimage is rejected,
+	// with the route of the request and the reason for the rejection.
+	// This includes invalid L402 credentials, cookies of the HTML paywall page that can't be redeemed,
+	// and pass and credit tokens that are rejected because the pass expired or the balance is too low
+	// (in these cases the response contains a new invoice).
+	// The reason is a message like the one that's sent to the client, but without details about the request,
+	// so the number of different reasons is limited.
+	PreimageRejected(route string, reason string)
+	// LNcall is called after a call to the LN node,
+	// with the name of the LNclient method ("GenerateInvoice" or "CheckInvoice"),
+	// the duration of the call and its error (nil if the call was successful).
+	LNcall(method string, duration time.Duration, err error)
+	// StorageError is called when a call to the storage client fails,
+	// with the name of the storage client method ("Get", "Set", "CompareAndSwap", "SetWithTTL" or "Delete")
+	// and the error.
+	StorageError(method string, err error)
+}
+
+// getRoute returns the route of the request that's reported to the metrics:
+// The path pattern of the first entry of InvoiceOptions.PricingTable that matches the request,
+// or the URL path of the request if no entry matches.
+func getRoute(req *http.Request, invoiceOptions InvoiceOptions) string {
+	for _, routePrice := range invoiceOptions.PricingTable {
+		if routePrice.matches(req) {
+			return routePrice.Path
+		}
+	}
+	return req.URL.Path
+}
+
+// noopMetrics is the Metrics implementation that's used when MiddlewareOptions.Metrics isn't set.
+type noopMetrics struct{}
+
+func (noopMetrics) InvoiceGenerated(string, int64)      {}
+func (noopMetrics) InvoiceRedeemed(string, int64)       {}
+func (noopMetrics) CredentialAccepted(string)           {}
+func (noopMetrics) PreimageRejected(string, string)     {}
+func (noopMetrics) LNcall(string, time.Duration, error) {}
+func (noopMetrics) StorageError(string, error)          {}
+
+// withStorageMetrics wraps the storage client so that failed calls are reported to the metrics.
+// The returned storage client implements the same optional interfaces (AtomicStorageClient and ExtendedStorageClient)
+// as the given one, so the middleware's behaviour doesn't change.
+func withStorageMetrics(storageClient StorageClient, metrics Metrics) StorageClient {
+	base := metricsStorageClient{storageClient: storageClient, metrics: metrics}
+	atomicStorageClient, isAtomic := storageClient.(AtomicStorageClient)
+	extendedStorageClient, isExtended := storageClient.(ExtendedStorageClient)
+	switch {
+	case isAtomic && isExtended:
+		return metricsAtomicExtendedStorageClient{
+			metricsAtomicStorageClient{base, atomicStorageClient},
+			extendedStorageClient,
+		}
+	case isAtomic:
+		return metricsAtomicStorageClient{base, atomicStorageClient}
+	case isExtended:
+		return metricsExtendedStorageClient{base, extendedStorageClient}
+	default:
+		return base
+	}
+}
+
+// metricsStorageClient is a StorageClient that reports errors of the wrapped StorageClient to the metrics.
+type metricsStorageClient struct {
+	storageClient StorageClient
+	metrics       Metrics
+}
+
+func (c metricsStorageClient) Set(k string, v interface{}) error {
+	return c.observe("Set", c.storageClient.Set(k, v))
+}
+
+func (c metricsStorageClient) Get(k string, v interface{}) (bool, error) {
+	found, err := c.storageClient.Get(k, v)
+	return found, c.observe("Get", err)
+}
+
+// observe reports the error to the metrics if it's not nil and returns it.
+func (c metricsStorageClient) observe(method string, err error) error {
+	if err != nil {
+		c.metrics.StorageError(method, err)
+	}
+	return err
+}
+
+type metricsAtomicStorageClient struct {
+	metricsStorageClient
+	atomicStorageClient AtomicStorageClient
+}
+
+func (c metricsAtomicStorageClient) CompareAndSwap(k string, old, new interface{}) (bool, error) {
+	swapped, err := c.atomicStorageClient.CompareAndSwap(k, old, new)
+	return swapped, c.observe("CompareAndSwap", err)
+}
+
+type metricsExtendedStorageClient struct {
+	metricsStorageClient
+	extendedStorageClient ExtendedStorageClient
+}
+
+func (c metricsExtendedStorageClient) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return c.observe("SetWithTTL", c.extendedStorageClient.SetWithTTL(k, v, ttl))
+}
+
+func (c metricsExtendedStorageClient) Delete(k string) error {
+	return c.observe("Delete", c.extendedStorageClient.Delete(k))
+}
+
+type metricsAtomicExtendedStorageClient struct {
+	metricsAtomicStorageClient
+	extendedStorageClient ExtendedStorageClient
+}
+
+func (c metricsAtomicExtendedStorageClient) SetWithTTL(k string, v interface{}, ttl time.Duration) error {
+	return c.observe("SetWithTTL", c.extendedStorageClient.SetWithTTL(k, v, ttl))
+}
+
+func (c metricsAtomicExtendedStorageClient) Delete(k string) error {
+	return c.observe("Delete", c.extendedStorageClient.Delete(k))
+}
@@ -1,20 +1,24 @@
 package wall
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"path"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/philippgille/ln-paywall/internal/logging"
 	"github.com/philippgille/ln-paywall/ln"
 )
 
-// stdOutLogger logs to stdout, while the default log package loggers log to stderr.
-var stdOutLogger = log.New(os.Stdout, "", log.LstdFlags)
-
 // InvoiceOptions are the options for an invoice.
 type InvoiceOptions struct {
 	// Amount of Satoshis you want to have paid for one API call.
@@ -25,12 +29,145 @@ type InvoiceOptions struct {
 	// for example: "API call to api.example.com".
 	// Optional ("" by default).
 	Memo string
+	// Duration after which an unpaid invoice expires.
+	// Preimages of invoices that expired before they were paid are rejected.
+	// Values below 1 second are automatically changed to the default value.
+	// Optional (1 hour by default).
+	Expiry time.Duration
+	// Duration after the invoice expiry during which the client can still send the preimage.
+	// If the storage client implements ExtendedStorageClient, the invoice metadata is deleted afterwards.
+	// Preimages of invoices whose metadata was deleted are rejected, so the redemption window
+	// should be longer than the time a client might need to send the preimage after paying the invoice.
+	// Values below 1 second are automatically changed to the default value.
+	// Optional (24 hours by default).
+	RedemptionWindow time.Duration
+	// Pricing table for requests that should be priced differently than with the Price and Memo above.
+	// The entries are checked in order and the first one that matches the request is used.
+	// If no entry matches, Price and Memo are used.
+	// Optional (nil by default).
+	PricingTable []RoutePrice
+	// Function that determines the price and memo for a request,
+	// for example based on the payload size or query parameters.
+	// Takes precedence over the PricingTable, Price and Memo.
+	// Optional (nil by default).
+	PricingFunc PricingFunc
+	// Binds the invoice to the full request instead of only its HTTP method and URL path.
+	// If true, a hash of the query string, the headers listed in BindHeaders and the body
+	// is stored when the invoice is issued, and the request with the preimage must lead to the same hash.
+	// This prevents clients from paying for a cheap request (e.g. a small payload)
+	// and using the preimage for an expensive one.
+	// Note that the request body is read completely for calculating the hash.
+	// Optional (false by default).
+	BindRequest bool
+	// Names of the headers that are included in the request hash when BindRequest is true.
+	// Optional (nil by default).
+	BindHeaders []string
+}
+
+// PricingFunc determines the price (in Satoshis) and memo for the invoice of the given request.
+// The price must be at least 1.
+//
+// If the request should be rejected (for example because a query parameter has an invalid value),
+// return a PricingRejection (or a pointer to one, optionally wrapped in another error) as error, which leads to a response with its status code and message.
+// Any other error leads to a "500 Internal Server Error" response.
+//
+// If the function reads the request body, it must replace it with an unread copy,
+// so that the body is still available to other middlewares and the final request handler.
+type PricingFunc func(*http.Request) (int64, string, error)
+
+// PricingRejection is the error a PricingFunc can return to reject a request.
+type PricingRejection struct {
+	// HTTP status code of the response.
+	// Optional (400 Bad Request by default).
+	StatusCode int
+	// Message that's sent in the response body.
+	Message string
+}
+
+// Error returns the message of the rejection.
+func (pr PricingRejection) Error() string {
+	return pr.Message
+}
+
+// RoutePrice is an entry in the pricing table of InvoiceOptions.
+// It defines the price and memo for requests that match the given HTTP method and path pattern.
+type RoutePrice struct {
+	// HTTP method (e.g. "GET") that a request must have for the entry to match.
+	// Optional ("" by default, which matches all methods).
+	Method string
+	// Pattern that the URL path of a request must match for the entry to match,
+	// for example "/qr" or "/images/*".
+	// The syntax is the one of path.Match (https://golang.org/pkg/path/#Match).
+	// Note that "*" doesn't match "/", so "/images/*" matches "/images/foo", but not "/images/foo/bar".
+	// Required.
+	Path string
+	// Amount of Satoshis you want to have paid for one API call to the route.
+	// Values below 1 are automatically changed to InvoiceOptions.Price.
+	// Optional (InvoiceOptions.Price by default).
+	Price int64
+	// Note to be shown on the invoice for the route.
+	// Optional (InvoiceOptions.Memo by default).
+	Memo string
 }
 
 // DefaultInvoiceOptions provides default values for InvoiceOptions.
 var DefaultInvoiceOptions = InvoiceOptions{
-	Price: 1,
-	Memo:  "API call",
+	Price:            1,
+	Memo:             "API call",
+	Expiry:           time.Hour,
+	RedemptionWindow: 24 * time.Hour,
+}
+
+// MiddlewareOptions are the options for the middleware that aren't specific to single invoices.
+type MiddlewareOptions struct {
+	// Options for the L402 (formerly known as LSAT) protocol.
+	// When set, the middleware supports L402 in addition to the "X-Preimage" header based protocol.
+	// Optional (nil by default, which disables L402).
+	L402 *L402Options
+	// Options for prepaid credit balances, where one invoice buys a balance for many requests.
+	// When set, a paid invoice leads to a credit token instead of being valid for only one request.
+	// Optional (nil by default, which disables prepaid credits).
+	Credit *CreditOptions
+	// Options for time-window access passes, where one invoice buys unlimited requests during a time window.
+	// When set, a paid invoice leads to a pass token instead of being valid for only one request.
+	// If Credit is set as well, passes are used for the paths they're configured for and credits for all other paths.
+	// Optional (nil by default, which disables passes).
+	Pass *PassOptions
+	// Maximum duration of a single call to the LN node, like generating or checking an invoice.
+	// When it's exceeded, the call is cancelled and the middleware responds with "500 Internal Server Error".
+	// Calls are also cancelled when the client cancels its request.
+	// This only works with LN clients that implement ContextLNclient, like all clients in the ln package do.
+	// Values below 1 millisecond are automatically changed to the default value.
+	// Optional (30 seconds by default).
+	LNtimeout time.Duration
+	// Logger for the middleware's structured log entries, for example about sent invoices and rejected requests.
+	// Each entry contains the HTTP method and URL path of the request,
+	// and entries about the outcome of a request contain an "outcome" field.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+	// Log sensitive values as is, like preimages and invoices.
+	// By default preimages are redacted, and invoices and payment hashes are shortened.
+	// This should only be enabled for debugging.
+	// Optional (false by default).
+	LogSensitiveValues bool
+	// Hook for collecting metrics, like the number of generated and redeemed invoices,
+	// the latency of the LN node and the number of storage errors.
+	// See the metrics package for an implementation for Prometheus.
+	// Optional (nil by default, which disables metrics).
+	Metrics Metrics
+	// Hooks for reacting to events like generated invoices and redeemed or rejected preimages,
+	// for example for recording revenue per customer.
+	// Optional (nil by default).
+	Hooks Hooks
+	// Options for the HTML paywall page, which is sent instead of the plain invoice
+	// when a browser requests a paywalled URL with a GET request.
+	// Optional (nil by default, which disables the HTML paywall page).
+	HTML *HTMLOptions
+}
+
+// DefaultMiddlewareOptions provides default values for MiddlewareOptions.
+var DefaultMiddlewareOptions = MiddlewareOptions{
+	LNtimeout: 30 * time.Second,
 }
 
 // StorageClient is an abstraction for different storage client implementations.
@@ -45,17 +182,96 @@ type StorageClient interface {
 	Get(string, interface{}) (bool, error)
 }
 
+// AtomicStorageClient is a StorageClient that additionally supports an atomic compare-and-swap operation.
+// The middleware uses it for operations that must not be affected by concurrent requests,
+// like updating the balance of prepaid credits.
+// All storage clients in the storage package implement this interface.
+type AtomicStorageClient interface {
+	StorageClient
+	// CompareAndSwap stores the given object (third parameter) for the given key,
+	// but only if the currently stored object equals the old one (second parameter).
+	// If the old object is nil, the new one is only stored if no object exists for the key yet.
+	// Objects are equal if their JSON representations are equal.
+	// Returns true if the new object was stored.
+	CompareAndSwap(string, interface{}, interface{}) (bool, error)
+}
+
+// ExtendedStorageClient is a StorageClient that additionally supports expiring and deleting objects.
+// The middleware uses it for deleting invoice metadata and access passes that aren't required anymore,
+// so the storage doesn't grow without bounds.
+// All storage clients in the storage package implement this interface.
+type ExtendedStorageClient interface {
+	StorageClient
+	// SetWithTTL stores the given object (second parameter) for the given key,
+	// which expires after the given TTL (third parameter).
+	// Expired objects must not be returned by Get anymore.
+	// If the TTL is 0 or negative, the object doesn't expire.
+	SetWithTTL(string, interface{}, time.Duration) error
+	// Delete deletes the stored object for the given key.
+	// Deleting a key that doesn't exist is not an error.
+	Delete(string) error
+}
+
 // LNclient is an abstraction of a client that connects to a Lightning Network node implementation (like lnd, c-lightning and eclair)
 // and provides the methods required by the paywall.
 type LNclient interface {
-	// GenerateInvoice generates a new invoice based on the price in Satoshis and with the given memo.
-	GenerateInvoice(int64, string) (ln.Invoice, error)
+	// GenerateInvoice generates a new invoice based on the price in Satoshis and with the given memo and expiry.
+	GenerateInvoice(int64, string, time.Duration) (ln.Invoice, error)
 	// CheckInvoice checks if the invoice was settled, given an LN node implementation dependent ID.
 	// For example lnd uses the payment hash a.k.a. preimage hash as ID, while Lightning Charge
 	// uses a randomly generated string as ID.
 	CheckInvoice(string) (bool, error)
 }
 
+// ContextLNclient is an LNclient that additionally supports cancelling its calls via a context.
+// The middleware passes the context of the HTTP request, with the MiddlewareOptions.LNtimeout as deadline,
+// so calls to a slow or unresponsive LN node don't block the request forever.
+// All clients in the ln package implement this interface.
+type ContextLNclient interface {
+	LNclient
+	// GenerateInvoiceContext is like GenerateInvoice, but the call is cancelled when the context is done.
+	GenerateInvoiceContext(context.Context, int64, string, time.Duration) (ln.Invoice, error)
+	// CheckInvoiceContext is like CheckInvoice, but the call is cancelled when the context is done.
+	CheckInvoiceContext(context.Context, string) (bool, error)
+}
+
+// requestLNclient is an LNclient that passes the context of an HTTP request,
+// with a timeout for each call, to the wrapped LNclient, if it's a ContextLNclient.
+// It reports the duration of each call to the metrics.
+type requestLNclient struct {
+	ctx      context.Context
+	timeout  time.Duration
+	lnClient LNclient
+	metrics  Metrics
+}
+
+func (c requestLNclient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (invoice ln.Invoice, err error) {
+	defer c.observe("GenerateInvoice", time.Now(), &err)
+	ctxLNclient, ok := c.lnClient.(ContextLNclient)
+	if !ok {
+		return c.lnClient.GenerateInvoice(amount, memo, expiry)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return ctxLNclient.GenerateInvoiceContext(ctx, amount, memo, expiry)
+}
+
+func (c requestLNclient) CheckInvoice(id string) (settled bool, err error) {
+	defer c.observe("CheckInvoice", time.Now(), &err)
+	ctxLNclient, ok := c.lnClient.(ContextLNclient)
+	if !ok {
+		return c.lnClient.CheckInvoice(id)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	return ctxLNclient.CheckInvoiceContext(ctx, id)
+}
+
+// observe reports a finished call to the metrics. It's meant to be deferred at the beginning of the call.
+func (c requestLNclient) observe(method string, start time.Time, err *error) {
+	c.metrics.LNcall(method, time.Since(start), *err)
+}
+
 // invoiceMetaData is data that's required to prevent clients from cheating
 // (e.g. have multiple requests executed while having paid only once,
 // or requesting an invoice for a cheap endpoint and using the payment proof for an expensive one).
@@ -75,7 +291,26 @@ type invoiceMetaData struct {
 	ImplDepID string
 	Method    string
 	Path      string
-	Used      bool
+	// The price of the invoice in Satoshis, for auditing purposes.
+	Price int64
+	// Hash of the query string, selected headers and body of the request.
+	// Empty if the invoice isn't bound to the full request.
+	RequestHash string
+	// Amount of Satoshis that's added to a credit balance when the invoice is redeemed.
+	// 0 if the invoice isn't for prepaid credits.
+	CreditAmount int64
+	// Storage key of the credit balance that's topped up when the invoice is redeemed.
+	// Empty if the invoice isn't for topping up an existing balance.
+	CreditKey string
+	// Time at which the invoice expires if it's not paid until then.
+	ExpiresAt time.Time
+	// Duration of the pass that's created when the invoice is redeemed.
+	// 0 if the invoice isn't for a pass.
+	PassDuration time.Duration
+	// Hash of the token in the cookie that was set together with the HTML paywall page.
+	// Empty if the invoice wasn't sent in an HTML paywall page.
+	CookieTokenHash string
+	Used            bool
 }
 
 type frameworkAbstraction interface {
@@ -85,8 +320,12 @@ type frameworkAbstraction interface {
 	respondWithError(error, string, int)
 	// getHTTPrequest returns a pointer to the current http.Request.
 	getHTTPrequest() *http.Request
-	// respondWithInvoice sends a response with the given headers, status code and invoice string.
-	respondWithInvoice(map[string]string, int, []byte)
+	// respond sends a response with the given headers, status code and body.
+	respond(map[string]string, int, []byte)
+	// setResponseHeader sets a header of the response that the next handler sends.
+	setResponseHeader(string, string)
+	// setCookie adds a "Set-Cookie" header to the response, in addition to the cookies that were set before.
+	setCookie(*http.Cookie)
 	// next moves to the next handler, which might be another middleware or the actual request handler.
 	// This method is only called when all previous operations were successful (e.g. the invoice was paid properly).
 	// An error only needs to be returned if the specific web framework requires middlewares to return one,
@@ -94,76 +333,295 @@ type frameworkAbstraction interface {
 	next() error
 }
 
-func commonHandler(fa frameworkAbstraction, invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient) error {
-	// Check if the request contains a header with the preimage that we need to check if the requester paid
+func commonHandler(fa frameworkAbstraction, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient) error {
+	// Cancel calls to the LN node when the client cancels the request or when they take too long
+	lnClient = requestLNclient{
+		ctx:      fa.getHTTPrequest().Context(),
+		timeout:  middlewareOptions.LNtimeout,
+		lnClient: lnClient,
+		metrics:  middlewareOptions.Metrics,
+	}
+	storageClient = withStorageMetrics(storageClient, middlewareOptions.Metrics)
+	middlewareOptions.Logger = middlewareOptions.Logger.With("method", fa.getHTTPrequest().Method, "path", fa.getHTTPrequest().URL.Path)
+
+	// Check if the request contains an L402 credential (if L402 is enabled) or a header with the preimage
+	// that we need to check if the requester paid
+	l402Credential := ""
+	if middlewareOptions.L402 != nil {
+		l402Credential = getL402Credential(fa.getHTTPrequest())
+	}
 	preimageHex := fa.getPreimageFromHeader()
-	if preimageHex == "" {
-		// Generate the invoice
-		invoice, err := lnClient.GenerateInvoice(invoiceOptions.Price, invoiceOptions.Memo)
+	cookieCredential := ""
+	if middlewareOptions.HTML != nil {
+		cookieCredential = getCookieCredential(fa.getHTTPrequest(), *middlewareOptions.HTML)
+	}
+	// Browsers send pass and credit tokens in cookies instead of headers.
+	// An invoice that's shown on the HTML paywall page is redeemed first though, because it might be for topping up the credits.
+	passToken := ""
+	if middlewareOptions.usesPass(fa.getHTTPrequest()) {
+		passToken = fa.getHTTPrequest().Header.Get("X-Pass-Token")
+		if passToken == "" && middlewareOptions.HTML != nil && cookieCredential == "" {
+			passToken = getTokenCookie(fa.getHTTPrequest(), *middlewareOptions.HTML, passCookieSuffix)
+		}
+	}
+	creditToken := ""
+	if middlewareOptions.usesCredit(fa.getHTTPrequest()) {
+		creditToken = fa.getHTTPrequest().Header.Get("X-Credit-Token")
+		if creditToken == "" && middlewareOptions.HTML != nil && cookieCredential == "" {
+			creditToken = getTokenCookie(fa.getHTTPrequest(), *middlewareOptions.HTML, creditCookieSuffix)
+		}
+	}
+	if l402Credential != "" {
+		// Check if the macaroon is valid for this request and if the preimage belongs to its payment hash.
+		paymentHash, metaData, invalidCredential, err := handleL402(fa.getHTTPrequest(), l402Credential, *middlewareOptions.L402, invoiceOptions, storageClient)
 		if err != nil {
-			errorMsg := fmt.Sprintf("Couldn't generate invoice: %+v", err)
-			log.Println(errorMsg)
-			fa.respondWithError(err, errorMsg, http.StatusInternalServerError)
+			errorMsg := fmt.Sprintf("An error occurred during checking the L402 credential: %+v", err)
+			middlewareOptions.Logger.Error("Couldn't check the L402 credential", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		} else if invalidCredential.reason != "" {
+			middlewareOptions.Logger.Warn("The L402 credential is invalid", "outcome", "rejected", "reason", invalidCredential.message)
+			reportRejection(fa, invalidCredential.reason, invoiceOptions, middlewareOptions)
+			sendError(fa, nil, invalidCredential.code, invalidCredential.message, http.StatusUnauthorized)
+		} else if metaData != nil {
+			// The metadata was looked up and marked as used because the credential is single-use
+			middlewareOptions.Logger.Info("The L402 credential is valid, continuing to the next handler", "outcome", "redeemed", "price", metaData.Price,
+				logging.Identifier("payment_hash", paymentHash, middlewareOptions.LogSensitiveValues))
+			reportRedemption(fa, paymentHash, metaData.Price, invoiceOptions, middlewareOptions)
+			err = fa.next()
+			if err != nil {
+				return err
+			}
 		} else {
-			// Cache the invoice metadata
-			metadata := invoiceMetaData{
-				ImplDepID: invoice.ImplDepID,
-				Method:    fa.getHTTPrequest().Method,
-				Path:      fa.getHTTPrequest().URL.Path,
+			// The credential can be used for multiple requests, so the invoice isn't reported as redeemed again with each one
+			middlewareOptions.Logger.Info("The L402 credential is valid, continuing to the next handler", "outcome", "accepted",
+				logging.Identifier("payment_hash", paymentHash, middlewareOptions.LogSensitiveValues))
+			middlewareOptions.Metrics.CredentialAccepted(getRoute(fa.getHTTPrequest(), invoiceOptions))
+			middlewareOptions.Hooks.CredentialAccepted(fa.getHTTPrequest().Context(), fa.getHTTPrequest(), paymentHash)
+			err = fa.next()
+			if err != nil {
+				return err
 			}
-			storageClient.Set(invoice.PaymentHash, metadata)
-
-			// Respond with the invoice
-			stdOutLogger.Printf("Sending invoice in response: %v", invoice.PaymentRequest)
-			headers := make(map[string]string)
-			headers["Content-Type"] = "application/vnd.lightning.bolt11"
-			fa.respondWithInvoice(headers, http.StatusPaymentRequired, []byte(invoice.PaymentRequest))
 		}
+	} else if preimageHex == "" && passToken != "" {
+		// Check the pass or respond with an invoice for a new one
+		return handlePassToken(fa, passToken, invoiceOptions, middlewareOptions, lnClient, storageClient)
+	} else if preimageHex == "" && creditToken != "" {
+		// Deduct the price from the credit balance or respond with a top-up invoice
+		return handleCreditToken(fa, creditToken, invoiceOptions, middlewareOptions, lnClient, storageClient)
+	} else if preimageHex == "" && cookieCredential != "" {
+		// Redeem the invoice that was shown on the HTML paywall page, or show a new one
+		paymentHash, metaData, invalidCredential, err := handleCookieCredential(fa.getHTTPrequest(), cookieCredential, invoiceOptions, storageClient, lnClient)
+		if err != nil {
+			errorMsg := fmt.Sprintf("An error occurred during checking the cookie: %+v", err)
+			middlewareOptions.Logger.Error("Couldn't check the cookie", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		} else if invalidCredential.reason != "" {
+			// For example the invoice isn't paid yet or was already redeemed
+			middlewareOptions.Logger.Info("The cookie can't be redeemed, sending a new invoice", "reason", invalidCredential.message)
+			reportRejection(fa, invalidCredential.reason, invoiceOptions, middlewareOptions)
+			respondWithNewInvoice(fa, invoiceOptions, middlewareOptions, lnClient, storageClient, "")
+		} else {
+			// The cookie isn't required anymore
+			fa.setCookie(expiredCookie(fa.getHTTPrequest(), *middlewareOptions.HTML))
+			return redeemInvoice(fa, paymentHash, metaData, invoiceOptions, middlewareOptions, lnClient, storageClient)
+		}
+	} else if preimageHex == "" {
+		respondWithNewInvoice(fa, invoiceOptions, middlewareOptions, lnClient, storageClient, "")
 	} else {
 		// Check if the provided preimage belongs to a settled API payment invoice and that it wasn't already used. Also store used preimages.
-		invalidPreimageMsg, err := handlePreimage(fa.getHTTPrequest(), storageClient, lnClient)
+		metaData, invalidPreimage, err := handlePreimage(fa.getHTTPrequest(), invoiceOptions, storageClient, lnClient)
 		if err != nil {
 			errorMsg := fmt.Sprintf("An error occurred during checking the preimage: %+v", err)
-			log.Printf("%v\n", errorMsg)
-			fa.respondWithError(err, errorMsg, http.StatusInternalServerError)
-		} else if invalidPreimageMsg != "" {
-			log.Printf("%v: %v\n", invalidPreimageMsg, preimageHex)
-			fa.respondWithError(nil, invalidPreimageMsg, http.StatusBadRequest)
+			middlewareOptions.Logger.Error("Couldn't check the preimage", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		} else if invalidPreimage.reason != "" {
+			middlewareOptions.Logger.Warn("The preimage is invalid", "outcome", "rejected", "reason", invalidPreimage.message,
+				logging.Secret("preimage", preimageHex, middlewareOptions.LogSensitiveValues))
+			reportRejection(fa, invalidPreimage.reason, invoiceOptions, middlewareOptions)
+			sendError(fa, nil, invalidPreimage.code, invalidPreimage.message, http.StatusBadRequest)
 		} else {
-			// The preimage was valid (has a corresponding + settled invoice, wasn't used before etc.). Continue to next handler.
-			preimageHash, err := ln.HashPreimage(preimageHex)
-			if err == nil {
-				stdOutLogger.Printf("The provided preimage is valid. Continuing to the next handler. Preimage hash: %v\n", preimageHash)
-			}
-			err = fa.next()
-			if err != nil {
-				return err
-			}
+			// Calculate preimage hash (a.k.a. payment hash) from preimage.
+			// Ignore error because handlePreimage already validated the preimage format.
+			preimageHash, _ := ln.HashPreimage(preimageHex)
+			return redeemInvoice(fa, preimageHash, metaData, invoiceOptions, middlewareOptions, lnClient, storageClient)
 		}
 	}
 	return nil
 }
 
+// redeemInvoice continues with a paid invoice that was successfully checked and marked as used:
+// If the invoice was for a pass or prepaid credits, they're created, otherwise the request is passed to the next handler.
+func redeemInvoice(fa frameworkAbstraction, paymentHash string, metaData *invoiceMetaData, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient) error {
+	reportRedemption(fa, paymentHash, metaData.Price, invoiceOptions, middlewareOptions)
+	if metaData.PassDuration > 0 && middlewareOptions.Pass != nil {
+		// The invoice was for a pass
+		return redeemPass(fa, metaData, middlewareOptions, storageClient)
+	}
+	if metaData.CreditAmount > 0 {
+		// The invoice was for prepaid credits
+		return redeemCredit(fa, metaData, invoiceOptions, middlewareOptions, lnClient, storageClient)
+	}
+	// The invoice was paid and not used before etc. Continue to next handler.
+	middlewareOptions.Logger.Info("The invoice is paid, continuing to the next handler", "outcome", "redeemed", "price", metaData.Price,
+		logging.Identifier("payment_hash", paymentHash, middlewareOptions.LogSensitiveValues))
+	return fa.next()
+}
+
+// reportRedemption reports a redeemed invoice with the given payment hash and price to the metrics and hooks.
+func reportRedemption(fa frameworkAbstraction, paymentHash string, price int64, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions) {
+	middlewareOptions.Metrics.InvoiceRedeemed(getRoute(fa.getHTTPrequest(), invoiceOptions), price)
+	middlewareOptions.Hooks.PreimageRedeemed(fa.getHTTPrequest().Context(), fa.getHTTPrequest(), paymentHash, price)
+}
+
+// reportRejection reports a rejected preimage, L402 credential, cookie, pass token or credit token to the metrics and hooks.
+// The reason must not contain details about the request (see rejection).
+func reportRejection(fa frameworkAbstraction, reason string, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions) {
+	middlewareOptions.Metrics.PreimageRejected(getRoute(fa.getHTTPrequest(), invoiceOptions), reason)
+	middlewareOptions.Hooks.RedemptionRejected(fa.getHTTPrequest().Context(), fa.getHTTPrequest(), reason)
+}
+
+// respondWithNewInvoice generates an invoice for the current request, stores its metadata
+// and sends it in a "402 Payment Required" response.
+// If L402 is enabled, the response additionally contains a "WWW-Authenticate" header with a macaroon and the invoice.
+// If passes are enabled for the request, the invoice is for a pass.
+// Otherwise, if prepaid credits are enabled, the invoice is for buying credits, and creditKey is the storage key of the balance
+// that should be topped up (or empty if a new balance should be created).
+func respondWithNewInvoice(fa frameworkAbstraction, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient, creditKey string) {
+	// Determine the price and memo for the invoice
+	price, memo, err := getPriceAndMemo(fa.getHTTPrequest(), invoiceOptions)
+	if err != nil {
+		respondWithPricingError(fa, err, middlewareOptions.Logger)
+		return
+	}
+	passDuration := time.Duration(0)
+	creditAmount := int64(0)
+	if middlewareOptions.usesPass(fa.getHTTPrequest()) {
+		passDuration = middlewareOptions.Pass.Duration
+	} else if middlewareOptions.usesCredit(fa.getHTTPrequest()) {
+		creditAmount = middlewareOptions.Credit.getPurchaseAmount(price)
+		price = creditAmount
+	}
+	// Calculate the request hash before generating the invoice, in case the body can't be read
+	requestHash := ""
+	if invoiceOptions.BindRequest {
+		requestHash, err = hashRequest(fa.getHTTPrequest(), invoiceOptions.BindHeaders)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Couldn't read the request: %+v", err)
+			middlewareOptions.Logger.Warn("Couldn't read the request", "outcome", "rejected", "error", err)
+			sendError(fa, err, ErrorCodeInvalidRequest, errorMsg, http.StatusBadRequest)
+			return
+		}
+	}
+	// Browsers get the HTML paywall page, which sets a cookie for redeeming the invoice after it was paid
+	cookieToken := ""
+	if middlewareOptions.HTML != nil && wantsHTML(fa.getHTTPrequest()) {
+		cookieToken, err = newToken()
+		if err != nil {
+			errorMsg := fmt.Sprintf("Couldn't generate cookie token: %+v", err)
+			middlewareOptions.Logger.Error("Couldn't generate cookie token", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+			return
+		}
+	}
+	// Generate the invoice
+	invoice, err := lnClient.GenerateInvoice(price, memo, invoiceOptions.Expiry)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't generate invoice: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't generate invoice", "outcome", "error", "price", price, "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	// Make sure the payment hash that's used for looking up the invoice metadata later
+	// is the one that the client's LN node sees when paying the invoice.
+	err = checkPaymentHash(invoice, middlewareOptions.Logger)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't generate invoice: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't generate invoice", "outcome", "error", "price", price, "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+
+	// Cache the invoice metadata.
+	// The expiry is calculated after the invoice was generated, so it's never earlier than the one of the LN node.
+	metadata := invoiceMetaData{
+		ImplDepID:    invoice.ImplDepID,
+		Method:       fa.getHTTPrequest().Method,
+		Path:         fa.getHTTPrequest().URL.Path,
+		Price:        price,
+		RequestHash:  requestHash,
+		CreditAmount: creditAmount,
+		CreditKey:    creditKey,
+		ExpiresAt:    time.Now().Add(invoiceOptions.Expiry),
+		PassDuration: passDuration,
+	}
+	if cookieToken != "" {
+		metadata.CookieTokenHash = hashToken(cookieToken)
+	}
+	// Without the metadata the invoice can't be redeemed, so the client must not pay it.
+	// The error is already reported to the metrics by the storage client wrapper.
+	err = storeInvoiceMetaData(storageClient, invoice.PaymentHash, metadata, invoiceOptions)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't store invoice metadata: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't store invoice metadata", "outcome", "error", "error", err,
+			logging.Identifier("payment_hash", invoice.PaymentHash, middlewareOptions.LogSensitiveValues))
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+
+	headers := make(map[string]string)
+	// Add the L402 challenge
+	if middlewareOptions.L402 != nil {
+		macaroonBase64, err := newL402Macaroon(fa.getHTTPrequest(), invoice.PaymentHash, *middlewareOptions.L402)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Couldn't create L402 macaroon: %+v", err)
+			middlewareOptions.Logger.Error("Couldn't create L402 macaroon", "outcome", "error", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+			return
+		}
+		headers["WWW-Authenticate"] = "L402 macaroon=\"" + macaroonBase64 + "\", invoice=\"" + invoice.PaymentRequest + "\""
+	}
+
+	// Respond with the invoice
+	middlewareOptions.Logger.Info("Sending invoice", "outcome", "invoice", "price", price,
+		logging.Identifier("payment_hash", invoice.PaymentHash, middlewareOptions.LogSensitiveValues))
+	middlewareOptions.Metrics.InvoiceGenerated(getRoute(fa.getHTTPrequest(), invoiceOptions), price)
+	middlewareOptions.Hooks.InvoiceGenerated(fa.getHTTPrequest().Context(), fa.getHTTPrequest(), invoice)
+	if cookieToken != "" {
+		// The cookie can be used as long as the invoice metadata is stored
+		cookie := newCookie(fa.getHTTPrequest(), *middlewareOptions.HTML, invoice.PaymentHash, cookieToken, invoiceOptions.Expiry+invoiceOptions.RedemptionWindow)
+		respondWithHTMLpage(fa, headers, *middlewareOptions.HTML, HTMLPageData{
+			Invoice:     invoice.PaymentRequest,
+			PaymentHash: invoice.PaymentHash,
+			Amount:      price,
+			Memo:        memo,
+			ExpiresAt:   metadata.ExpiresAt,
+		}, cookie)
+	} else {
+		sendInvoice(fa, headers, invoice, price, memo, metadata.ExpiresAt)
+	}
+}
+
 // handlePreimage does the following:
 // 1) Validate the preimage format (encoding, length)
 // 2) Check if the invoice metadata exists in the storage
 // 3) Check if the current HTTP verb and URL path match the ones used for creating the invoice
-// 4) Check if the payment hash was already used in a previous request
-// 5) Check if the invoice was settled
-// 6) Mark the invoice metadata as used, so it can't be used in future requests
+// 4) Check if the current query string, headers and body match the ones used for creating the invoice (if the invoice was bound to them)
+// 5) Check if the payment hash was already used in a previous request
+// 6) Check if the invoice was settled (and if not, if it expired)
+// 7) Mark the invoice metadata as used, so it can't be used in future requests
 // Note: The payment hash (a.k.a. preimage hash) can be calculated from the preimage.
 //
-// Returns a string and an error.
-// The string contains detailed info about the result in case the preimage is invalid
+// Returns the invoice metadata, a rejection and an error.
+// The rejection contains detailed info about the result in case the preimage is invalid
 // (bad encoding, HTTP verb doesn't match, already used etc., generally a client-side error).
 // The error is only non-nil if a server-side error occurred during the check (like the LN node can't be reached).
-// The preimage is only valid if the string is empty and the error is nil.
-func handlePreimage(req *http.Request, storageClient StorageClient, lnClient LNclient) (string, error) {
+// The preimage is only valid if the rejection is empty and the error is nil. Only in this case the metadata is non-nil.
+func handlePreimage(req *http.Request, invoiceOptions InvoiceOptions, storageClient StorageClient, lnClient LNclient) (*invoiceMetaData, rejection, error) {
 	// 1) Validate the preimage format (encoding, length)
 	preimage := req.Header.Get("X-Preimage")
-	errString := validatePreimageFormat(preimage)
-	if errString != "" {
-		return errString, nil
+	invalidPreimage := validatePreimageFormat(preimage)
+	if invalidPreimage.reason != "" {
+		return nil, invalidPreimage, nil
 	}
 
 	// Calculate preimage hash (a.k.a. payment hash) from preimage.
@@ -174,28 +632,53 @@ func handlePreimage(req *http.Request, storageClient StorageClient, lnClient LNc
 	metaData := new(invoiceMetaData)
 	found, err := storageClient.Get(preimageHash, metaData)
 	if err != nil {
-		return "", err
+		return nil, rejection{}, err
 	}
 
 	// Execute all checks that we can do locally.
 
 	// 2. Check if the preimage hash exists in the storage
 	if !found {
-		return "You seem to have sent an invalid preimage or one that doesn't correspond to an invoice that was issued for an initial request", nil
+		return nil, newRejection(ErrorCodePreimageUnknown, "You seem to have sent an invalid preimage or one that doesn't correspond to an invoice that was issued for an initial request"), nil
 	}
+
+	return checkInvoice(req, preimageHash, metaData, invoiceOptions, storageClient, lnClient)
+}
+
+// checkInvoice does the steps 3) to 7) of handlePreimage for the invoice with the given payment hash and stored metadata.
+// It's also used for checking invoices that are redeemed by other means than a preimage, like the cookie of the HTML paywall page.
+func checkInvoice(req *http.Request, paymentHash string, metaData *invoiceMetaData, invoiceOptions InvoiceOptions, storageClient StorageClient, lnClient LNclient) (*invoiceMetaData, rejection, error) {
 	// 3) Check if the current HTTP verb and URL path match the ones used for creating the invoice
 	if req.Method != metaData.Method {
-		return "Your invoice was created for a " + metaData.Method + " request, but you're sending a " + req.Method + " request", nil
+		return nil, rejection{
+			code:    ErrorCodeMethodMismatch,
+			reason:  "Your invoice was created for a different HTTP method than the one of the request you're sending",
+			message: "Your invoice was created for a " + metaData.Method + " request, but you're sending a " + req.Method + " request",
+		}, nil
 	}
 	if req.URL.Path != metaData.Path {
-		return "Your invoice was created for the path \"" + metaData.Path + "\", but you're sending a request to \"" + req.URL.Path + "\"", nil
+		return nil, rejection{
+			code:    ErrorCodePathMismatch,
+			reason:  "Your invoice was created for a different path than the one of the request you're sending",
+			message: "Your invoice was created for the path \"" + metaData.Path + "\", but you're sending a request to \"" + req.URL.Path + "\"",
+		}, nil
+	}
+	// 4) Check if the current query string, headers and body match the ones used for creating the invoice
+	if metaData.RequestHash != "" {
+		requestHash, err := hashRequest(req, invoiceOptions.BindHeaders)
+		if err != nil {
+			return nil, rejection{}, err
+		}
+		if requestHash != metaData.RequestHash {
+			return nil, newRejection(ErrorCodeRequestMismatch, "Your invoice was created for a request with a different query string, headers or body than the request you're sending"), nil
+		}
 	}
-	// 4) Check if the preimage hash was already used in a previous request
+	// 5) Check if the preimage hash was already used in a previous request
 	if metaData.Used {
-		return "You already sent a request with the same preimage. You have to pay a new invoice for and include the corresponding preimage in each request.", nil
+		return nil, newRejection(ErrorCodePreimageAlreadyUsed, alreadyUsedMsg), nil
 	}
 
-	// 5) Check if the invoice was settled
+	// 6) Check if the invoice was settled
 	settled, err := lnClient.CheckInvoice(metaData.ImplDepID)
 	if err != nil {
 		// Returning a non-nil error leads to an "internal server error", but in some cases it's a "bad request".
@@ -203,37 +686,289 @@ func handlePreimage(req *http.Request, storageClient StorageClient, lnClient LNc
 		// TODO: Checks should be done in a more robust and elegant way
 		if reflect.TypeOf(err).Name() == "InvalidByteError" ||
 			err == hex.ErrLength {
-			return "The provided preimage isn't properly hex encoded", nil
+			return nil, newRejection(ErrorCodePreimageMalformed, "The provided preimage isn't properly hex encoded"), nil
 		} else if strings.Contains(err.Error(), "unable to locate invoice") {
-			return "No corresponding invoice was found for the provided preimage", nil
+			return nil, newRejection(ErrorCodeInvoiceNotFound, "No corresponding invoice was found for the provided preimage"), nil
 		} else {
-			return "", err
+			return nil, rejection{}, err
 		}
 	}
 	if !settled {
-		return "You somehow obtained the preimage of the invoice, but the invoice is not settled yet", nil
+		// LN nodes don't accept payments for expired invoices, so an invoice that's not settled and expired
+		// can't be settled anymore. A settled invoice on the other hand was settled before it expired.
+		// Metadata that was stored by previous versions doesn't contain the expiry.
+		if !metaData.ExpiresAt.IsZero() && time.Now().After(metaData.ExpiresAt) {
+			return nil, newRejection(ErrorCodeInvoiceExpired, "The invoice expired before it was paid. Send a request without preimage to get a new invoice."), nil
+		}
+		return nil, newRejection(ErrorCodeInvoiceUnsettled, "You somehow obtained the preimage of the invoice, but the invoice is not settled yet"), nil
+	}
+
+	// 7) Mark the invoice as used, so it can't be used in future requests.
+	// Concurrent requests with the same preimage can all pass the previous checks, but only one can mark it as used.
+	marked, err := markInvoiceUsed(storageClient, paymentHash, metaData, invoiceOptions)
+	if err != nil {
+		return nil, rejection{}, err
+	}
+	if !marked {
+		return nil, newRejection(ErrorCodePreimageAlreadyUsed, alreadyUsedMsg), nil
+	}
+
+	return metaData, rejection{}, nil
+}
+
+// alreadyUsedMsg is the message for rejecting a preimage that was already used in a previous request.
+const alreadyUsedMsg = "You already sent a request with the same preimage. You have to pay a new invoice for and include the corresponding preimage in each request."
+
+// rejection describes why a preimage or L402 credential is invalid.
+type rejection struct {
+	// Code for the client, which doesn't change when the message changes.
+	code ErrorCode
+	// Reason for the rejection, which doesn't contain any details about the request,
+	// so rejections can be grouped by it, for example in metrics.
+	// Empty if the preimage isn't rejected.
+	reason string
+	// Message for the client, which can contain details about the request.
+	message string
+}
+
+// newRejection returns a rejection whose message is the reason itself.
+func newRejection(code ErrorCode, reason string) rejection {
+	return rejection{code: code, reason: reason, message: reason}
+}
+
+// maxMarkUsedAttempts is the maximum number of compare-and-swap attempts for marking invoice metadata as used
+// in case the stored metadata differs from the one that was compared.
+const maxMarkUsedAttempts = 10
+
+// markInvoiceUsed marks the given invoice metadata as used, unless it was already marked as used in the meantime.
+// If the storage client implements AtomicStorageClient, this is done with a compare-and-swap operation,
+// so that only one of multiple concurrent requests with the same preimage wins.
+// Returns true if the metadata was marked as used by this call.
+func markInvoiceUsed(storageClient StorageClient, paymentHash string, metaData *invoiceMetaData, invoiceOptions InvoiceOptions) (bool, error) {
+	usedMetaData := *metaData
+	usedMetaData.Used = true
+
+	atomicStorageClient, isAtomic := storageClient.(AtomicStorageClient)
+	if !isAtomic {
+		err := storeInvoiceMetaData(storageClient, paymentHash, usedMetaData, invoiceOptions)
+		if err != nil {
+			return false, err
+		}
+		metaData.Used = true
+		return true, nil
 	}
 
-	// 6) Mark the invoice as used, so it can't be used in future requests
-	metaData.Used = true
-	err = storageClient.Set(preimageHash, *metaData)
+	// CompareAndSwap keeps the expiry of the stored metadata, so it doesn't have to be set again.
+	var old interface{} = *metaData
+	for i := 0; i < maxMarkUsedAttempts; i++ {
+		swapped, err := atomicStorageClient.CompareAndSwap(paymentHash, old, usedMetaData)
+		if err != nil {
+			return false, err
+		}
+		if swapped {
+			metaData.Used = true
+			return true, nil
+		}
+		// Either another request marked the metadata as used, or the stored metadata has a different JSON representation
+		// than the one we compared it with, because it was stored by a previous version with fewer fields.
+		// Only the former is a reason to reject the request. In the latter case, compare with the stored representation as is,
+		// so the metadata is still only marked as used if no other request changed it in the meantime.
+		storedMetaData := new(json.RawMessage)
+		found, err := storageClient.Get(paymentHash, storedMetaData)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, nil
+		}
+		currentMetaData := new(invoiceMetaData)
+		err = json.Unmarshal(*storedMetaData, currentMetaData)
+		if err != nil {
+			return false, err
+		}
+		if currentMetaData.Used {
+			return false, nil
+		}
+		old = *storedMetaData
+	}
+	return false, errors.New("Couldn't mark the invoice as used because of too many concurrent updates")
+}
+
+// storeInvoiceMetaData stores the invoice metadata for the given payment hash.
+// If the storage client supports it, the metadata expires after the invoice expired and the redemption window passed.
+// Afterwards preimages of the invoice are rejected because no metadata is found for them,
+// just like they're rejected before because the metadata is marked as used.
+func storeInvoiceMetaData(storageClient StorageClient, paymentHash string, metaData invoiceMetaData, invoiceOptions InvoiceOptions) error {
+	extendedStorageClient, ok := storageClient.(ExtendedStorageClient)
+	// Metadata that was stored by previous versions doesn't contain the expiry.
+	if !ok || metaData.ExpiresAt.IsZero() {
+		return storageClient.Set(paymentHash, metaData)
+	}
+	ttl := time.Until(metaData.ExpiresAt.Add(invoiceOptions.RedemptionWindow))
+	if ttl <= 0 {
+		// The redemption window passed while the request was handled
+		return extendedStorageClient.Delete(paymentHash)
+	}
+	return extendedStorageClient.SetWithTTL(paymentHash, metaData, ttl)
+}
+
+// checkPaymentHash returns an error if the payment hash of the invoice doesn't match the one in its payment request.
+// If the payment request can't be decoded (for example because an LNclient for tests returns fake payment requests),
+// it only logs a warning.
+func checkPaymentHash(invoice ln.Invoice, logger *slog.Logger) error {
+	decodedInvoice, err := ln.DecodeInvoice(invoice.PaymentRequest)
+	if err != nil {
+		logger.Warn("Couldn't decode the payment request for checking its payment hash", "error", err)
+		return nil
+	}
+	if !strings.EqualFold(decodedInvoice.PaymentHash, invoice.PaymentHash) {
+		return fmt.Errorf("The LN node returned the payment hash %v, but the payment request contains %v", invoice.PaymentHash, decodedInvoice.PaymentHash)
+	}
+	return nil
+}
+
+// newToken generates a new random token, for example for prepaid credits.
+func newToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
 	if err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(token), nil
+}
 
-	return "", nil
+// hashToken returns the hex encoded SHA-256 hash of the given token.
+// Only the hash is used in storage keys, so the tokens can't be obtained from the storage.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
 
-func validatePreimageFormat(preimageHex string) string {
+func validatePreimageFormat(preimageHex string) rejection {
 	if len(preimageHex) != 64 {
-		return "The provided preimage isn't properly formatted"
+		return newRejection(ErrorCodePreimageMalformed, "The provided preimage isn't properly formatted")
 	}
 	_, err := hex.DecodeString(preimageHex)
 	if err != nil {
 		// Either err == hex.ErrLength or err == hex.InvalidByteError.
-		return "The provided preimage isn't properly hex encoded"
+		return newRejection(ErrorCodePreimageMalformed, "The provided preimage isn't properly hex encoded")
+	}
+	return rejection{}
+}
+
+// respondWithPricingError responds with the status code and message of a PricingRejection,
+// or with "500 Internal Server Error" for other errors that occurred during determining the price.
+func respondWithPricingError(fa frameworkAbstraction, err error, logger *slog.Logger) {
+	if rejection, ok := asPricingRejection(err); ok {
+		logger.Info("The request was rejected during pricing", "outcome", "rejected", "reason", rejection.Message)
+		sendError(fa, err, ErrorCodePricingRejected, rejection.Message, rejection.StatusCode)
+	} else {
+		errorMsg := fmt.Sprintf("Couldn't determine the price: %+v", err)
+		logger.Error("Couldn't determine the price", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+	}
+}
+
+// asPricingRejection returns the first PricingRejection in the chain of the given error.
+// The chain can contain it as value or as pointer.
+func asPricingRejection(err error) (PricingRejection, bool) {
+	var rejection PricingRejection
+	if errors.As(err, &rejection) {
+		return rejection, true
+	}
+	var rejectionPointer *PricingRejection
+	if errors.As(err, &rejectionPointer) && rejectionPointer != nil {
+		return *rejectionPointer, true
+	}
+	return PricingRejection{}, false
+}
+
+// getPriceAndMemo returns the price and memo for the given request.
+// If a pricing function is configured, its result is used.
+// Otherwise the first matching entry of the pricing table is used. If none matches, the default price and memo are used.
+func getPriceAndMemo(req *http.Request, invoiceOptions InvoiceOptions) (int64, string, error) {
+	if invoiceOptions.PricingFunc != nil {
+		price, memo, err := invoiceOptions.PricingFunc(req)
+		if err != nil {
+			if rejection, ok := asPricingRejection(err); ok {
+				if rejection.StatusCode == 0 {
+					rejection.StatusCode = http.StatusBadRequest
+				}
+				return 0, "", rejection
+			}
+			return 0, "", err
+		}
+		if price <= 0 {
+			return 0, "", fmt.Errorf("The pricing function returned an invalid price: %v", price)
+		}
+		return price, memo, nil
+	}
+	for _, routePrice := range invoiceOptions.PricingTable {
+		if routePrice.matches(req) {
+			return routePrice.Price, routePrice.Memo, nil
+		}
+	}
+	return invoiceOptions.Price, invoiceOptions.Memo, nil
+}
+
+// matches returns true if the HTTP method and URL path of the given request match the ones of the pricing table entry.
+func (rp RoutePrice) matches(req *http.Request) bool {
+	if rp.Method != "" && !strings.EqualFold(rp.Method, req.Method) {
+		return false
+	}
+	// The only possible error is path.ErrBadPattern, in which case the entry doesn't match any request.
+	matched, _ := path.Match(rp.Path, req.URL.Path)
+	return matched
+}
+
+// usesPass returns true if passes are enabled for the given request.
+func (mo MiddlewareOptions) usesPass(req *http.Request) bool {
+	return mo.Pass != nil && coversPath(mo.Pass.Paths, req.URL.Path)
+}
+
+// usesCredit returns true if prepaid credits are enabled for the given request.
+func (mo MiddlewareOptions) usesCredit(req *http.Request) bool {
+	return mo.Credit != nil && !mo.usesPass(req)
+}
+
+func assignMiddlewareDefaultValues(middlewareOptions MiddlewareOptions) MiddlewareOptions {
+	if middlewareOptions.LNtimeout < time.Millisecond {
+		middlewareOptions.LNtimeout = DefaultMiddlewareOptions.LNtimeout
+	}
+	if middlewareOptions.Logger == nil {
+		middlewareOptions.Logger = slog.Default()
+	}
+	if middlewareOptions.Metrics == nil {
+		middlewareOptions.Metrics = noopMetrics{}
+	}
+	if middlewareOptions.Hooks == nil {
+		middlewareOptions.Hooks = noopHooks{}
+	}
+
+	// Work on copies of the structs that the pointers point to, so the caller's options don't get modified.
+
+	// L402Options
+	if middlewareOptions.L402 != nil {
+		l402Options := assignL402DefaultValues(*middlewareOptions.L402, middlewareOptions.Logger)
+		middlewareOptions.L402 = &l402Options
 	}
-	return ""
+	// CreditOptions
+	if middlewareOptions.Credit != nil {
+		creditOptions := assignCreditDefaultValues(*middlewareOptions.Credit)
+		middlewareOptions.Credit = &creditOptions
+	}
+	// PassOptions
+	if middlewareOptions.Pass != nil {
+		passOptions := assignPassDefaultValues(*middlewareOptions.Pass)
+		middlewareOptions.Pass = &passOptions
+	}
+	// HTMLOptions
+	if middlewareOptions.HTML != nil {
+		htmlOptions := assignHTMLDefaultValues(*middlewareOptions.HTML)
+		middlewareOptions.HTML = &htmlOptions
+	}
+
+	return middlewareOptions
 }
 
 func assignDefaultValues(invoiceOptions InvoiceOptions) InvoiceOptions {
@@ -242,6 +977,28 @@ func assignDefaultValues(invoiceOptions InvoiceOptions) InvoiceOptions {
 		invoiceOptions.Price = DefaultInvoiceOptions.Price
 	}
 	// Empty Memo is okay.
+	if invoiceOptions.Expiry < time.Second {
+		invoiceOptions.Expiry = DefaultInvoiceOptions.Expiry
+	}
+	if invoiceOptions.RedemptionWindow < time.Second {
+		invoiceOptions.RedemptionWindow = DefaultInvoiceOptions.RedemptionWindow
+	}
+
+	// RoutePrice entries of the pricing table.
+	// Copy the slice, so the caller's pricing table doesn't get modified.
+	if invoiceOptions.PricingTable != nil {
+		pricingTable := make([]RoutePrice, len(invoiceOptions.PricingTable))
+		for i, routePrice := range invoiceOptions.PricingTable {
+			if routePrice.Price <= 0 {
+				routePrice.Price = invoiceOptions.Price
+			}
+			if routePrice.Memo == "" {
+				routePrice.Memo = invoiceOptions.Memo
+			}
+			pricingTable[i] = routePrice
+		}
+		invoiceOptions.PricingTable = pricingTable
+	}
 
 	return invoiceOptions
 }
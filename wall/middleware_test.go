@@ -0,0 +1,1133 @@
+package wall_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+	"github.com/philippgille/ln-paywall/ln/lntest"
+	"github.com/philippgille/ln-paywall/pay"
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+func newTestNode(t *testing.T) *lntest.Node {
+	node, err := lntest.NewNode(lntest.DefaultNodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// newTestHandlerFunc returns a handler func with the middleware in front of it,
+// which responds with "pong" when the request was paid.
+func newTestHandlerFunc(lnClient wall.LNclient) http.HandlerFunc {
+	return wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, lnClient, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+}
+
+// TestPreimageConcurrent sends many concurrent requests with the same preimage
+// and tests if exactly one of them is successful.
+func TestPreimageConcurrent(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newTestHandlerFunc(node)
+
+	// Get the invoice
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusPaymentRequired {
+		t.Fatalf("Expected status code %v, but was %v", http.StatusPaymentRequired, res.Code)
+	}
+	preimage, err := node.Pay(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goroutineCount := 100
+	var successCount int32
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(goroutineCount) // Must be called before any goroutine is started
+	for i := 0; i < goroutineCount; i++ {
+		go func() {
+			defer waitGroup.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Preimage", preimage)
+			res := httptest.NewRecorder()
+			handlerFunc(res, req)
+			if res.Code == http.StatusOK {
+				atomic.AddInt32(&successCount, 1)
+			} else if res.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %v or %v, but was %v", http.StatusOK, http.StatusBadRequest, res.Code)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if successCount != 1 {
+		t.Errorf("Expected exactly one successful request, but there were %v", successCount)
+	}
+}
+
+// slowSetGoMap is a storage.GoMap whose Set method is delayed,
+// so that concurrent requests overlap between reading and storing an object.
+type slowSetGoMap struct {
+	storage.GoMap
+}
+
+func (m slowSetGoMap) Set(k string, v interface{}) error {
+	time.Sleep(10 * time.Millisecond)
+	return m.GoMap.Set(k, v)
+}
+
+// TestPreimageConcurrentLegacyMetaData tests if exactly one of many concurrent requests with the same preimage is successful
+// when the invoice metadata was stored by a previous version, without the fields that were added since.
+func TestPreimageConcurrentLegacyMetaData(t *testing.T) {
+	node := newTestNode(t)
+	storageClient := storage.NewGoMap()
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, slowSetGoMap{storageClient}, wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	invoice, err := node.GenerateInvoice(1, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyMetaData := struct {
+		ImplDepID string
+		Method    string
+		Path      string
+		Used      bool
+	}{
+		ImplDepID: invoice.ImplDepID,
+		Method:    "GET",
+		Path:      "/",
+	}
+	err = storageClient.Set(invoice.PaymentHash, legacyMetaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preimage, err := node.Pay(invoice.PaymentRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make the requests reach the point of marking the invoice as used at the same time
+	node.InjectLatency(lntest.MethodCheckInvoice, 100*time.Millisecond)
+
+	goroutineCount := 100
+	var successCount int32
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(goroutineCount) // Must be called before any goroutine is started
+	for i := 0; i < goroutineCount; i++ {
+		go func() {
+			defer waitGroup.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Preimage", preimage)
+			res := httptest.NewRecorder()
+			handlerFunc(res, req)
+			if res.Code == http.StatusOK {
+				atomic.AddInt32(&successCount, 1)
+			} else if res.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %v or %v, but was %v", http.StatusOK, http.StatusBadRequest, res.Code)
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if successCount != 1 {
+		t.Errorf("Expected exactly one successful request, but there were %v", successCount)
+	}
+}
+
+// TestPayClient tests if the pay.Client can pay for a request to a service with the middleware,
+// with the same fake node on both sides.
+func TestPayClient(t *testing.T) {
+	node := newTestNode(t)
+	server := httptest.NewServer(newTestHandlerFunc(node))
+	defer server.Close()
+	client := pay.NewClient(nil, node)
+
+	res, err := client.Get(server.URL + "/ping")
+	if err != nil {
+		t.Fatalf("An error occurred during the test: %v\n", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "pong", res.StatusCode, string(body))
+	}
+}
+
+// TestUnsettledInvoice tests if a preimage is rejected when the LN node doesn't consider the invoice settled,
+// for example because it expired before it was paid.
+func TestUnsettledInvoice(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newTestHandlerFunc(node)
+
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	preimage, err := node.Pay(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash, err := ln.HashPreimage(preimage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = node.SetInvoiceState(paymentHash, lntest.StateExpired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Preimage", preimage)
+	res = httptest.NewRecorder()
+	handlerFunc(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusBadRequest, res.Code)
+	}
+}
+
+// TestExpiredInvoice tests if a preimage of an unsettled invoice is rejected with a different error code
+// before and after the invoice expired.
+func TestExpiredInvoice(t *testing.T) {
+	node := newTestNode(t)
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.Expiry = time.Second
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	invoice, _, _ := getJSONinvoice(t, handlerFunc, "GET", "/")
+	preimage, err := node.Pay(invoice.Invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client somehow obtained the preimage, but the node doesn't consider the invoice settled
+	err = node.SetInvoiceState(invoice.PaymentHash, lntest.StateOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func() (int, string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Preimage", preimage)
+		req.Header.Set("Accept", "application/json")
+		res := httptest.NewRecorder()
+		handlerFunc(res, req)
+		return res.Code, res.Body.String()
+	}
+
+	unsettled := `{"code":"invoice_unsettled","message":"You somehow obtained the preimage of the invoice, but the invoice is not settled yet"}`
+	if code, body := send(); code != http.StatusBadRequest || body != unsettled {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusBadRequest, unsettled, code, body)
+	}
+	// Wait for the invoice to expire
+	deadline := invoice.ExpiresAt.Add(5 * time.Second)
+	code, body := send()
+	for ; body == unsettled && time.Now().Before(deadline); code, body = send() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expected := `{"code":"invoice_expired","message":"The invoice expired before it was paid. Send a request without preimage to get a new invoice."}`
+	if code != http.StatusBadRequest || body != expected {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusBadRequest, expected, code, body)
+	}
+}
+
+// TestLNnodeError tests if errors of the LN node lead to an internal server error.
+func TestLNnodeError(t *testing.T) {
+	node := newTestNode(t)
+	node.InjectError(lntest.MethodGenerateInvoice, errors.New("connection refused"))
+	handlerFunc := newTestHandlerFunc(node)
+
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusInternalServerError, res.Code)
+	}
+}
+
+// TestLNtimeout tests if a call to an unresponsive LN node is cancelled after the configured timeout
+// and leads to an internal server error.
+func TestLNtimeout(t *testing.T) {
+	node := newTestNode(t)
+	node.InjectLatency(lntest.MethodGenerateInvoice, time.Minute)
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.LNtimeout = 100 * time.Millisecond
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	start := time.Now()
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusInternalServerError, res.Code)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the call to be cancelled after %v, but it took %v\n", middlewareOptions.LNtimeout, time.Since(start))
+	}
+}
+
+// TestLogger tests if the log entries are structured and contain the request's path and outcome,
+// and if sensitive values are redacted.
+func TestLogger(t *testing.T) {
+	node := newTestNode(t)
+	logs := new(bytes.Buffer)
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	// Get the invoice
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/ping", nil))
+	invoice := res.Body.String()
+	// Send a preimage of an invoice that the node didn't create
+	preimage := "119969c2338798cd56708126b5d6c0f6f5e75ed38da7a409b0081d94b4dacbf8"
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set("X-Preimage", preimage)
+	handlerFunc(httptest.NewRecorder(), req)
+
+	if strings.Contains(logs.String(), invoice) || strings.Contains(logs.String(), preimage) {
+		t.Errorf("Expected the invoice and preimage to be redacted, but the logs were: %v\n", logs.String())
+	}
+	var outcomes []string
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		entry := map[string]interface{}{}
+		err := decoder.Decode(&entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry["path"] != "/ping" || entry["method"] != "GET" {
+			t.Errorf("Expected method %v and path %v, but the log entry was %v\n", "GET", "/ping", entry)
+		}
+		if outcome, ok := entry["outcome"].(string); ok {
+			outcomes = append(outcomes, outcome)
+		}
+	}
+	if len(outcomes) != 2 || outcomes[0] != "invoice" || outcomes[1] != "rejected" {
+		t.Errorf("Expected outcomes %v, but was %v\n", []string{"invoice", "rejected"}, outcomes)
+	}
+}
+
+// wrongPaymentHashLNclient returns invoices whose payment hash doesn't match the one in the payment request.
+// The node isn't embedded, so that the middleware can't use its context-aware methods.
+type wrongPaymentHashLNclient struct {
+	node *lntest.Node
+}
+
+func (c wrongPaymentHashLNclient) GenerateInvoice(amount int64, memo string, expiry time.Duration) (ln.Invoice, error) {
+	invoice, err := c.node.GenerateInvoice(amount, memo, expiry)
+	invoice.PaymentHash = "0000000000000000000000000000000000000000000000000000000000000000"
+	return invoice, err
+}
+
+func (c wrongPaymentHashLNclient) CheckInvoice(id string) (bool, error) {
+	return c.node.CheckInvoice(id)
+}
+
+// TestWrongPaymentHash tests if an invoice isn't sent to the client when the payment hash returned by the LN node
+// doesn't match the one in the payment request.
+func TestWrongPaymentHash(t *testing.T) {
+	handlerFunc := newTestHandlerFunc(wrongPaymentHashLNclient{newTestNode(t)})
+
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusInternalServerError, res.Code)
+	}
+}
+
+// failingStorageClient is a wall.StorageClient whose Set method always fails.
+type failingStorageClient struct {
+	wall.StorageClient
+}
+
+func (c failingStorageClient) Set(k string, v interface{}) error {
+	return errors.New("disk full")
+}
+
+// TestStorageError tests if an invoice isn't sent to the client when its metadata can't be stored,
+// because it couldn't be redeemed then, and if the error is reported to the metrics.
+func TestStorageError(t *testing.T) {
+	metrics := newRecordingMetrics()
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Metrics = metrics
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, newTestNode(t), failingStorageClient{storage.NewGoMap()}, middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %v, but was %v\n", http.StatusInternalServerError, res.Code)
+	}
+	if len(metrics.storageErrors) != 1 || metrics.storageErrors[0] != "Set" {
+		t.Errorf("Expected storage errors %v, but was %v\n", []string{"Set"}, metrics.storageErrors)
+	}
+}
+
+// recordingMetrics is a wall.Metrics implementation that records the redeemed amounts, accepted L402 credentials
+// and rejection reasons per route and the methods of failed storage calls.
+type recordingMetrics struct {
+	lock          sync.Mutex
+	redemptions   map[string][]int64
+	accepted      map[string]int
+	rejections    map[string][]string
+	storageErrors []string
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		redemptions: map[string][]int64{},
+		accepted:    map[string]int{},
+		rejections:  map[string][]string{},
+	}
+}
+
+func (m *recordingMetrics) InvoiceGenerated(string, int64)      {}
+func (m *recordingMetrics) LNcall(string, time.Duration, error) {}
+
+func (m *recordingMetrics) InvoiceRedeemed(route string, amount int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.redemptions[route] = append(m.redemptions[route], amount)
+}
+
+func (m *recordingMetrics) CredentialAccepted(route string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.accepted[route]++
+}
+
+func (m *recordingMetrics) StorageError(method string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.storageErrors = append(m.storageErrors, method)
+}
+
+func (m *recordingMetrics) PreimageRejected(route string, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rejections[route] = append(m.rejections[route], reason)
+}
+
+// TestMetricsRejectionReason tests if the rejection reason that's reported to the metrics doesn't contain details about the request,
+// while the response does, and if the route is the matching pattern of the pricing table.
+// This is tested for preimages and L402 credentials.
+func TestMetricsRejectionReason(t *testing.T) {
+	node := newTestNode(t)
+	metrics := newRecordingMetrics()
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.PricingTable = []wall.RoutePrice{{Path: "/items/*"}}
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.Metrics = metrics
+	middlewareOptions.L402 = &wall.L402Options{RootKey: testRootKey}
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/items/1", nil))
+	preimage, err := node.Pay(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/items/2", nil)
+	req.Header.Set("X-Preimage", preimage)
+	res = httptest.NewRecorder()
+	handlerFunc(res, req)
+
+	if !strings.Contains(res.Body.String(), "/items/1") {
+		t.Errorf("Expected the response to contain the path %v, but was %v\n", "/items/1", res.Body.String())
+	}
+	reasons := metrics.rejections["/items/*"]
+	if len(reasons) != 1 || strings.Contains(reasons[0], "/items/") {
+		t.Errorf("Expected one reason without the path, but was %v\n", metrics.rejections)
+	}
+
+	// The same for L402 credentials
+	m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/items/1")
+	authorization := "L402 " + encodeMacaroon(t, m) + ":" + preimage
+	req = httptest.NewRequest("GET", "/items/2", nil)
+	req.Header.Set("Authorization", authorization)
+	res = httptest.NewRecorder()
+	handlerFunc(res, req)
+	if !strings.Contains(res.Body.String(), "/items/1") {
+		t.Errorf("Expected the response to contain the path %v, but was %v\n", "/items/1", res.Body.String())
+	}
+	reasons = metrics.rejections["/items/*"]
+	if len(reasons) != 2 || strings.Contains(reasons[1], "/items/") {
+		t.Errorf("Expected two reasons without the path, but was %v\n", metrics.rejections)
+	}
+}
+
+// TestMetricsL402 tests if single-use L402 credentials are reported as redeemed invoices with their price,
+// and other L402 credentials as accepted credentials for each request, without redeeming the invoice.
+func TestMetricsL402(t *testing.T) {
+	node := newTestNode(t)
+	for _, singleUse := range []bool{true, false} {
+		metrics := newRecordingMetrics()
+		invoiceOptions := wall.DefaultInvoiceOptions
+		invoiceOptions.PricingTable = []wall.RoutePrice{{Path: "/items/*", Price: 10}}
+		middlewareOptions := wall.DefaultMiddlewareOptions
+		middlewareOptions.Metrics = metrics
+		middlewareOptions.L402 = &wall.L402Options{RootKey: testRootKey, SingleUse: singleUse}
+		handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("pong"))
+		})
+
+		m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/items/1")
+		authorization := "L402 " + encodeMacaroon(t, m) + ":" + preimage
+		for i := 0; i < 2; i++ {
+			sendL402(t, handlerFunc, "GET", "/items/1", authorization)
+		}
+
+		expectedRedemptions := map[string][]int64{}
+		expectedAccepted := map[string]int{"/items/*": 2}
+		if singleUse {
+			// The second request is rejected
+			expectedRedemptions = map[string][]int64{"/items/*": {10}}
+			expectedAccepted = map[string]int{}
+		}
+		if !reflect.DeepEqual(metrics.redemptions, expectedRedemptions) || !reflect.DeepEqual(metrics.accepted, expectedAccepted) {
+			t.Errorf("Expected (%v, %v) for single-use %v, but was (%v, %v)\n", expectedRedemptions, expectedAccepted, singleUse,
+				metrics.redemptions, metrics.accepted)
+		}
+	}
+}
+
+type userKey struct{}
+
+// recordingHooks is a wall.Hooks implementation that records the events together with the user ID from the context.
+type recordingHooks struct {
+	events []string
+}
+
+func (h *recordingHooks) InvoiceGenerated(ctx context.Context, req *http.Request, invoice ln.Invoice) {
+	h.events = append(h.events, ctx.Value(userKey{}).(string)+" generated "+invoice.PaymentHash)
+}
+
+func (h *recordingHooks) PreimageRedeemed(ctx context.Context, req *http.Request, paymentHash string, amount int64) {
+	h.events = append(h.events, ctx.Value(userKey{}).(string)+" redeemed "+paymentHash)
+}
+
+func (h *recordingHooks) CredentialAccepted(ctx context.Context, req *http.Request, paymentHash string) {
+	h.events = append(h.events, ctx.Value(userKey{}).(string)+" accepted "+paymentHash)
+}
+
+func (h *recordingHooks) RedemptionRejected(ctx context.Context, req *http.Request, reason string) {
+	h.events = append(h.events, ctx.Value(userKey{}).(string)+" rejected")
+}
+
+// TestHooks tests if the hooks are called for generated invoices and redeemed and rejected preimages,
+// with the context of the request, for each way of sending a preimage or credential.
+func TestHooks(t *testing.T) {
+	node := newTestNode(t)
+	newHandlerFunc := func(hooks wall.Hooks, middlewareOptions wall.MiddlewareOptions) http.HandlerFunc {
+		middlewareOptions.Hooks = hooks
+		handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("pong"))
+		})
+		// Simulates a previous middleware that authenticates the user
+		return func(w http.ResponseWriter, r *http.Request) {
+			handlerFunc(w, r.WithContext(context.WithValue(r.Context(), userKey{}, "alice")))
+		}
+	}
+	send := func(handlerFunc http.HandlerFunc, header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(header, value)
+		res := httptest.NewRecorder()
+		handlerFunc(res, req)
+		return res
+	}
+	pay := func(invoice string) (string, string) {
+		preimage, err := node.Pay(invoice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paymentHash, err := ln.HashPreimage(preimage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return preimage, paymentHash
+	}
+	getPaymentHash := func(invoice string) string {
+		decodedInvoice, err := ln.DecodeInvoice(invoice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodedInvoice.PaymentHash
+	}
+
+	t.Run("preimage", func(t *testing.T) {
+		hooks := &recordingHooks{}
+		handlerFunc := newHandlerFunc(hooks, wall.DefaultMiddlewareOptions)
+
+		res := send(handlerFunc, "X-Preimage", "")
+		preimage, paymentHash := pay(res.Body.String())
+		send(handlerFunc, "X-Preimage", preimage)
+		send(handlerFunc, "X-Preimage", preimage)
+
+		expected := []string{"alice generated " + paymentHash, "alice redeemed " + paymentHash, "alice rejected"}
+		if !reflect.DeepEqual(hooks.events, expected) {
+			t.Errorf("Expected %v, but was %v\n", expected, hooks.events)
+		}
+	})
+
+	t.Run("L402", func(t *testing.T) {
+		for _, singleUse := range []bool{true, false} {
+			hooks := &recordingHooks{}
+			middlewareOptions := wall.DefaultMiddlewareOptions
+			middlewareOptions.L402 = &wall.L402Options{RootKey: testRootKey, SingleUse: singleUse}
+			handlerFunc := newHandlerFunc(hooks, middlewareOptions)
+
+			m, preimage := getL402Credential(t, node, handlerFunc, "GET", "/")
+			paymentHash, err := ln.HashPreimage(preimage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authorization := "L402 " + encodeMacaroon(t, m) + ":" + preimage
+			sendL402(t, handlerFunc, "GET", "/", authorization)
+			sendL402(t, handlerFunc, "GET", "/", authorization)
+			sendL402(t, handlerFunc, "POST", "/", authorization)
+
+			expected := []string{"alice generated " + paymentHash, "alice redeemed " + paymentHash, "alice rejected", "alice rejected"}
+			if !singleUse {
+				expected = []string{"alice generated " + paymentHash, "alice accepted " + paymentHash, "alice accepted " + paymentHash, "alice rejected"}
+			}
+			if !reflect.DeepEqual(hooks.events, expected) {
+				t.Errorf("Expected %v for single-use %v, but was %v\n", expected, singleUse, hooks.events)
+			}
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		hooks := &recordingHooks{}
+		middlewareOptions := wall.DefaultMiddlewareOptions
+		middlewareOptions.HTML = &wall.HTMLOptions{}
+		handlerFunc := newHandlerFunc(hooks, middlewareOptions)
+		sendCookie := func(cookie *http.Cookie) (*http.Cookie, string) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", "text/html")
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			res := httptest.NewRecorder()
+			handlerFunc(res, req)
+			_, invoice, _ := strings.Cut(res.Body.String(), `<p class="invoice">`)
+			invoice, _, _ = strings.Cut(invoice, "</p>")
+			cookies := res.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("Expected one cookie, but was %v\n", cookies)
+			}
+			return cookies[0], invoice
+		}
+
+		cookie, invoice := sendCookie(nil)
+		// The invoice isn't paid yet, so a new one is sent
+		_, newInvoice := sendCookie(cookie)
+		_, paymentHash := pay(invoice)
+		sendCookie(cookie)
+
+		expected := []string{"alice generated " + paymentHash, "alice rejected", "alice generated " + getPaymentHash(newInvoice), "alice redeemed " + paymentHash}
+		if !reflect.DeepEqual(hooks.events, expected) {
+			t.Errorf("Expected %v, but was %v\n", expected, hooks.events)
+		}
+	})
+
+	t.Run("pass", func(t *testing.T) {
+		hooks := &recordingHooks{}
+		middlewareOptions := wall.DefaultMiddlewareOptions
+		middlewareOptions.Pass = &wall.PassOptions{}
+		handlerFunc := newHandlerFunc(hooks, middlewareOptions)
+
+		res := send(handlerFunc, "X-Pass-Token", "unknown")
+		preimage, paymentHash := pay(res.Body.String())
+		send(handlerFunc, "X-Preimage", preimage)
+
+		expected := []string{"alice rejected", "alice generated " + paymentHash, "alice redeemed " + paymentHash}
+		if !reflect.DeepEqual(hooks.events, expected) {
+			t.Errorf("Expected %v, but was %v\n", expected, hooks.events)
+		}
+	})
+
+	t.Run("credit", func(t *testing.T) {
+		hooks := &recordingHooks{}
+		middlewareOptions := wall.DefaultMiddlewareOptions
+		middlewareOptions.Credit = &wall.CreditOptions{Calls: 1}
+		handlerFunc := newHandlerFunc(hooks, middlewareOptions)
+
+		res := send(handlerFunc, "X-Credit-Token", "")
+		preimage, paymentHash := pay(res.Body.String())
+		token := send(handlerFunc, "X-Preimage", preimage).Header().Get("X-Credit-Token")
+		// The balance is used up, so a top-up invoice is sent
+		res = send(handlerFunc, "X-Credit-Token", token)
+
+		expected := []string{"alice generated " + paymentHash, "alice redeemed " + paymentHash, "alice rejected", "alice generated " + getPaymentHash(res.Body.String())}
+		if !reflect.DeepEqual(hooks.events, expected) {
+			t.Errorf("Expected %v, but was %v\n", expected, hooks.events)
+		}
+	})
+}
+
+// TestJSONResponses tests if invoices and errors are sent as JSON objects when the client accepts JSON,
+// and as plain text otherwise.
+func TestJSONResponses(t *testing.T) {
+	node := newTestNode(t)
+	handlerFunc := newTestHandlerFunc(node)
+	send := func(method string, preimage string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("X-Preimage", preimage)
+		req.Header.Set("Accept", accept)
+		res := httptest.NewRecorder()
+		handlerFunc(res, req)
+		return res
+	}
+
+	res := send("GET", "", "application/json")
+	invoice := struct {
+		Invoice     string    `json:"invoice"`
+		PaymentHash string    `json:"payment_hash"`
+		Amount      int64     `json:"amount"`
+		Memo        string    `json:"memo"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{}
+	err := json.Unmarshal(res.Body.Bytes(), &invoice)
+	if err != nil {
+		t.Fatalf("Expected a JSON body, but was %v (%v)\n", res.Body.String(), err)
+	}
+	if res.Code != http.StatusPaymentRequired || res.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusPaymentRequired, "application/json", res.Code, res.Header().Get("Content-Type"))
+	}
+	if invoice.Amount != wall.DefaultInvoiceOptions.Price || invoice.Memo != wall.DefaultInvoiceOptions.Memo ||
+		len(invoice.PaymentHash) != 64 || time.Until(invoice.ExpiresAt) < 59*time.Minute {
+		t.Errorf("Unexpected invoice data: %+v\n", invoice)
+	}
+	preimage, err := node.Pay(invoice.Invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method   string
+		accept   string
+		expected string
+	}{
+		{"POST", "application/json", `{"code":"method_mismatch","message":"Your invoice was created for a GET request, but you're sending a POST request"}`},
+		{"GET", "*/*", "pong"},
+		{"GET", "application/json", `{"code":"preimage_already_used","message":"You already sent a request with the same preimage. You have to pay a new invoice for and include the corresponding preimage in each request."}`},
+		{"GET", "", "You already sent a request with the same preimage. You have to pay a new invoice for and include the corresponding preimage in each request.\n"},
+	}
+	for _, testCase := range testCases {
+		res = send(testCase.method, preimage, testCase.accept)
+		if res.Body.String() != testCase.expected {
+			t.Errorf("Expected %v, but was %v\n", testCase.expected, res.Body.String())
+		}
+	}
+}
+
+// jsonInvoice is the JSON body of "402 Payment Required" responses.
+type jsonInvoice struct {
+	Invoice     string    `json:"invoice"`
+	PaymentHash string    `json:"payment_hash"`
+	Amount      int64     `json:"amount"`
+	Memo        string    `json:"memo"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// getJSONinvoice sends a request without credential to the handler func and returns the invoice of the JSON response,
+// or the status code and body of the response if it's not "402 Payment Required".
+func getJSONinvoice(t *testing.T, handlerFunc http.HandlerFunc, method string, path string) (jsonInvoice, int, string) {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Accept", "application/json")
+	res := httptest.NewRecorder()
+	handlerFunc(res, req)
+	invoice := jsonInvoice{}
+	if res.Code != http.StatusPaymentRequired {
+		return invoice, res.Code, res.Body.String()
+	}
+	err := json.Unmarshal(res.Body.Bytes(), &invoice)
+	if err != nil {
+		t.Fatalf("Expected a JSON body, but was %v (%v)\n", res.Body.String(), err)
+	}
+	return invoice, res.Code, res.Body.String()
+}
+
+// TestHTMLPage tests if browsers get the HTML paywall page,
+// and if the invoice is redeemed with the page's cookie after it was paid.
+func TestHTMLPage(t *testing.T) {
+	node := newTestNode(t)
+	storageClient := storage.NewGoMap()
+	middlewareOptions := wall.DefaultMiddlewareOptions
+	middlewareOptions.HTML = &wall.HTMLOptions{}
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storageClient, middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	statusHandler := wall.NewStatusHandler(node, storageClient, wall.DefaultStatusOptions)
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		handlerFunc(res, req)
+		return res
+	}
+	getStatus := func(paymentHash string) string {
+		res := httptest.NewRecorder()
+		statusHandler(res, httptest.NewRequest("GET", "/ln-paywall/status?payment_hash="+paymentHash, nil))
+		return res.Body.String()
+	}
+
+	// Clients that don't accept HTML still get the plain invoice
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	if res.Header().Get("Content-Type") != "application/vnd.lightning.bolt11" {
+		t.Errorf("Expected %v, but was %v\n", "application/vnd.lightning.bolt11", res.Header().Get("Content-Type"))
+	}
+
+	res = send(nil)
+	if res.Code != http.StatusPaymentRequired || res.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusPaymentRequired, "text/html; charset=utf-8", res.Code, res.Header().Get("Content-Type"))
+	}
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "ln-paywall" || !cookies[0].HttpOnly {
+		t.Fatalf("Expected one HttpOnly cookie named %v, but was %v\n", "ln-paywall", cookies)
+	}
+	cookie := cookies[0]
+	paymentHash, _, _ := strings.Cut(cookie.Value, ".")
+	body := res.Body.String()
+	_, invoice, _ := strings.Cut(body, `<p class="invoice">`)
+	invoice, _, _ = strings.Cut(invoice, "</p>")
+	if !strings.Contains(body, `href="lightning:`+invoice+`"`) || !strings.Contains(body, `src="data:image/png;base64,`) {
+		t.Errorf("Expected the page to contain the invoice and QR code, but was %v\n", body)
+	}
+
+	// Unpaid invoices aren't redeemed, a new invoice is sent instead
+	if getStatus(paymentHash) != `{"status":"unpaid"}` {
+		t.Errorf("Expected %v, but was %v\n", `{"status":"unpaid"}`, getStatus(paymentHash))
+	}
+	res = send(cookie)
+	if res.Code != http.StatusPaymentRequired {
+		t.Errorf("Expected %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+	}
+
+	_, err := node.Pay(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getStatus(paymentHash) != `{"status":"paid"}` {
+		t.Errorf("Expected %v, but was %v\n", `{"status":"paid"}`, getStatus(paymentHash))
+	}
+	res = send(cookie)
+	if res.Body.String() != "pong" {
+		t.Errorf("Expected %v, but was %v\n", "pong", res.Body.String())
+	}
+	// The cookie is deleted after redeeming the invoice
+	cookies = res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected an expired cookie, but was %v\n", cookies)
+	}
+	if getStatus(paymentHash) != `{"status":"redeemed"}` {
+		t.Errorf("Expected %v, but was %v\n", `{"status":"redeemed"}`, getStatus(paymentHash))
+	}
+
+	// A redeemed invoice can't be redeemed again, and a cookie with a wrong token can't be used at all
+	wrongToken := *cookie
+	wrongToken.Value = paymentHash + ".wrong"
+	for _, cookie := range []*http.Cookie{cookie, &wrongToken} {
+		res = send(cookie)
+		if res.Code != http.StatusPaymentRequired {
+			t.Errorf("Expected %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+		}
+	}
+}
+
+// TestHTMLPagePassAndCredit tests if browsers, which don't send the "X-Pass-Token" and "X-Credit-Token" headers,
+// can use passes and prepaid credits that they bought via the HTML paywall page.
+// Like a browser, the client keeps the cookies and reloads the page after paying the invoice.
+func TestHTMLPagePassAndCredit(t *testing.T) {
+	for _, name := range []string{"pass", "credit"} {
+		t.Run(name, func(t *testing.T) {
+			node := newTestNode(t)
+			middlewareOptions := wall.DefaultMiddlewareOptions
+			middlewareOptions.HTML = &wall.HTMLOptions{}
+			if name == "pass" {
+				middlewareOptions.Pass = &wall.PassOptions{Duration: time.Hour}
+			} else {
+				middlewareOptions.Credit = &wall.CreditOptions{Calls: 2}
+			}
+			handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storage.NewGoMap(), middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("pong"))
+			})
+			server := httptest.NewServer(handlerFunc)
+			defer server.Close()
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Jar: jar}
+			load := func() (int, string) {
+				req, err := http.NewRequest("GET", server.URL+"/items/1", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+				res, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return res.StatusCode, string(body)
+			}
+			// Pays the invoice on the page and reloads it
+			payAndReload := func(body string) {
+				_, invoice, _ := strings.Cut(body, `<p class="invoice">`)
+				invoice, _, _ = strings.Cut(invoice, "</p>")
+				_, err := node.Pay(invoice)
+				if err != nil {
+					t.Fatal(err)
+				}
+				code, body := load()
+				if code != http.StatusOK || body != "pong" {
+					t.Fatalf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "pong", code, body)
+				}
+			}
+
+			code, body := load()
+			if code != http.StatusPaymentRequired {
+				t.Fatalf("Expected %v, but was %v\n", http.StatusPaymentRequired, code)
+			}
+			payAndReload(body)
+
+			// The pass or the second of the two bought requests is used without paying again
+			code, body = load()
+			if code != http.StatusOK || body != "pong" {
+				t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "pong", code, body)
+			}
+			if name == "pass" {
+				return
+			}
+
+			// The used up balance is topped up via the page as well
+			code, body = load()
+			if code != http.StatusPaymentRequired {
+				t.Fatalf("Expected %v, but was %v\n", http.StatusPaymentRequired, code)
+			}
+			payAndReload(body)
+			code, body = load()
+			if code != http.StatusOK || body != "pong" {
+				t.Errorf("Expected (%v, %v), but was (%v, %v)\n", http.StatusOK, "pong", code, body)
+			}
+			code, _ = load()
+			if code != http.StatusPaymentRequired {
+				t.Errorf("Expected %v, but was %v\n", http.StatusPaymentRequired, code)
+			}
+		})
+	}
+}
+
+// TestStatusHandlerErrors tests the status handler's responses to invalid and unknown payment hashes.
+func TestStatusHandlerErrors(t *testing.T) {
+	statusHandler := wall.NewStatusHandler(newTestNode(t), storage.NewGoMap(), wall.DefaultStatusOptions)
+	testCases := []struct {
+		paymentHash string
+		expected    int
+	}{
+		{"", http.StatusBadRequest},
+		{"abc", http.StatusBadRequest},
+		{strings.Repeat("ab", 32), http.StatusNotFound},
+	}
+	for _, testCase := range testCases {
+		res := httptest.NewRecorder()
+		statusHandler(res, httptest.NewRequest("GET", "/ln-paywall/status?payment_hash="+testCase.paymentHash, nil))
+		if res.Code != testCase.expected {
+			t.Errorf("Expected %v, but was %v\n", testCase.expected, res.Code)
+		}
+	}
+}
+
+// newTestStatusHandler returns a status handler that checks the status every 10 milliseconds,
+// together with a handler func with the middleware in front of it that uses the same storage client.
+func newTestStatusHandler(node *lntest.Node) (http.HandlerFunc, http.HandlerFunc) {
+	storageClient := storage.NewGoMap()
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storageClient, wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	statusOptions := wall.DefaultStatusOptions
+	statusOptions.PollInterval = 10 * time.Millisecond
+	return wall.NewStatusHandler(node, storageClient, statusOptions), handlerFunc
+}
+
+// TestStatusHandlerLongPolling tests if a long-polling request returns when the invoice is paid,
+// and if it returns the unpaid status when the wait duration elapsed.
+func TestStatusHandlerLongPolling(t *testing.T) {
+	node := newTestNode(t)
+	statusHandler, handlerFunc := newTestStatusHandler(node)
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	invoice := res.Body.String()
+	decodedInvoice, err := ln.DecodeInvoice(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash := decodedInvoice.PaymentHash
+
+	start := time.Now()
+	res = httptest.NewRecorder()
+	statusHandler(res, httptest.NewRequest("GET", "/ln-paywall/status?wait=1&payment_hash="+paymentHash, nil))
+	if res.Body.String() != `{"status":"unpaid"}` || time.Since(start) < time.Second {
+		t.Errorf("Expected %v after 1s, but was %v after %v\n", `{"status":"unpaid"}`, res.Body.String(), time.Since(start))
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		node.Pay(invoice)
+	}()
+	start = time.Now()
+	res = httptest.NewRecorder()
+	statusHandler(res, httptest.NewRequest("GET", "/ln-paywall/status?wait=10&payment_hash="+paymentHash, nil))
+	if res.Body.String() != `{"status":"paid"}` || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected %v before the wait duration elapsed, but was %v after %v\n", `{"status":"paid"}`, res.Body.String(), time.Since(start))
+	}
+
+	res = httptest.NewRecorder()
+	statusHandler(res, httptest.NewRequest("GET", "/ln-paywall/status?wait=-1&payment_hash="+paymentHash, nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, but was %v\n", http.StatusBadRequest, res.Code)
+	}
+}
+
+// TestStatusHandlerSSE tests if the status handler sends each status change as Server-Sent Event
+// and ends the stream when the invoice was redeemed.
+func TestStatusHandlerSSE(t *testing.T) {
+	node := newTestNode(t)
+	statusHandler, handlerFunc := newTestStatusHandler(node)
+	server := httptest.NewServer(statusHandler)
+	defer server.Close()
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	invoice := res.Body.String()
+	decodedInvoice, err := ln.DecodeInvoice(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentHash := decodedInvoice.PaymentHash
+
+	req, _ := http.NewRequest("GET", server.URL+"?payment_hash="+paymentHash, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if stream.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected %v, but was %v\n", "text/event-stream", stream.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(stream.Body)
+	readEvent := func() string {
+		event, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Skip the empty line after each event
+		reader.ReadString('\n')
+		return event
+	}
+
+	if event := readEvent(); event != "data: {\"status\":\"unpaid\"}\n" {
+		t.Errorf("Expected %v, but was %v\n", `data: {"status":"unpaid"}`, event)
+	}
+	preimage, err := node.Pay(invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := readEvent(); event != "data: {\"status\":\"paid\"}\n" {
+		t.Errorf("Expected %v, but was %v\n", `data: {"status":"paid"}`, event)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Preimage", preimage)
+	handlerFunc(httptest.NewRecorder(), req)
+	if event := readEvent(); event != "data: {\"status\":\"redeemed\"}\n" {
+		t.Errorf("Expected %v, but was %v\n", `data: {"status":"redeemed"}`, event)
+	}
+	_, err = reader.ReadString('\n')
+	if err != io.EOF {
+		t.Errorf("Expected %v, but was %v\n", io.EOF, err)
+	}
+}
+
+// TestStatusHandlerUnknown tests if the event stream reports the unknown status and ends
+// when the invoice metadata is deleted while the stream is open.
+func TestStatusHandlerUnknown(t *testing.T) {
+	node := newTestNode(t)
+	storageClient := storage.NewGoMap()
+	handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storageClient, wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	statusOptions := wall.DefaultStatusOptions
+	statusOptions.PollInterval = 10 * time.Millisecond
+	server := httptest.NewServer(wall.NewStatusHandler(node, storageClient, statusOptions))
+	defer server.Close()
+	res := httptest.NewRecorder()
+	handlerFunc(res, httptest.NewRequest("GET", "/", nil))
+	decodedInvoice, err := ln.DecodeInvoice(res.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"?payment_hash="+decodedInvoice.PaymentHash, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	reader := bufio.NewReader(stream.Body)
+	for i, expected := range []string{`{"status":"unpaid"}`, `{"status":"unknown"}`} {
+		event, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.ReadString('\n')
+		if event != "data: "+expected+"\n" {
+			t.Errorf("Expected %v, but was %v\n", "data: "+expected, event)
+		}
+		if i == 0 {
+			// Like after the redemption window
+			err = storageClient.Delete(decodedInvoice.PaymentHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_, err = reader.ReadString('\n')
+	if err != io.EOF {
+		t.Errorf("Expected %v, but was %v\n", io.EOF, err)
+	}
+}
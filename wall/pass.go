@@ -0,0 +1,129 @@
+package wall
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+)
+
+// PassOptions are the options for time-window access passes.
+//
+// Instead of paying one invoice per request, the client pays one invoice for unlimited requests during a time window,
+// for example "500 Satoshis for 24 hours of access". The price is the one of the request that leads to the invoice
+// (see InvoiceOptions), so you probably want to use a higher price than for single requests.
+// After the invoice was paid and the client sent the preimage in the "X-Preimage" header (as usual),
+// the response contains an "X-Pass-Token" header with a token and an "X-Pass-Expires" header with the expiry of the pass
+// (in RFC 3339 format). In subsequent requests the client only sends the token in the "X-Pass-Token" header.
+// Browsers that paid via the HTML paywall page get the token in a cookie instead (see HTMLOptions).
+// After the pass expired, the response is a "402 Payment Required" with an invoice for a new pass.
+type PassOptions struct {
+	// Duration for which a pass is valid after the invoice was redeemed.
+	// Optional (24 hours by default).
+	Duration time.Duration
+	// Patterns that the URL path of a request must match for passes to be used,
+	// for example "/qr" or "/images/*". The syntax is the one of path.Match (https://golang.org/pkg/path/#Match).
+	// A pass is only valid for these paths, and for requests to other paths invoices are valid for single requests, as usual.
+	// Optional (nil by default, which means all paths).
+	Paths []string
+}
+
+// DefaultPassOptions provides default values for PassOptions.
+var DefaultPassOptions = PassOptions{
+	Duration: 24 * time.Hour,
+}
+
+func assignPassDefaultValues(passOptions PassOptions) PassOptions {
+	if passOptions.Duration <= 0 {
+		passOptions.Duration = DefaultPassOptions.Duration
+	}
+
+	return passOptions
+}
+
+// accessPass is a pass that's valid for unlimited requests until it expires.
+// It's stored with the key returned by getPassKey.
+// The type itself is not exported, but the fields have to be (for (un-)marshaling).
+type accessPass struct {
+	ExpiresAt time.Time
+	// The patterns of the paths that the pass is valid for. Empty if it's valid for all paths.
+	Paths []string
+}
+
+// getPassKey returns the storage key for the pass of the given pass token.
+func getPassKey(token string) string {
+	return "pass:" + hashToken(token)
+}
+
+// coversPath returns true if the given URL path matches one of the path patterns,
+// or if there are no path patterns.
+func coversPath(pathPatterns []string, urlPath string) bool {
+	if len(pathPatterns) == 0 {
+		return true
+	}
+	for _, pathPattern := range pathPatterns {
+		// The only possible error is path.ErrBadPattern, in which case the pattern doesn't match any path.
+		if matched, _ := path.Match(pathPattern, urlPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePassToken checks if the pass of the given pass token is valid for the current request.
+// If the pass doesn't exist or expired, it responds with an invoice for a new pass.
+func handlePassToken(fa frameworkAbstraction, token string, invoiceOptions InvoiceOptions, middlewareOptions MiddlewareOptions, lnClient LNclient, storageClient StorageClient) error {
+	pass := new(accessPass)
+	found, err := storageClient.Get(getPassKey(token), pass)
+	if err != nil {
+		errorMsg := fmt.Sprintf("An error occurred during checking the pass: %+v", err)
+		middlewareOptions.Logger.Error("Couldn't check the pass", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+	if !found || time.Now().After(pass.ExpiresAt) || !coversPath(pass.Paths, fa.getHTTPrequest().URL.Path) {
+		middlewareOptions.Logger.Info("The pass doesn't exist, expired or isn't valid for the path, sending invoice for a new pass")
+		reportRejection(fa, "The pass doesn't exist, expired or isn't valid for the path", invoiceOptions, middlewareOptions)
+		respondWithNewInvoice(fa, invoiceOptions, middlewareOptions, lnClient, storageClient, "")
+		return nil
+	}
+
+	middlewareOptions.Logger.Info("The pass is valid, continuing to the next handler", "outcome", "accepted", "expires_at", pass.ExpiresAt)
+	fa.setResponseHeader("X-Pass-Expires", pass.ExpiresAt.Format(time.RFC3339))
+	return fa.next()
+}
+
+// redeemPass creates a new pass for an invoice that was paid for a pass and sends its token to the client.
+func redeemPass(fa frameworkAbstraction, metaData *invoiceMetaData, middlewareOptions MiddlewareOptions, storageClient StorageClient) error {
+	logger := middlewareOptions.Logger
+	token, err := newToken()
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't generate pass token: %+v", err)
+		logger.Error("Couldn't generate pass token", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+	pass := accessPass{
+		// The pass is valid from the time of the redemption, not the time the invoice was created.
+		ExpiresAt: time.Now().Add(metaData.PassDuration),
+		Paths:     middlewareOptions.Pass.Paths,
+	}
+	// Passes aren't required anymore after they expired
+	if extendedStorageClient, ok := storageClient.(ExtendedStorageClient); ok {
+		err = extendedStorageClient.SetWithTTL(getPassKey(token), pass, metaData.PassDuration)
+	} else {
+		err = storageClient.Set(getPassKey(token), pass)
+	}
+	if err != nil {
+		errorMsg := fmt.Sprintf("An error occurred during storing the pass: %+v", err)
+		logger.Error("Couldn't store the pass", "outcome", "error", "error", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return nil
+	}
+
+	logger.Info("Created a pass, continuing to the next handler", "outcome", "redeemed", "price", metaData.Price, "expires_at", pass.ExpiresAt)
+	fa.setResponseHeader("X-Pass-Token", token)
+	setTokenCookie(fa, middlewareOptions.HTML, passCookieSuffix, token, metaData.PassDuration)
+	fa.setResponseHeader("X-Pass-Expires", pass.ExpiresAt.Format(time.RFC3339))
+	return fa.next()
+}
@@ -0,0 +1,95 @@
+package wall_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// basicStorageClient is a wall.StorageClient that only has the methods of the StorageClient interface,
+// so the middleware can't use the methods of AtomicStorageClient and ExtendedStorageClient.
+type basicStorageClient struct {
+	storageClient wall.StorageClient
+}
+
+func (c basicStorageClient) Set(k string, v interface{}) error {
+	return c.storageClient.Set(k, v)
+}
+
+func (c basicStorageClient) Get(k string, v interface{}) (bool, error) {
+	return c.storageClient.Get(k, v)
+}
+
+// TestPass tests buying a pass, using it during its time window and for the paths it's valid for,
+// and if it's rejected afterwards, both with a storage client that supports TTLs and one that doesn't.
+func TestPass(t *testing.T) {
+	storageClients := map[string]wall.StorageClient{
+		"extended": storage.NewGoMap(),
+		"basic":    basicStorageClient{storage.NewGoMap()},
+	}
+	for name, storageClient := range storageClients {
+		t.Run(name, func(t *testing.T) {
+			node := newTestNode(t)
+			passDuration := 500 * time.Millisecond
+			middlewareOptions := wall.DefaultMiddlewareOptions
+			middlewareOptions.Pass = &wall.PassOptions{Duration: passDuration, Paths: []string{"/images/*"}}
+			handlerFunc := wall.NewHandlerFuncMiddleware(wall.DefaultInvoiceOptions, node, storageClient, middlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("pong"))
+			})
+			send := func(path string, token string, preimage string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", path, nil)
+				req.Header.Set("X-Pass-Token", token)
+				req.Header.Set("X-Preimage", preimage)
+				res := httptest.NewRecorder()
+				handlerFunc(res, req)
+				return res
+			}
+
+			// Buy the pass
+			res := send("/images/1", "", "")
+			if res.Code != http.StatusPaymentRequired {
+				t.Fatalf("Expected status code %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+			}
+			preimage, err := node.Pay(res.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			boughtAt := time.Now()
+			res = send("/images/1", "", preimage)
+			token := res.Header().Get("X-Pass-Token")
+			if res.Code != http.StatusOK || token == "" || res.Header().Get("X-Pass-Expires") == "" {
+				t.Fatalf("Expected (%v, a token and expiry), but was (%v, %v, %v)\n", http.StatusOK, res.Code, token, res.Header().Get("X-Pass-Expires"))
+			}
+
+			// The pass is valid for all paths that match the patterns
+			for _, path := range []string{"/images/1", "/images/2"} {
+				res = send(path, token, "")
+				if res.Code != http.StatusOK || res.Body.String() != "pong" {
+					t.Errorf("Expected (%v, %v) for %v, but was (%v, %v)\n", http.StatusOK, "pong", path, res.Code, res.Body.String())
+				}
+			}
+			// Other paths require a payment, as usual
+			for _, path := range []string{"/videos/1", "/images/1/large"} {
+				res = send(path, token, "")
+				if res.Code != http.StatusPaymentRequired {
+					t.Errorf("Expected status code %v for %v, but was %v\n", http.StatusPaymentRequired, path, res.Code)
+				}
+			}
+
+			// The pass is rejected after it expired, but not before
+			deadline := boughtAt.Add(passDuration + 5*time.Second)
+			for res = send("/images/1", token, ""); res.Code == http.StatusOK && time.Now().Before(deadline); res = send("/images/1", token, "") {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if res.Code != http.StatusPaymentRequired {
+				t.Errorf("Expected status code %v, but was %v\n", http.StatusPaymentRequired, res.Code)
+			} else if elapsed := time.Since(boughtAt); elapsed < passDuration {
+				t.Errorf("Expected the pass to be rejected after %v, but it was rejected after %v\n", passDuration, elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package wall_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/philippgille/ln-paywall/storage"
+	"github.com/philippgille/ln-paywall/wall"
+)
+
+// TestPricingTable tests if the first matching entry of the pricing table determines the price and memo,
+// and if the default price and memo are used for the fields that the entry doesn't set and for requests that no entry matches.
+func TestPricingTable(t *testing.T) {
+	invoiceOptions := wall.DefaultInvoiceOptions
+	invoiceOptions.Price = 5
+	invoiceOptions.Memo = "Default"
+	invoiceOptions.PricingTable = []wall.RoutePrice{
+		{Method: "POST", Path: "/images/*", Price: 100, Memo: "Upload"},
+		{Path: "/images/special", Price: 50},
+		{Path: "/images/*", Price: 10, Memo: "Image"},
+		{Path: "/videos/*", Memo: "Video"},
+		// Invalid pattern, which doesn't match any request
+		{Path: "[", Price: 1000},
+	}
+	handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, newTestNode(t), storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	testCases := []struct {
+		method        string
+		path          string
+		expectedPrice int64
+		expectedMemo  string
+	}{
+		{"POST", "/images/special", 100, "Upload"},
+		{"GET", "/images/special", 50, "Default"},
+		{"GET", "/images/foo", 10, "Image"},
+		{"PUT", "/images/foo", 10, "Image"},
+		// "*" doesn't match "/"
+		{"GET", "/images/foo/bar", 5, "Default"},
+		{"GET", "/videos/1", 5, "Video"},
+		{"GET", "/", 5, "Default"},
+		{"GET", "/[", 5, "Default"},
+	}
+	for _, testCase := range testCases {
+		invoice, _, _ := getJSONinvoice(t, handlerFunc, testCase.method, testCase.path)
+		if invoice.Amount != testCase.expectedPrice || invoice.Memo != testCase.expectedMemo {
+			t.Errorf("Expected (%v, %v) for %v %v, but was (%v, %v)\n", testCase.expectedPrice, testCase.expectedMemo,
+				testCase.method, testCase.path, invoice.Amount, invoice.Memo)
+		}
+	}
+}
+
+// TestPricingFunc tests if the pricing function takes precedence over the pricing table,
+// and if its rejections and errors lead to the expected responses.
+func TestPricingFunc(t *testing.T) {
+	node := newTestNode(t)
+	testCases := []struct {
+		pricingFunc    wall.PricingFunc
+		expectedStatus int
+		expectedBody   string
+	}{
+		{func(*http.Request) (int64, string, error) {
+			return 42, "Dynamic", nil
+		}, http.StatusPaymentRequired, ""},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", wall.PricingRejection{Message: "Invalid size"}
+		}, http.StatusBadRequest, `{"code":"pricing_rejected","message":"Invalid size"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", wall.PricingRejection{StatusCode: http.StatusRequestEntityTooLarge, Message: "Too large"}
+		}, http.StatusRequestEntityTooLarge, `{"code":"pricing_rejected","message":"Too large"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", fmt.Errorf("checking the size: %w", wall.PricingRejection{Message: "Invalid size"})
+		}, http.StatusBadRequest, `{"code":"pricing_rejected","message":"Invalid size"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", &wall.PricingRejection{StatusCode: http.StatusRequestEntityTooLarge, Message: "Too large"}
+		}, http.StatusRequestEntityTooLarge, `{"code":"pricing_rejected","message":"Too large"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", fmt.Errorf("checking the size: %w", &wall.PricingRejection{Message: "Invalid size"})
+		}, http.StatusBadRequest, `{"code":"pricing_rejected","message":"Invalid size"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "", errors.New("database unavailable")
+		}, http.StatusInternalServerError, `{"code":"internal_error","message":"Couldn't determine the price: database unavailable"}`},
+		{func(*http.Request) (int64, string, error) {
+			return 0, "Free", nil
+		}, http.StatusInternalServerError, `{"code":"internal_error","message":"Couldn't determine the price: The pricing function returned an invalid price: 0"}`},
+		{func(*http.Request) (int64, string, error) {
+			return -1, "Negative", nil
+		}, http.StatusInternalServerError, `{"code":"internal_error","message":"Couldn't determine the price: The pricing function returned an invalid price: -1"}`},
+	}
+	for _, testCase := range testCases {
+		invoiceOptions := wall.DefaultInvoiceOptions
+		invoiceOptions.PricingTable = []wall.RoutePrice{{Path: "/*", Price: 10, Memo: "Table"}}
+		invoiceOptions.PricingFunc = testCase.pricingFunc
+		handlerFunc := wall.NewHandlerFuncMiddleware(invoiceOptions, node, storage.NewGoMap(), wall.DefaultMiddlewareOptions)(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("pong"))
+		})
+
+		invoice, status, body := getJSONinvoice(t, handlerFunc, "GET", "/items")
+		if status != testCase.expectedStatus {
+			t.Errorf("Expected status code %v, but was %v\n", testCase.expectedStatus, status)
+		} else if status == http.StatusPaymentRequired && (invoice.Amount != 42 || invoice.Memo != "Dynamic") {
+			t.Errorf("Expected (%v, %v), but was (%v, %v)\n", 42, "Dynamic", invoice.Amount, invoice.Memo)
+		} else if status != http.StatusPaymentRequired && body != testCase.expectedBody {
+			t.Errorf("Expected %v, but was %v\n", testCase.expectedBody, body)
+		}
+	}
+}
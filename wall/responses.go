@@ -0,0 +1,124 @@
+package wall
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/philippgille/ln-paywall/ln"
+)
+
+// ErrorCode is a stable, machine-readable code for the reason of an error response.
+// Unlike the error messages, which are meant for humans and can change, the codes can be relied on by clients.
+// They're sent in the JSON error responses, which the middleware sends when the request's "Accept" header contains "application/json".
+type ErrorCode string
+
+// Error codes that are sent in JSON error responses.
+const (
+	// ErrorCodeInternalError means that a server-side error occurred, like the LN node or storage not being reachable.
+	ErrorCodeInternalError ErrorCode = "internal_error"
+	// ErrorCodeInvalidRequest means that the request couldn't be read, for example because the body was cut off.
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrorCodePricingRejected means that the PricingFunc rejected the request.
+	ErrorCodePricingRejected ErrorCode = "pricing_rejected"
+	// ErrorCodePreimageMalformed means that the preimage isn't a hex encoded 32 byte value.
+	ErrorCodePreimageMalformed ErrorCode = "preimage_malformed"
+	// ErrorCodePreimageUnknown means that no invoice was issued for the preimage, or its metadata was already deleted.
+	ErrorCodePreimageUnknown ErrorCode = "preimage_unknown"
+	// ErrorCodeMethodMismatch means that the invoice was created for a request with a different HTTP method.
+	ErrorCodeMethodMismatch ErrorCode = "method_mismatch"
+	// ErrorCodePathMismatch means that the invoice was created for a request with a different URL path.
+	ErrorCodePathMismatch ErrorCode = "path_mismatch"
+	// ErrorCodeRequestMismatch means that the invoice was created for a request with a different query string, headers or body
+	// (see InvoiceOptions.BindRequest).
+	ErrorCodeRequestMismatch ErrorCode = "request_mismatch"
+	// ErrorCodePreimageAlreadyUsed means that the preimage was already used in a previous request.
+	ErrorCodePreimageAlreadyUsed ErrorCode = "preimage_already_used"
+	// ErrorCodeInvoiceNotFound means that the LN node doesn't know the invoice of the preimage.
+	ErrorCodeInvoiceNotFound ErrorCode = "invoice_not_found"
+	// ErrorCodeInvoiceExpired means that the invoice expired before it was paid.
+	ErrorCodeInvoiceExpired ErrorCode = "invoice_expired"
+	// ErrorCodeInvoiceUnsettled means that the invoice isn't paid yet.
+	ErrorCodeInvoiceUnsettled ErrorCode = "invoice_unsettled"
+	// ErrorCodeCredentialMalformed means that the L402 credential or its macaroon isn't properly formatted.
+	ErrorCodeCredentialMalformed ErrorCode = "credential_malformed"
+	// ErrorCodeCredentialInvalid means that the L402 macaroon isn't valid for the request (for example because it expired),
+	// or that the preimage doesn't belong to its invoice.
+	ErrorCodeCredentialInvalid ErrorCode = "credential_invalid"
+	// ErrorCodeCredentialUnknown means that no invoice metadata was found for a single-use L402 credential.
+	ErrorCodeCredentialUnknown ErrorCode = "credential_unknown"
+	// ErrorCodeCredentialAlreadyUsed means that the single-use L402 credential was already used in a previous request.
+	ErrorCodeCredentialAlreadyUsed ErrorCode = "credential_already_used"
+)
+
+// errorResponse is the JSON body of error responses.
+type errorResponse struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// invoiceResponse is the JSON body of "402 Payment Required" responses.
+type invoiceResponse struct {
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	// Amount in Satoshis.
+	Amount    int64     `json:"amount"`
+	Memo      string    `json:"memo"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sendError sends an error response with the given code, message and status code.
+// The response is a JSON object if the client accepts JSON, and plain text otherwise.
+func sendError(fa frameworkAbstraction, err error, code ErrorCode, errorMsg string, statusCode int) {
+	if !acceptsMediaType(fa.getHTTPrequest(), "application/json") {
+		fa.respondWithError(err, errorMsg, statusCode)
+		return
+	}
+	// Marshalling a struct with only strings can't fail
+	body, _ := json.Marshal(errorResponse{Code: code, Message: errorMsg})
+	headers := map[string]string{"Content-Type": "application/json"}
+	fa.respond(headers, statusCode, body)
+}
+
+// sendInvoice sends a "402 Payment Required" response with the given headers and invoice.
+// The response is a JSON object with the invoice details if the client accepts JSON,
+// and the plain payment request otherwise.
+func sendInvoice(fa frameworkAbstraction, headers map[string]string, invoice ln.Invoice, price int64, memo string, expiresAt time.Time) {
+	if !acceptsMediaType(fa.getHTTPrequest(), "application/json") {
+		headers["Content-Type"] = "application/vnd.lightning.bolt11"
+		fa.respond(headers, http.StatusPaymentRequired, []byte(invoice.PaymentRequest))
+		return
+	}
+	body, err := json.Marshal(invoiceResponse{
+		Invoice:     invoice.PaymentRequest,
+		PaymentHash: invoice.PaymentHash,
+		Amount:      price,
+		Memo:        memo,
+		ExpiresAt:   expiresAt.UTC(),
+	})
+	if err != nil {
+		errorMsg := fmt.Sprintf("Couldn't encode the invoice: %+v", err)
+		sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	headers["Content-Type"] = "application/json"
+	fa.respond(headers, http.StatusPaymentRequired, body)
+}
+
+// acceptsMediaType returns true if the "Accept" header of the request explicitly contains the given media type.
+// Wildcards like "*/*" don't count, so clients that accept anything get the default response format.
+func acceptsMediaType(req *http.Request, mediaType string) bool {
+	for _, header := range req.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(header, ",") {
+			// Quality values other than 0 are ignored. Invalid media ranges lead to an empty media type.
+			accepted, params, _ := mime.ParseMediaType(mediaRange)
+			if accepted == mediaType && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package wall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/philippgille/ln-paywall/internal/logging"
+)
+
+// InvoiceStatus is the status of an invoice, as reported by the status handler.
+type InvoiceStatus string
+
+// Statuses that the status handler reports.
+const (
+	// InvoiceStatusUnpaid means that the invoice isn't paid yet, but can still be paid.
+	InvoiceStatusUnpaid InvoiceStatus = "unpaid"
+	// InvoiceStatusPaid means that the invoice is paid, but wasn't redeemed yet.
+	InvoiceStatusPaid InvoiceStatus = "paid"
+	// InvoiceStatusExpired means that the invoice expired before it was paid.
+	InvoiceStatusExpired InvoiceStatus = "expired"
+	// InvoiceStatusRedeemed means that the invoice was paid and redeemed.
+	InvoiceStatusRedeemed InvoiceStatus = "redeemed"
+	// InvoiceStatusUnknown means that the invoice's metadata was deleted while the status handler was waiting for a change,
+	// for example because the redemption window ended (see InvoiceOptions.RedemptionWindow).
+	// The status can't be determined anymore then, because the metadata is required for checking the invoice on the LN node.
+	InvoiceStatusUnknown InvoiceStatus = "unknown"
+)
+
+// StatusOptions are the options for the status handler.
+type StatusOptions struct {
+	// Maximum duration of a single call to the LN node.
+	// Values below 1 millisecond are automatically changed to the default value.
+	// Optional (30 seconds by default).
+	LNtimeout time.Duration
+	// Maximum duration that a long-polling request waits for the invoice to be paid.
+	// Longer durations in the "wait" query parameter are shortened to this value.
+	// Values below 1 second are automatically changed to the default value.
+	// Optional (30 seconds by default).
+	MaxWait time.Duration
+	// Interval in which the invoice status is checked while a long-polling request or event stream is open.
+	// Each check is a call to the storage and (unless the invoice was redeemed) the LN node.
+	// Values below 1 millisecond are automatically changed to the default value.
+	// Optional (1 second by default).
+	PollInterval time.Duration
+	// Logger for the handler's structured log entries.
+	// Optional (slog.Default() by default).
+	Logger *slog.Logger
+	// Log sensitive values as is, like payment hashes.
+	// Optional (false by default).
+	LogSensitiveValues bool
+}
+
+// DefaultStatusOptions provides default values for StatusOptions.
+var DefaultStatusOptions = StatusOptions{
+	LNtimeout:    30 * time.Second,
+	MaxWait:      30 * time.Second,
+	PollInterval: time.Second,
+}
+
+// statusResponse is the JSON body of the status handler's responses.
+type statusResponse struct {
+	Status InvoiceStatus `json:"status"`
+}
+
+// NewStatusHandler returns an http.HandlerFunc that reports the status of an invoice that the middleware generated.
+// The hex encoded payment hash of the invoice is taken from the "payment_hash" query parameter,
+// and the response is a JSON object like {"status":"paid"} (see InvoiceStatus).
+// The LN client and storage client must be the same as the ones of the middleware.
+//
+// Clients can wait for the payment in two ways, instead of sending requests in a loop:
+//
+// - Long-polling: With the "wait" query parameter, for example "wait=30", the response is delayed
+// until the invoice isn't unpaid anymore, but at most for the given number of seconds (see StatusOptions.MaxWait).
+// If the invoice is still unpaid afterwards, the response contains the "unpaid" status.
+//
+// - Server-Sent Events: When the request's "Accept" header contains "text/event-stream", the response is an event stream
+// with one event for the current status and one for each change, with the same JSON object as data.
+// The stream ends when the invoice expired or was redeemed, or when its status is unknown. Clients should close the connection then,
+// because for example the browser's EventSource reconnects automatically otherwise.
+//
+// The handler must be mounted without the middleware in front of it.
+// It's used by the HTML paywall page (see HTMLOptions), but can also be used by other clients,
+// for example a single-page app that shows the invoice itself.
+func NewStatusHandler(lnClient LNclient, storageClient StorageClient, statusOptions StatusOptions) http.HandlerFunc {
+	statusOptions = assignStatusDefaultValues(statusOptions)
+	return func(w http.ResponseWriter, r *http.Request) {
+		fa := stdlibHTTP{w: w, r: r}
+		lnClient := requestLNclient{
+			ctx:      r.Context(),
+			timeout:  statusOptions.LNtimeout,
+			lnClient: lnClient,
+			metrics:  noopMetrics{},
+		}
+		paymentHash := r.URL.Query().Get("payment_hash")
+		logger := statusOptions.Logger.With(logging.Identifier("payment_hash", paymentHash, statusOptions.LogSensitiveValues))
+
+		// The payment hash has the same format as a preimage
+		if validatePreimageFormat(paymentHash).reason != "" {
+			errorMsg := "The \"payment_hash\" query parameter must be a hex encoded 32 byte value"
+			sendError(fa, nil, ErrorCodeInvalidRequest, errorMsg, http.StatusBadRequest)
+			return
+		}
+		wait, err := getWait(r, statusOptions.MaxWait)
+		if err != nil {
+			errorMsg := "The \"wait\" query parameter must be a non-negative number of seconds"
+			sendError(fa, err, ErrorCodeInvalidRequest, errorMsg, http.StatusBadRequest)
+			return
+		}
+		status, found, err := getInvoiceStatus(paymentHash, lnClient, storageClient)
+		if err != nil {
+			errorMsg := fmt.Sprintf("Couldn't get the invoice status: %+v", err)
+			logger.Error("Couldn't get the invoice status", "error", err)
+			sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+			return
+		} else if !found {
+			errorMsg := "No invoice with the given payment hash was found"
+			sendError(fa, nil, ErrorCodeInvoiceNotFound, errorMsg, http.StatusNotFound)
+			return
+		}
+
+		if acceptsMediaType(r, "text/event-stream") {
+			streamInvoiceStatus(fa, paymentHash, status, lnClient, storageClient, statusOptions, logger)
+			return
+		}
+		if wait > 0 && status == InvoiceStatusUnpaid {
+			// Long-polling
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			status, err = waitForInvoiceStatus(ctx, paymentHash, status, statusOptions.PollInterval, lnClient, storageClient)
+			cancel()
+			if r.Context().Err() != nil {
+				// The client cancelled its request, so there's no one to respond to
+				return
+			} else if err != nil && err != context.DeadlineExceeded {
+				errorMsg := fmt.Sprintf("Couldn't get the invoice status: %+v", err)
+				logger.Error("Couldn't get the invoice status", "error", err)
+				sendError(fa, err, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// Marshalling a struct with only strings can't fail
+		body, _ := json.Marshal(statusResponse{Status: status})
+		headers := map[string]string{
+			"Content-Type":  "application/json",
+			"Cache-Control": "no-store",
+		}
+		fa.respond(headers, http.StatusOK, body)
+	}
+}
+
+// getInvoiceStatus returns the status of the invoice with the given payment hash.
+// Returns false if no metadata was found for the invoice.
+func getInvoiceStatus(paymentHash string, lnClient LNclient, storageClient StorageClient) (InvoiceStatus, bool, error) {
+	metaData := new(invoiceMetaData)
+	found, err := storageClient.Get(paymentHash, metaData)
+	if err != nil || !found {
+		return "", found, err
+	}
+	if metaData.Used {
+		return InvoiceStatusRedeemed, true, nil
+	}
+	settled, err := lnClient.CheckInvoice(metaData.ImplDepID)
+	if err != nil {
+		return "", true, err
+	}
+	if settled {
+		return InvoiceStatusPaid, true, nil
+	} else if !metaData.ExpiresAt.IsZero() && time.Now().After(metaData.ExpiresAt) {
+		return InvoiceStatusExpired, true, nil
+	}
+	return InvoiceStatusUnpaid, true, nil
+}
+
+// waitForInvoiceStatus checks the status of the invoice with the given payment hash in the given interval
+// and returns it as soon as it differs from the given status.
+// If the context is done before, the given status and the context's error are returned.
+func waitForInvoiceStatus(ctx context.Context, paymentHash string, status InvoiceStatus, pollInterval time.Duration, lnClient LNclient, storageClient StorageClient) (InvoiceStatus, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+			newStatus, found, err := getInvoiceStatus(paymentHash, lnClient, storageClient)
+			if err != nil {
+				return status, err
+			} else if !found {
+				// The invoice can't be checked on the LN node without the ID in its metadata
+				newStatus = InvoiceStatusUnknown
+			}
+			if newStatus != status {
+				return newStatus, nil
+			}
+		}
+	}
+}
+
+// streamInvoiceStatus sends the given status and each change of the status as Server-Sent Events,
+// until the invoice expired or was redeemed, its status is unknown, or the client cancelled its request.
+func streamInvoiceStatus(fa stdlibHTTP, paymentHash string, status InvoiceStatus, lnClient LNclient, storageClient StorageClient, statusOptions StatusOptions, logger *slog.Logger) {
+	flusher, ok := fa.w.(http.Flusher)
+	if !ok {
+		errorMsg := "The response writer doesn't support streaming"
+		logger.Error(errorMsg)
+		sendError(fa, nil, ErrorCodeInternalError, errorMsg, http.StatusInternalServerError)
+		return
+	}
+	fa.w.Header().Set("Content-Type", "text/event-stream")
+	fa.w.Header().Set("Cache-Control", "no-store")
+	fa.w.WriteHeader(http.StatusOK)
+	for {
+		// Marshalling a struct with only strings can't fail
+		data, _ := json.Marshal(statusResponse{Status: status})
+		_, err := fmt.Fprintf(fa.w, "data: %s\n\n", data)
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		if status == InvoiceStatusExpired || status == InvoiceStatusRedeemed || status == InvoiceStatusUnknown {
+			return
+		}
+		status, err = waitForInvoiceStatus(fa.r.Context(), paymentHash, status, statusOptions.PollInterval, lnClient, storageClient)
+		if err != nil {
+			// The stream ends either way. The client can reconnect to get the current status.
+			if fa.r.Context().Err() == nil {
+				logger.Error("Couldn't get the invoice status", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// getWait returns the duration of the "wait" query parameter, shortened to the given maximum.
+// Returns 0 if the request doesn't have the parameter.
+func getWait(req *http.Request, maxWait time.Duration) (time.Duration, error) {
+	waitString := req.URL.Query().Get("wait")
+	if waitString == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(waitString)
+	if err != nil {
+		return 0, err
+	} else if seconds < 0 {
+		return 0, fmt.Errorf("negative wait duration: %v", seconds)
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait, nil
+}
+
+func assignStatusDefaultValues(statusOptions StatusOptions) StatusOptions {
+	if statusOptions.LNtimeout < time.Millisecond {
+		statusOptions.LNtimeout = DefaultStatusOptions.LNtimeout
+	}
+	if statusOptions.MaxWait < time.Second {
+		statusOptions.MaxWait = DefaultStatusOptions.MaxWait
+	}
+	if statusOptions.PollInterval < time.Millisecond {
+		statusOptions.PollInterval = DefaultStatusOptions.PollInterval
+	}
+	if statusOptions.Logger == nil {
+		statusOptions.Logger = slog.Default()
+	}
+
+	return statusOptions
+}
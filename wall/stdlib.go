@@ -5,28 +5,33 @@ import (
 )
 
 // NewHandlerFuncMiddleware returns a function which you can use within an http.HandlerFunc chain.
-func NewHandlerFuncMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient) func(http.HandlerFunc) http.HandlerFunc {
+func NewHandlerFuncMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, middlewareOptions MiddlewareOptions) func(http.HandlerFunc) http.HandlerFunc {
+	// The default values are assigned once, so all wrapped handlers share them (for example a generated L402 root key)
+	invoiceOptions = assignDefaultValues(invoiceOptions)
+	middlewareOptions = assignMiddlewareDefaultValues(middlewareOptions)
 	return func(next http.HandlerFunc) http.HandlerFunc {
-		return createHandlerFunc(invoiceOptions, lnClient, storageClient, next)
+		return createHandlerFunc(invoiceOptions, lnClient, storageClient, middlewareOptions, next)
 	}
 }
 
 // NewHandlerMiddleware returns a function which you can use within an http.Handler chain.
-func NewHandlerMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient) func(http.Handler) http.Handler {
+func NewHandlerMiddleware(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, middlewareOptions MiddlewareOptions) func(http.Handler) http.Handler {
+	invoiceOptions = assignDefaultValues(invoiceOptions)
+	middlewareOptions = assignMiddlewareDefaultValues(middlewareOptions)
 	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(createHandlerFunc(invoiceOptions, lnClient, storageClient, next.ServeHTTP))
+		return http.HandlerFunc(createHandlerFunc(invoiceOptions, lnClient, storageClient, middlewareOptions, next.ServeHTTP))
 	}
 }
 
-func createHandlerFunc(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, next http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
-	invoiceOptions = assignDefaultValues(invoiceOptions)
+// createHandlerFunc expects options to which the default values were already assigned.
+func createHandlerFunc(invoiceOptions InvoiceOptions, lnClient LNclient, storageClient StorageClient, middlewareOptions MiddlewareOptions, next http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fa := stdlibHTTP{
 			w:           w,
 			r:           r,
 			nextHandler: next,
 		}
-		commonHandler(fa, invoiceOptions, lnClient, storageClient)
+		commonHandler(fa, invoiceOptions, middlewareOptions, lnClient, storageClient)
 	}
 }
 
@@ -48,17 +53,24 @@ func (fa stdlibHTTP) getHTTPrequest() *http.Request {
 	return fa.r
 }
 
-func (fa stdlibHTTP) respondWithInvoice(headers map[string]string, statusCode int, body []byte) {
+func (fa stdlibHTTP) respond(headers map[string]string, statusCode int, body []byte) {
 	// Note: w.Header().Set(...) must be called before w.WriteHeader(...)!
 	for k, v := range headers {
 		fa.w.Header().Set(k, v)
 	}
 	// Status code
 	fa.w.WriteHeader(statusCode)
-	// The actual invoice goes into the body
 	fa.w.Write(body)
 }
 
+func (fa stdlibHTTP) setResponseHeader(key string, value string) {
+	fa.w.Header().Set(key, value)
+}
+
+func (fa stdlibHTTP) setCookie(cookie *http.Cookie) {
+	http.SetCookie(fa.w, cookie)
+}
+
 func (fa stdlibHTTP) next() error {
 	fa.nextHandler.ServeHTTP(fa.w, fa.r)
 	return nil